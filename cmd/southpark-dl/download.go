@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/xypwn/southpark-downloader-ui/internal/logic"
+	"github.com/xypwn/southpark-downloader-ui/pkg/data"
+	sp "github.com/xypwn/southpark-downloader-ui/pkg/southpark"
+)
+
+// Resolves URLs and selection expressions to episodes. All arguments
+// that aren't URLs are treated as one selection expression. The series
+// is only fetched if there is a selection.
+func resolveEpisodes(ctx context.Context, opts options, args []string) ([]sp.Episode, error) {
+	extraHosts, err := opts.Cfg.CustomHostDefinitions()
+	if err != nil {
+		return nil, err
+	}
+
+	var res []sp.Episode
+	var exprs []string
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "http://") || strings.HasPrefix(arg, "https://") {
+			ep, err := logic.GetEpisodeByURL(ctx, arg, extraHosts)
+			if err != nil {
+				return nil, fmt.Errorf("%v: %w", arg, err)
+			}
+			res = append(res, ep)
+		} else {
+			exprs = append(exprs, arg)
+		}
+	}
+
+	if len(exprs) > 0 {
+		eps, err := resolveSelection(ctx, opts, strings.Join(exprs, " "))
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, eps...)
+	}
+	return res, nil
+}
+
+func download(ctx context.Context, opts options, args []string) error {
+	eps, err := resolveEpisodes(ctx, opts, args)
+	if err != nil {
+		return err
+	}
+	return downloadEpisodes(ctx, opts, eps)
+}
+
+// Downloads the episodes listed in a file (see logic.ParseImport), or
+// stdin if path is "-". Lines that can't be resolved are reported and
+// the rest is downloaded anyway.
+func importList(ctx context.Context, opts options, path string) error {
+	var text []byte
+	var err error
+	if path == "-" {
+		text, err = io.ReadAll(os.Stdin)
+	} else {
+		text, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return err
+	}
+	entries := logic.ParseImport(string(text))
+
+	importOpts := logic.ImportOptions{
+		Host: opts.Host,
+		TTL:  -1,
+	}
+	importOpts.ExtraHosts, err = opts.Cfg.CustomHostDefinitions()
+	if err != nil {
+		return err
+	}
+
+	cache := data.NewBinding[*logic.Cache]()
+	cacheClient := cache.NewClient()
+	cacheClient.Change(func(*logic.Cache) *logic.Cache {
+		return logic.NewCache()
+	})
+	// Selections need the series and a language. If the series
+	// can't be loaded, only the selections fail.
+	seriesFetched := false
+	var seriesErr error
+	for i, e := range entries {
+		if e.URL != "" || e.Err != nil {
+			continue
+		}
+		if !seriesFetched {
+			var series logic.Series
+			series, importOpts.Language, seriesErr = getSeries(ctx, opts)
+			if seriesErr == nil {
+				cacheClient.Change(func(c *logic.Cache) *logic.Cache {
+					c.SetSeries(series)
+					return c
+				})
+				importOpts.Host = string(series.Region.Host)
+			}
+			seriesFetched = true
+		}
+		entries[i].Err = seriesErr
+	}
+
+	eps, failed := logic.ResolveImport(ctx, cacheClient, entries, importOpts)
+	for _, v := range failed {
+		fmt.Fprintf(os.Stderr, "%v:%v: %v: %v\n", path, v.Line, v.Text, v.Err)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err := downloadEpisodes(ctx, opts, eps); err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("unable to resolve %v of %v lines", len(failed), len(entries))
+	}
+	return nil
+}
+
+func downloadEpisodes(ctx context.Context, opts options, eps []sp.Episode) error {
+	cfgBinding := data.NewBinding[*logic.Config]()
+	cfgClient := cfgBinding.NewClient()
+	cfgClient.Change(func(*logic.Config) *logic.Config {
+		return opts.Cfg
+	})
+
+	var errMtx sync.Mutex
+	var failed []string
+	addFailed := func(name string, err error) {
+		errMtx.Lock()
+		defer errMtx.Unlock()
+		failed = append(failed, name)
+		fmt.Fprintf(os.Stderr, "%v: Error: %v\n", name, err)
+	}
+
+	dls := logic.NewDownloads(cfgClient, func(err error) {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+	})
+
+	var wg sync.WaitGroup
+	for _, ep := range eps {
+		ep := ep
+		name := fmt.Sprintf("S%02vE%02v %v", ep.SeasonNumber, ep.EpisodeNumber, ep.Language.Code())
+		if ep.Unavailable {
+			addFailed(name, errors.New("episode is unavailable"))
+			continue
+		}
+		params := logic.NewEpisodeDownloadParams(opts.Cfg, ep)
+		if params.Downloaded() {
+			fmt.Printf("%v: Already downloaded: %v\n", name, params.PlayablePath())
+			continue
+		}
+
+		wg.Add(1)
+		var once sync.Once
+		done := func() {
+			once.Do(wg.Done)
+		}
+		dl := dls.Add(ctx, params, func(err error) {
+			addFailed(name, err)
+			done()
+		})
+
+		// Prints status changes and progress in steps of 10%
+		progressClient := dl.ProgressBinding().NewClient()
+		lastStatus := logic.DownloadStatus(-1)
+		lastStep := -1
+		progressClient.AddListener(func(p logic.DownloadProgress) {
+			step := int(p.Value * 10)
+			if p.Status == lastStatus && step == lastStep {
+				return
+			}
+			lastStatus, lastStep = p.Status, step
+			switch p.Status {
+			case logic.DownloadStatusDone:
+				fmt.Printf("%v: Done: %v\n", name, params.PlayablePath())
+				done()
+			case logic.DownloadStatusInterrupted:
+				done()
+			default:
+				fmt.Printf("%v: %v\n", name, p)
+			}
+		})
+
+		dl.Go(struct{}{})
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%v of %v downloads failed: %v", len(failed), len(eps), strings.Join(failed, ", "))
+	}
+	return nil
+}
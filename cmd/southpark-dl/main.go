@@ -0,0 +1,300 @@
+// Command line interface for listing, searching and downloading episodes
+// without the GUI.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/xypwn/southpark-downloader-ui/internal/logic"
+	sp "github.com/xypwn/southpark-downloader-ui/pkg/southpark"
+)
+
+const usage = `Usage: southpark-dl [flags] <command> [args]
+
+Commands:
+  seasons                  List all seasons
+  episodes <selection>...  List episodes, e.g. "5", "S05" or "S01-S03,!S02E04"
+  search <query>           Search episodes
+  download <episode>...    Download episodes given as URLs or selections
+  import <file>            Download the URLs and selections listed in a
+                           file, one per line ("-" for stdin)
+  serve [-addr] [-data]    Run a download daemon with a JSON API on
+                           127.0.0.1:8765 (see internal/server)
+
+Selections:
+  5, S05, S05E04           A season or a single episode
+  S01-S05, S20E01-E05      Ranges of seasons or episodes
+  latest, all              The newest episode or all episodes
+  S05:de                   A term in another language than -lang
+  !S20E04                  Excludes episodes, e.g. "S20,!S20E04"
+
+Flags:
+`
+
+type options struct {
+	Cfg      *logic.Config
+	Host     string
+	Language string
+	Limit    int
+	setFlags map[string]bool // Flags given on the command line
+}
+
+// Copies the config values of flags given on the command line to cfg.
+func (o options) applySetFlags(cfg *logic.Config) {
+	o.copySetFlags(cfg, o.Cfg)
+}
+
+// Copies the config values that flags given on the command line
+// stand for from src to dst.
+func (o options) copySetFlags(dst, src *logic.Config) {
+	for name := range o.setFlags {
+		switch name {
+		case "host":
+			dst.Host = src.Host
+		case "quality":
+			dst.MaximumQuality = src.MaximumQuality
+		case "o":
+			dst.DownloadPath = src.DownloadPath
+		case "pattern":
+			dst.OutputFilePattern = src.OutputFilePattern
+		case "j":
+			dst.ConcurrentDownloads = src.ConcurrentDownloads
+		case "hls":
+			dst.OutputFormat = src.OutputFormat
+		}
+	}
+}
+
+func main() {
+	cfg := logic.NewConfig()
+	opts := options{Cfg: cfg}
+
+	flags := flag.NewFlagSet("southpark-dl", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&opts.Host, "host", "", "website host, e.g. www.southpark.de (detected automatically if empty)")
+	flags.StringVar(&opts.Language, "lang", "", "language, e.g. EN or German (first available language if empty)")
+	flags.IntVar(&opts.Limit, "n", 50, "maximum number of search results")
+	quality := flags.String("quality", "best", "maximum video quality, e.g. best, 1080 or 720p")
+	flags.StringVar(&cfg.DownloadPath, "o", cfg.DownloadPath, "output directory")
+	flags.StringVar(&cfg.OutputFilePattern, "pattern", cfg.OutputFilePattern, "output file name pattern ($S season, $E episode, $L language, $T title, $Q quality)")
+	flags.IntVar(&cfg.ConcurrentDownloads, "j", cfg.ConcurrentDownloads, "number of concurrent downloads")
+	hls := flags.Bool("hls", false, "save as a folder with an HLS playlist instead of an MP4 file")
+	flags.Parse(os.Args[1:])
+	opts.setFlags = make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		opts.setFlags[f.Name] = true
+	})
+
+	if q, err := parseQuality(*quality); err != nil {
+		fatal(err)
+	} else {
+		cfg.MaximumQuality = q
+	}
+	if *hls {
+		cfg.OutputFormat = sp.OutputFormatHLS
+	}
+	if cfg.ConcurrentDownloads < 1 {
+		fatal(errors.New("-j must be at least 1"))
+	}
+	cfg.Host = opts.Host
+
+	args := flags.Args()
+	if len(args) == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	var err error
+	switch cmd, args := args[0], args[1:]; cmd {
+	case "seasons":
+		err = listSeasons(ctx, opts)
+	case "episodes":
+		if len(args) == 0 {
+			fatal(errors.New("episodes: expected a selection"))
+		}
+		err = listEpisodes(ctx, opts, strings.Join(args, " "))
+	case "search":
+		if len(args) == 0 {
+			fatal(errors.New("search: expected a query"))
+		}
+		err = search(ctx, opts, strings.Join(args, " "))
+	case "download":
+		if len(args) == 0 {
+			fatal(errors.New("download: expected at least one episode"))
+		}
+		err = download(ctx, opts, args)
+	case "import":
+		if len(args) != 1 {
+			fatal(errors.New("import: expected a file"))
+		}
+		err = importList(ctx, opts, args[0])
+	case "serve":
+		err = serve(ctx, opts, args)
+	default:
+		fatal(fmt.Errorf("unknown command: %v", cmd))
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func fatal(err error) {
+	if _, msg, ok := logic.ShortUserMessage(err); ok {
+		fmt.Fprintln(os.Stderr, "Error:", msg)
+		fmt.Fprintln(os.Stderr, "Details:", err)
+	} else {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+	}
+	os.Exit(1)
+}
+
+func parseQuality(s string) (logic.Quality, error) {
+	if strings.EqualFold(s, "best") {
+		return logic.QualityBest, nil
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(strings.ToLower(s), "p"))
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid quality: %v", s)
+	}
+	return logic.Quality(n), nil
+}
+
+// Returns the series and the language selected by opts.
+func getSeries(ctx context.Context, opts options) (logic.Series, sp.Language, error) {
+	extraHosts, err := opts.Cfg.CustomHostDefinitions()
+	if err != nil {
+		return logic.Series{}, 0, err
+	}
+	series, err := logic.GetSeries(ctx, opts.Host, extraHosts)
+	var langErrs logic.LanguageErrors
+	if errors.As(err, &langErrs) && len(series.Seasons) > 0 {
+		fmt.Fprintln(os.Stderr, "Warning: some languages failed to load:", err)
+	} else if err != nil {
+		return logic.Series{}, 0, err
+	}
+
+	var lang sp.Language
+	if opts.Language == "" {
+		found := false
+		for _, v := range series.Region.AvailableLanguages() {
+			if _, ok := series.Seasons[v]; ok {
+				lang = v
+				found = true
+				break
+			}
+		}
+		if !found {
+			return logic.Series{}, 0, errors.New("no languages available")
+		}
+	} else {
+		var ok bool
+		lang, ok = sp.LanguageFromString(opts.Language)
+		if !ok {
+			return logic.Series{}, 0, fmt.Errorf("unknown language: %v", opts.Language)
+		}
+		if _, ok := series.Seasons[lang]; !ok {
+			var avail []string
+			for _, v := range series.Region.AvailableLanguages() {
+				avail = append(avail, v.Code())
+			}
+			return logic.Series{}, 0, fmt.Errorf("language %v not available on %v (available: %v)", lang, series.Region.Host, strings.Join(avail, ", "))
+		}
+	}
+	return series, lang, nil
+}
+
+func listSeasons(ctx context.Context, opts options) error {
+	series, lang, err := getSeries(ctx, opts)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, v := range series.Seasons[lang] {
+		fmt.Fprintf(w, "S%02v\t%v\t%v\n", v.SeasonNumber, v.Title, v.URL)
+	}
+	return w.Flush()
+}
+
+func printEpisodes(eps []sp.EpisodeMetadata) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, v := range eps {
+		var unavailable string
+		if v.Unavailable {
+			unavailable = "(unavailable)"
+		}
+		fmt.Fprintf(w, "S%02vE%02v\t%v\t%v\t%v\t%v\n", v.SeasonNumber, v.EpisodeNumber, v.Language.Code(), v.Title, unavailable, v.URL)
+	}
+	return w.Flush()
+}
+
+func resolveSelection(ctx context.Context, opts options, expr string) ([]sp.Episode, error) {
+	sel, err := sp.ParseSelection(expr)
+	if err != nil {
+		return nil, err
+	}
+	series, lang, err := getSeries(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	eps, err := logic.ResolveSelection(ctx, nil, series, sel, lang, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(eps) == 0 {
+		return nil, fmt.Errorf("no episodes match \"%v\"", expr)
+	}
+	return eps, nil
+}
+
+func listEpisodes(ctx context.Context, opts options, expr string) error {
+	eps, err := resolveSelection(ctx, opts, expr)
+	if err != nil {
+		return err
+	}
+	metas := make([]sp.EpisodeMetadata, len(eps))
+	for i, v := range eps {
+		metas[i] = v.EpisodeMetadata
+	}
+	return printEpisodes(metas)
+}
+
+func search(ctx context.Context, opts options, query string) error {
+	series, lang, err := getSeries(ctx, opts)
+	if err != nil {
+		return err
+	}
+	it := sp.NewSearchIterator(series.Region, series.SeriesMGID(lang), query, 35)
+	it.Filter = func(e sp.EpisodeMetadata) bool {
+		return e.Language == lang
+	}
+	var results []sp.EpisodeMetadata
+	for !it.Done() && len(results) < opts.Limit {
+		res, err := it.Next(ctx)
+		if err != nil {
+			return err
+		}
+		results = append(results, res...)
+	}
+	if len(results) > opts.Limit {
+		results = results[:opts.Limit]
+	}
+	if len(results) == 0 {
+		fmt.Fprintln(os.Stderr, "No results")
+		return nil
+	}
+	return printEpisodes(results)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/xypwn/southpark-downloader-ui/internal/logic"
+	"github.com/xypwn/southpark-downloader-ui/internal/server"
+	"github.com/xypwn/southpark-downloader-ui/pkg/data"
+)
+
+// Runs the download daemon until ctx is done. The config, cache, queue
+// and subscriptions are kept in a data directory, so they persist across
+// restarts. Flags given before the command override the saved config
+// until the daemon stops, without being saved. Config changes made
+// through the API are saved, except to the settings given as flags.
+func serve(ctx context.Context, opts options, args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", "127.0.0.1:8765", "address to listen on")
+	dataDir := flags.String("data", "", "directory for the config, cache and queue (user config directory if empty)")
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		return fmt.Errorf("serve: unexpected argument: %v", flags.Arg(0))
+	}
+
+	if *dataDir == "" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return err
+		}
+		*dataDir = filepath.Join(dir, "southpark-dl")
+	}
+	if host, _, err := net.SplitHostPort(*addr); err != nil {
+		return err
+	} else if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		fmt.Fprintln(os.Stderr, "Warning: anyone who can reach", *addr, "can control downloads")
+	}
+
+	storage, err := logic.NewStorage(*dataDir)
+	if err != nil {
+		return err
+	}
+	// Saving is retried on the next change, so it's not fatal
+	onStorageError := func(err error) {
+		fmt.Fprintln(os.Stderr, "Error: save:", err)
+	}
+	cfgStor, err := logic.NewStorageItem(storage, "config", logic.NewConfig, onStorageError)
+	if err != nil {
+		return err
+	}
+	cacheStor, err := logic.NewStorageItem(storage, "cache", logic.NewCache, onStorageError)
+	if err != nil {
+		return err
+	}
+	dlInfoStor, err := logic.NewStorageItem(storage, "downloads", logic.NewDownloadsInfo, onStorageError)
+	if err != nil {
+		return err
+	}
+	subsStor, err := logic.NewStorageItem(storage, "subscriptions", logic.NewSubscriptions, onStorageError)
+	if err != nil {
+		return err
+	}
+
+	// The running config is the saved one with flags applied on top
+	cfgStorClient := cfgStor.NewClient()
+	cfgBinding := data.NewBinding[*logic.Config]()
+	cfgClient := cfgBinding.NewClient()
+	cfgStorClient.Examine(func(c *logic.Config) {
+		cfg := *c
+		opts.applySetFlags(&cfg)
+		cfgClient.Change(func(*logic.Config) *logic.Config {
+			return &cfg
+		})
+	})
+	cfgClient.AddListener(func(c *logic.Config) {
+		cfgStorClient.Change(func(saved *logic.Config) *logic.Config {
+			cfg := *c
+			opts.copySetFlags(&cfg, saved)
+			return &cfg
+		})
+	})
+
+	onError := func(err error) {
+		if _, msg, ok := logic.ShortUserMessage(err); ok {
+			fmt.Fprintf(os.Stderr, "Error: %v (%v)\n", msg, err)
+		} else {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		}
+	}
+
+	dls := logic.NewDownloads(cfgBinding.NewClient(), onError)
+	handler := server.New(ctx, dls, cfgBinding.NewClient(), cacheStor.NewClient(), onError)
+	logic.ConnectDownloadsToDownloadsInfo(ctx, dls, dlInfoStor, handler.ReportError)
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go logic.WatchSubscriptions(ctx, storage.NewLock(logic.SubscriptionsLockName), subsStor.NewClient(), cacheStor.NewClient(), cfgBinding.NewClient(), dls,
+		func(queued []logic.AutoQueued) {
+			for _, v := range queued {
+				fmt.Printf("Queued new episode S%02vE%02v %v\n", v.Episode.SeasonNumber, v.Episode.EpisodeNumber, v.Episode.Title)
+			}
+		},
+		handler.ReportError,
+	)
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Printf("Listening on http://%v (data in %v)\n", *addr, *dataDir)
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
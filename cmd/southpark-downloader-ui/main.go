@@ -2,15 +2,20 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"net/url"
+	"os"
+	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
 
-	/*"os"
-	"runtime/pprof"*/
+	/*"runtime/pprof"*/
 
 	"github.com/xypwn/southpark-downloader-ui/internal/gui"
 	"github.com/xypwn/southpark-downloader-ui/internal/logic"
+	"github.com/xypwn/southpark-downloader-ui/pkg/diskcache"
+	sp "github.com/xypwn/southpark-downloader-ui/pkg/southpark"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
@@ -20,6 +25,11 @@ import (
 	"fyne.io/fyne/v2/widget"
 )
 
+const (
+	appID              = "org.nobrain.southparkdownloaderui"
+	thumbnailCacheSize = 64 << 20 // Bytes
+)
+
 func main() {
 	/*f, err := os.Create("profile.prof")
 	if err != nil {
@@ -30,10 +40,22 @@ func main() {
 
 	ctx := context.Background()
 
-	app := app.NewWithID("org.nobrain.southparkdownloaderui")
+	// Before creating the app, which needs a display
+	for _, arg := range os.Args[1:] {
+		if arg == "--self-check" {
+			os.Exit(selfCheck(ctx))
+		}
+	}
+
+	app := app.NewWithID(appID)
+
 	window := app.NewWindow("South Park Downloader")
 
 	onError := func(err error) {
+		if title, msg, ok := logic.UserMessage(err); ok {
+			dialog.ShowInformation(title, msg, window)
+			return
+		}
 		errText := widget.NewLabel("Error: " + err.Error())
 		errText.Wrapping = fyne.TextWrapWord
 		errText.Alignment = fyne.TextAlignCenter
@@ -71,7 +93,11 @@ func main() {
 		), window)
 	}
 
-	storage, err := logic.NewStorage(app.Storage().RootURI().Path())
+	storagePath := app.Storage().RootURI().Path()
+	if p, err := desktopStoragePath(); err == nil && !fyne.CurrentDevice().IsMobile() && p != storagePath {
+		fmt.Fprintf(os.Stderr, "Warning: --self-check reads the config from %v instead of %v\n", p, storagePath)
+	}
+	storage, err := logic.NewStorage(storagePath)
 	if err != nil {
 		panic(err)
 	}
@@ -93,18 +119,48 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	subsStor, err := logic.NewStorageItem(storage, "subscriptions", logic.NewSubscriptions, func(err error) {
+		panic(err)
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	// Not fatal, a nil cache just fetches thumbnails every time
+	thumbnails, err := diskcache.New(filepath.Join(app.Storage().RootURI().Path(), "thumbnails"), thumbnailCacheSize)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Warning: unable to open thumbnail cache:", err)
+	}
+	app.Lifecycle().SetOnStopped(func() {
+		thumbnails.Flush()
+	})
 
 	dls := logic.NewDownloads(cfgStor.NewClient(), onError)
 
 	mobile := fyne.CurrentDevice().IsMobile()
 
-	downloads := gui.NewDownloads(dls, mobile, cfgStor.NewClient())
+	downloads := gui.NewDownloads(ctx, dls, mobile, cfgStor.NewClient(), cacheStor.NewClient(), onError, window)
 
 	logic.ConnectDownloadsToDownloadsInfo(ctx, dls, dlInfoStor, func(err error) {
 		panic(err)
 	})
 
-	episodesPanel := gui.NewEpisodesPanel(ctx, dls, cacheStor, cfgStor.NewClient(),
+	subsLock := storage.NewLock(logic.SubscriptionsLockName)
+	go logic.WatchSubscriptions(ctx, subsLock, subsStor.NewClient(), cacheStor.NewClient(), cfgStor.NewClient(), dls,
+		func(queued []logic.AutoQueued) {
+			var names []string
+			for _, v := range queued {
+				names = append(names, fmt.Sprintf("S%02vE%02v %v", v.Episode.SeasonNumber, v.Episode.EpisodeNumber, v.Episode.Title))
+			}
+			app.SendNotification(fyne.NewNotification(
+				fmt.Sprintf("Queued %v New Episodes", len(queued)),
+				strings.Join(names, "\n"),
+			))
+		},
+		onError,
+	)
+
+	episodesPanel := gui.NewEpisodesPanel(ctx, dls, cacheStor, cfgStor.NewClient(), thumbnails,
 		func(title, text string) {
 			dialog.ShowInformation(title, text, window)
 		},
@@ -126,10 +182,15 @@ func main() {
 			theme.DownloadIcon(),
 			downloads,
 		),
+		container.NewTabItemWithIcon(
+			"Subscriptions",
+			theme.HistoryIcon(),
+			gui.NewSubscriptions(ctx, subsLock, subsStor, cacheStor.NewClient(), cfgStor.NewClient(), dls, onError, window),
+		),
 		container.NewTabItemWithIcon(
 			"Preferences",
 			theme.SettingsIcon(),
-			gui.NewPreferences(ctx, cfgStor, onError, window),
+			gui.NewPreferences(ctx, cfgStor, thumbnails, onError, window),
 		),
 	)
 
@@ -141,3 +202,62 @@ func main() {
 
 	window.ShowAndRun()
 }
+
+// Returns the directory fyne's app.Storage() uses on desktop, without
+// creating an app. Fyne doesn't export this, so it mirrors rootConfigDir
+// in fyne.io/fyne/v2/internal/app as of v2.5.3 and must be checked when
+// updating Fyne. main warns if the two disagree.
+func desktopStoragePath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	var dir string
+	switch runtime.GOOS {
+	case "darwin":
+		dir = filepath.Join(home, "Library", "Preferences")
+	case "windows":
+		dir = filepath.Join(home, "AppData", "Roaming")
+	default:
+		dir, err = os.UserConfigDir()
+		if err != nil {
+			return "", err
+		}
+	}
+	return filepath.Join(dir, "fyne", appID), nil
+}
+
+// Prints a report of each step of the extraction chain. Returns the
+// exit code.
+func selfCheck(ctx context.Context) int {
+	// Only reads the config, so checking doesn't create the data directory
+	var cfg *logic.Config
+	storagePath, err := desktopStoragePath()
+	if err == nil {
+		cfg, err = logic.ReadStorageItem(storagePath, "config", logic.NewConfig)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Warning: unable to load config, using defaults:", err)
+		cfg = logic.NewConfig()
+	}
+
+	report, err := logic.RunSelfCheck(ctx, cfg, func(s sp.SelfCheckStep) {
+		fmt.Fprintf(os.Stderr, "%v: %v\n", s.Name, s.Status())
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	fmt.Print(report)
+	if !report.Passed() {
+		for _, v := range report.Steps {
+			if v.Err != nil && !v.Skipped() {
+				if _, msg, ok := logic.ShortUserMessage(v.Err); ok {
+					fmt.Fprintln(os.Stderr, msg)
+				}
+			}
+		}
+		return 1
+	}
+	return 0
+}
@@ -1,17 +1,24 @@
 package gui
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net/url"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/skratchdot/open-golang/open"
 	"github.com/xypwn/southpark-downloader-ui/internal/logic"
 	"github.com/xypwn/southpark-downloader-ui/pkg/data"
+	sp "github.com/xypwn/southpark-downloader-ui/pkg/southpark"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/data/binding"
+	"fyne.io/fyne/v2/dialog"
 	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
 )
@@ -49,7 +56,8 @@ func downloadFilterFunc(dl *logic.Download, filter downloadFilter) bool {
 
 	ep := dl.Params().Episode
 	s := fmt.Sprintf(
-		"%v Season%v Episode%v S%vE%v %v %v",
+		"%v %v Season%v Episode%v S%vE%v %v %v",
+		dl.Params().MasterURL,
 		ep.URL,
 		ep.SeasonNumber,
 		ep.EpisodeNumber,
@@ -103,10 +111,14 @@ func (di *DownloadItem) Set(dl *logic.Download) {
 	di.reset()
 
 	di.playBtn.OnTapped = func() {
-		open.Start(dl.Params().OutputVideoPath)
+		open.Start(dl.Params().PlayablePath())
 	}
 	ep := dl.Params().Episode
-	di.text.SetText(fmt.Sprintf("S%vE%v: %v", ep.SeasonNumber, ep.EpisodeNumber, ep.Title))
+	if dl.Params().MasterURL != "" {
+		di.text.SetText(ep.Title)
+	} else {
+		di.text.SetText(fmt.Sprintf("S%vE%v: %v", ep.SeasonNumber, ep.EpisodeNumber, ep.Title))
+	}
 	client := dl.ProgressBinding().NewClient()
 	statusChangedFunc := func(dp logic.DownloadProgress) {
 		di.status.SetText(dp.String())
@@ -148,7 +160,7 @@ type Downloads struct {
 	obj    fyne.CanvasObject
 }
 
-func NewDownloads(dls *logic.Downloads, mobile bool, cfgClient *data.Client[*logic.Config]) *Downloads {
+func NewDownloads(ctx context.Context, dls *logic.Downloads, mobile bool, cfgClient *data.Client[*logic.Config], cacheClient *data.Client[*logic.Cache], onError func(error), window fyne.Window) *Downloads {
 	res := &Downloads{
 		filter: data.NewListFilter(
 			dls.ListBinding,
@@ -473,14 +485,67 @@ func NewDownloads(dls *logic.Downloads, mobile bool, cfgClient *data.Client[*log
 		)
 	}
 
+	downloadURLBtn := widget.NewButtonWithIcon("Download HLS URL", theme.ContentAddIcon(), func() {
+		urlEntry := widget.NewEntry()
+		urlEntry.PlaceHolder = "https://example.com/master.m3u8"
+		urlEntry.Validator = func(s string) error {
+			u, err := url.Parse(s)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return errors.New("invalid URL")
+			}
+			return nil
+		}
+		nameEntry := widget.NewEntry()
+		nameEntry.PlaceHolder = "Output file name"
+		nameEntry.Validator = func(s string) error {
+			if strings.TrimSpace(s) == "" {
+				return errors.New("name is empty")
+			}
+			return nil
+		}
+		dialog.ShowForm(
+			"Download HLS URL",
+			"Download",
+			"Cancel",
+			[]*widget.FormItem{
+				widget.NewFormItem("URL", urlEntry),
+				widget.NewFormItem("Name", nameEntry),
+			},
+			func(ok bool) {
+				if !ok {
+					return
+				}
+				var params logic.DownloadParams
+				cfgClient.Examine(func(c *logic.Config) {
+					params = logic.NewURLDownloadParams(c, urlEntry.Text, strings.TrimSpace(nameEntry.Text))
+				})
+				dls.Add(ctx, params, onError).Go(struct{}{})
+			},
+			window,
+		)
+	})
+
+	queueEpisodesBtn := widget.NewButtonWithIcon("Queue Episodes...", theme.ContentAddIcon(), func() {
+		showQueueEpisodesDialog(ctx, dls, cfgClient, cacheClient, onError, window)
+	})
+
+	importBtn := widget.NewButtonWithIcon("Import List...", theme.UploadIcon(), func() {
+		showImportDialog(ctx, dls, cfgClient, cacheClient, onError, window)
+	})
+
 	res.obj = container.NewBorder(
 		container.NewVBox(
 			topbar,
-			widget.NewButtonWithIcon("Open Download Folder", theme.FolderOpenIcon(), func() {
-				cfgClient.Examine(func(cfg *logic.Config) {
-					open.Start(cfg.DownloadPath)
-				})
-			}),
+			container.NewGridWithColumns(2,
+				widget.NewButtonWithIcon("Open Download Folder", theme.FolderOpenIcon(), func() {
+					cfgClient.Examine(func(cfg *logic.Config) {
+						open.Start(cfg.DownloadPath)
+					})
+				}),
+				downloadURLBtn,
+				queueEpisodesBtn,
+				importBtn,
+			),
 		),
 		nil,
 		nil,
@@ -491,6 +556,253 @@ func NewDownloads(dls *logic.Downloads, mobile bool, cfgClient *data.Client[*log
 	return res
 }
 
+// Returns the languages of series that have seasons
+// and their names.
+func seriesLanguages(series logic.Series) ([]sp.Language, []string) {
+	var languages []sp.Language
+	var names []string
+	for _, v := range series.Region.AvailableLanguages() {
+		if _, ok := series.Seasons[v]; ok {
+			languages = append(languages, v)
+			names = append(names, v.String())
+		}
+	}
+	return languages, names
+}
+
+// Shows the result of queueing the selected episodes.
+func showQueuedInfo(selected, added int, failed []logic.ImportEntry, window fyne.Window) {
+	msg := fmt.Sprintf("Queued %v episodes.", added)
+	if skipped := selected - added; skipped > 0 {
+		msg += fmt.Sprintf("\n%v selected episodes were skipped, because they are unavailable,\nalready queued or already downloaded.", skipped)
+	}
+	if len(failed) == 0 {
+		dialog.ShowInformation("Queue Episodes", msg, window)
+		return
+	}
+
+	var lines []string
+	for _, v := range failed {
+		text := v.Err.Error()
+		if _, userMsg, ok := logic.UserMessage(v.Err); ok {
+			text = userMsg
+		}
+		lines = append(lines, fmt.Sprintf("Line %v: %v\n    %v", v.Line, v.Text, text))
+	}
+	failedText := widget.NewLabel(strings.Join(lines, "\n"))
+	failedText.TextStyle.Monospace = true
+	scroll := container.NewScroll(failedText)
+	scroll.SetMinSize(fyne.NewSize(400, 200))
+	dialog.ShowCustom("Queue Episodes", "Close", container.NewBorder(
+		widget.NewLabel(msg+fmt.Sprintf("\nUnable to resolve %v lines:", len(failed))),
+		nil,
+		nil,
+		nil,
+		scroll,
+	), window)
+}
+
+// Lets the user queue episodes of the cached series using a
+// selection expression (see sp.Selection).
+func showQueueEpisodesDialog(
+	ctx context.Context,
+	dls *logic.Downloads,
+	cfgClient *data.Client[*logic.Config],
+	cacheClient *data.Client[*logic.Cache],
+	onError func(error),
+	window fyne.Window,
+) {
+	var host string
+	var ttl time.Duration
+	cfgClient.Examine(func(c *logic.Config) {
+		host = c.Host
+		ttl = c.CacheTTL()
+	})
+	var series logic.Series
+	var ok bool
+	cacheClient.Examine(func(c *logic.Cache) {
+		series, ok = c.CachedSeries(host)
+	})
+	if !ok {
+		dialog.ShowInformation(
+			"No Episodes Loaded",
+			"Please wait for the episodes to load in the Episodes tab.",
+			window,
+		)
+		return
+	}
+
+	languages, languageOpts := seriesLanguages(series)
+	if len(languages) == 0 {
+		return
+	}
+
+	exprEntry := widget.NewEntry()
+	exprEntry.PlaceHolder = "S01-S05, S20E01-E05, !S20E04, latest"
+	exprEntry.Validator = func(s string) error {
+		_, err := sp.ParseSelection(s)
+		return err
+	}
+	languageSel := widget.NewSelect(languageOpts, nil)
+	languageSel.SetSelectedIndex(0)
+	help := widget.NewLabel(
+		"S05, S05E04: a season or an episode\n" +
+			"S01-S05, S20E01-E05: ranges\n" +
+			"latest, all: the newest or all episodes\n" +
+			"S05:de: another language\n" +
+			"!S20E04: exclude episodes",
+	)
+	help.Importance = widget.LowImportance
+
+	dialog.ShowForm(
+		"Queue Episodes",
+		"Queue",
+		"Cancel",
+		[]*widget.FormItem{
+			widget.NewFormItem("Episodes", exprEntry),
+			widget.NewFormItem("Language", languageSel),
+			widget.NewFormItem("", help),
+		},
+		func(ok bool) {
+			if !ok {
+				return
+			}
+			sel, err := sp.ParseSelection(exprEntry.Text)
+			if err != nil {
+				onError(err)
+				return
+			}
+			lang := languages[languageSel.SelectedIndex()]
+
+			progressDlg := dialog.NewCustomWithoutButtons("Finding Episodes", widget.NewProgressBarInfinite(), window)
+			progressDlg.Show()
+			go func() {
+				eps, err := logic.ResolveSelection(ctx, cacheClient, series, sel, lang, ttl)
+				progressDlg.Hide()
+				if err != nil {
+					if title, msg, ok := logic.UserMessage(err); ok {
+						dialog.ShowInformation(title, msg, window)
+					} else {
+						onError(err)
+					}
+					return
+				}
+				var cfg logic.Config
+				cfgClient.Examine(func(c *logic.Config) {
+					cfg = *c
+				})
+				added := dls.AddEpisodes(ctx, &cfg, eps, onError)
+				if len(eps) == 0 {
+					dialog.ShowInformation("Queue Episodes", "No episodes match \""+exprEntry.Text+"\".", window)
+					return
+				}
+				showQueuedInfo(len(eps), len(added), nil, window)
+			}()
+		},
+		window,
+	)
+}
+
+// Lets the user queue a list of episode URLs and selection
+// expressions (see logic.ParseImport), pasted or read from a file.
+func showImportDialog(
+	ctx context.Context,
+	dls *logic.Downloads,
+	cfgClient *data.Client[*logic.Config],
+	cacheClient *data.Client[*logic.Cache],
+	onError func(error),
+	window fyne.Window,
+) {
+	var opts logic.ImportOptions
+	var err error
+	cfgClient.Examine(func(c *logic.Config) {
+		opts.Host = c.Host
+		opts.ExtraHosts, err = c.CustomHostDefinitions()
+		opts.TTL = c.CacheTTL()
+	})
+	if err != nil {
+		onError(err)
+		return
+	}
+	var series logic.Series
+	cacheClient.Examine(func(c *logic.Cache) {
+		series, _ = c.CachedSeries(opts.Host)
+	})
+	languages, languageOpts := seriesLanguages(series)
+
+	listEntry := widget.NewMultiLineEntry()
+	listEntry.PlaceHolder = "One per line, e.g.\nhttps://www.southpark.de/...\nS05E04\nS20E01-E05, !S20E04"
+	listEntry.SetMinRowsVisible(8)
+	openFileBtn := widget.NewButtonWithIcon("Open File...", theme.FolderOpenIcon(), func() {
+		dialog.ShowFileOpen(func(r fyne.URIReadCloser, err error) {
+			if err != nil {
+				onError(err)
+				return
+			}
+			if r == nil {
+				return
+			}
+			defer r.Close()
+			text, err := io.ReadAll(r)
+			if err != nil {
+				onError(err)
+				return
+			}
+			listEntry.SetText(string(text))
+		}, window)
+	})
+	languageSel := widget.NewSelect(languageOpts, nil)
+	if len(languages) > 0 {
+		languageSel.SetSelectedIndex(0)
+	} else {
+		languageSel.Disable()
+	}
+
+	dlg := dialog.NewCustomConfirm(
+		"Import List",
+		"Queue",
+		"Cancel",
+		container.NewBorder(
+			nil,
+			container.NewBorder(nil, nil, widget.NewLabel("Language for selections"), nil, languageSel),
+			nil,
+			nil,
+			container.NewBorder(nil, openFileBtn, nil, nil, listEntry),
+		),
+		func(ok bool) {
+			if !ok {
+				return
+			}
+			entries := logic.ParseImport(listEntry.Text)
+			if len(entries) == 0 {
+				return
+			}
+			if i := languageSel.SelectedIndex(); i >= 0 {
+				opts.Language = languages[i]
+			}
+
+			progressDlg := dialog.NewCustomWithoutButtons("Finding Episodes", widget.NewProgressBarInfinite(), window)
+			progressDlg.Show()
+			go func() {
+				eps, failed := logic.ResolveImport(ctx, cacheClient, entries, opts)
+				progressDlg.Hide()
+				if ctx.Err() != nil {
+					return
+				}
+				var cfg logic.Config
+				cfgClient.Examine(func(c *logic.Config) {
+					cfg = *c
+				})
+				added := dls.AddEpisodes(ctx, &cfg, eps, onError)
+				showQueuedInfo(len(eps), len(added), failed, window)
+			}()
+		},
+		window,
+	)
+	dlg.Resize(fyne.NewSize(500, 400))
+	dlg.Show()
+}
+
 func (dll *Downloads) CreateRenderer() fyne.WidgetRenderer {
 	return widget.NewSimpleRenderer(dll.obj)
 }
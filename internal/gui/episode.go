@@ -3,19 +3,15 @@ package gui
 import (
 	"bytes"
 	"context"
-	"errors"
 	"fmt"
 	"image/color"
-	"path"
-	"strings"
 	"sync"
 
 	"github.com/xypwn/southpark-downloader-ui/internal/logic"
 	"github.com/xypwn/southpark-downloader-ui/pkg/asynctask"
 	"github.com/xypwn/southpark-downloader-ui/pkg/data"
+	"github.com/xypwn/southpark-downloader-ui/pkg/diskcache"
 	"github.com/xypwn/southpark-downloader-ui/pkg/gui/ellipsislabel"
-	"github.com/xypwn/southpark-downloader-ui/pkg/httputils"
-	"github.com/xypwn/southpark-downloader-ui/pkg/southpark"
 	sp "github.com/xypwn/southpark-downloader-ui/pkg/southpark"
 
 	"fyne.io/fyne/v2"
@@ -55,6 +51,7 @@ func NewEpisode(
 	onError func(error),
 	dls *logic.Downloads,
 	cfgClient *data.Client[*logic.Config],
+	thumbnails *diskcache.Cache,
 	metadata sp.EpisodeMetadata,
 	getEpisode func() (sp.Episode, error),
 	showSeasonNumber bool,
@@ -70,7 +67,7 @@ func NewEpisode(
 		thumbnailTask: asynctask.New(
 			ctx,
 			func(ctx context.Context, url string, setProgress func(struct{})) (*canvas.Image, error) {
-				data, err := httputils.GetBodyWithContext(ctx, url)
+				data, err := thumbnails.Get(ctx, url)
 				if err != nil {
 					return nil, err
 				}
@@ -239,7 +236,7 @@ func NewEpisode(
 					res.progressText.Hide()
 					res.button.SetIcon(theme.MediaPlayIcon())
 					res.button.OnTapped = func() {
-						open.Start(dl.Params().OutputVideoPath)
+						open.Start(dl.Params().PlayablePath())
 					}
 					res.isDownloaded = true
 					res.isDownloading = false
@@ -303,61 +300,24 @@ func NewEpisode(
 		}
 
 		res.button.OnTapped = func() {
-			var downloadPath string
-			var maxQuality logic.Quality
-			var outputFilePattern string
-			cfgClient.Examine(func(c *logic.Config) {
-				downloadPath = c.DownloadPath
-				maxQuality = c.MaximumQuality
-				outputFilePattern = c.OutputFilePattern
-			})
-
-			toValidFilename := func(s string) string {
-				var result strings.Builder
-				for i := 0; i < len(s); i++ {
-					b := s[i]
-					if ('a' <= b && b <= 'z') ||
-						('A' <= b && b <= 'Z') ||
-						('0' <= b && b <= '9') {
-						result.WriteByte(b)
-					} else {
-						result.WriteByte('_')
-					}
-				}
-				return result.String()
-			}
-
 			ep, err := getEpisode()
 			if err != nil {
 				onError(err)
+				return
 			}
 
-			outputBase := strings.NewReplacer(
-				"$S", fmt.Sprintf("%02v", ep.SeasonNumber),
-				"$E", fmt.Sprintf("%02v", ep.EpisodeNumber),
-				"$L", strings.ReplaceAll(ep.Language.String(), " ", "_"),
-				"$T", toValidFilename(ep.Title),
-				"$Q", maxQuality.String(),
-			).Replace(outputFilePattern)
+			var params logic.DownloadParams
+			cfgClient.Examine(func(c *logic.Config) {
+				params = logic.NewEpisodeDownloadParams(c, ep)
+			})
 
 			doDownload = func() {
 				dl = dls.Add(
 					ctx,
-					logic.DownloadParams{
-						Episode:            ep,
-						MaxQuality:         maxQuality,
-						TmpDirPath:         path.Join(downloadPath, "~TMP_"+outputBase),
-						OutputVideoPath:    path.Join(downloadPath, outputBase+".mp4"),
-						OutputSubtitlePath: path.Join(downloadPath, outputBase+".vtt"),
-					},
+					params,
 					func(err error) {
-						if vserr := (&southpark.VideoServiceError{}); errors.As(err, &vserr) {
-							msg := vserr.Error()
-							if len(msg) > 0 {
-								msg = strings.ToUpper(string(msg[0])) + msg[1:]
-							}
-							msg += "."
-							onInfo("Video unavailable", msg)
+						if title, msg, ok := logic.UserMessage(err); ok {
+							onInfo(title, msg)
 						} else {
 							onError(err)
 						}
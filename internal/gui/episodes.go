@@ -3,11 +3,16 @@ package gui
 import (
 	"context"
 	"errors"
+	"fmt"
+	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/xypwn/southpark-downloader-ui/internal/logic"
 	"github.com/xypwn/southpark-downloader-ui/pkg/data"
+	"github.com/xypwn/southpark-downloader-ui/pkg/diskcache"
 	sp "github.com/xypwn/southpark-downloader-ui/pkg/southpark"
 
 	"fyne.io/fyne/v2"
@@ -27,6 +32,7 @@ func NewEpisodeList(
 	dls *logic.Downloads,
 	cacheClient *data.Client[*logic.Cache],
 	cfgClient *data.Client[*logic.Config],
+	thumbnails *diskcache.Cache,
 	onInfo func(title, text string),
 	onError func(error),
 	setClipboard func(string),
@@ -34,36 +40,66 @@ func NewEpisodeList(
 ) (episodeList *EpisodeList, destroy func()) {
 	var destroyMtx sync.Mutex
 	var destroyFns []func()
+	destroyAll := func() {
+		destroyMtx.Lock()
+		for _, fn := range destroyFns {
+			fn()
+		}
+		destroyFns = nil
+		destroyMtx.Unlock()
+	}
+
+	var ttl time.Duration
+	cfgClient.Examine(func(c *logic.Config) {
+		ttl = c.CacheTTL()
+	})
 
+	cnt := container.NewStack()
 	res := &EpisodeList{
-		obj: NewLoadable(
-			ctx,
+		obj: cnt,
+	}
+
+	// Replaces the list, if refresh is set, the cache isn't used
+	var load func(refresh bool)
+	load = func(refresh bool) {
+		destroyAll()
+		loadCtx, cancel := context.WithCancel(ctx)
+		destroyMtx.Lock()
+		destroyFns = append(destroyFns, cancel)
+		destroyMtx.Unlock()
+		cnt.RemoveAll()
+		cnt.Add(NewLoadable(
+			loadCtx,
 			func(ctx context.Context) (fyne.CanvasObject, error) {
 				var eps []sp.Episode
 
-				update := false
+				stale := false
 				cacheClient.Examine(func(c *logic.Cache) {
 					s := c.Series[season.Region.Host].Seasons[season.Language][season.Index]
-					if s.Episodes == nil {
-						update = true
-					} else {
+					if !refresh {
 						eps = s.Episodes
 					}
+					stale = logic.Stale(s.FetchedAt, ttl)
 				})
 
-				if update {
-					var mgid string
+				if eps == nil {
 					var err error
-					eps, mgid, err = logic.GetSeason(ctx, season.Season)
+					eps, _, err = logic.FetchSeason(ctx, cacheClient, season)
 					if err != nil {
 						return nil, err
 					}
-					cacheClient.Change(func(c *logic.Cache) *logic.Cache {
-						s := &c.Series[season.Region.Host].Seasons[season.Language][season.Index]
-						s.Episodes = eps
-						s.MGID = mgid
-						return c
-					})
+				} else if stale {
+					// Show the cached episodes now and
+					// reload the list if they changed
+					go func() {
+						_, changed, err := logic.FetchSeason(loadCtx, cacheClient, season)
+						if err != nil {
+							return
+						}
+						if changed && loadCtx.Err() == nil {
+							load(false)
+						}
+					}()
 				}
 
 				vbox := container.NewVBox()
@@ -123,6 +159,7 @@ func NewEpisodeList(
 						onError,
 						dls,
 						cfgClient,
+						thumbnails,
 						v.EpisodeMetadata,
 						func() (sp.Episode, error) {
 							return ep, nil
@@ -141,9 +178,14 @@ func NewEpisodeList(
 
 				updateButtonState()
 
+				refreshButton := widget.NewButtonWithIcon("", theme.ViewRefreshIcon(), func() {
+					load(true)
+				})
+				refreshButton.Importance = widget.LowImportance
+
 				content := container.NewBorder(
 					nil,
-					container.NewPadded(downloadAllButton),
+					container.NewPadded(container.NewBorder(nil, nil, nil, refreshButton, downloadAllButton)),
 					nil,
 					nil,
 					container.NewPadded(vbox),
@@ -152,17 +194,12 @@ func NewEpisodeList(
 				return container.NewVScroll(content), nil
 			},
 			setClipboard,
-		),
+		))
 	}
+	load(false)
 	res.ExtendBaseWidget(res)
 
-	return res, func() {
-		destroyMtx.Lock()
-		for _, fn := range destroyFns {
-			fn()
-		}
-		destroyMtx.Unlock()
-	}
+	return res, destroyAll
 }
 
 func (el *EpisodeList) CreateRenderer() fyne.WidgetRenderer {
@@ -179,6 +216,7 @@ func NewEpisodesPanel(
 	dls *logic.Downloads,
 	cacheStor *logic.StorageItem[*logic.Cache],
 	cfgClient *data.Client[*logic.Config],
+	thumbnails *diskcache.Cache,
 	onInfo func(title, text string),
 	onError func(error),
 	setClipboard func(string),
@@ -188,25 +226,179 @@ func NewEpisodesPanel(
 	res.ExtendBaseWidget(res)
 
 	cache := cacheStor.NewClient()
+	panelCtx := ctx
+
+	cnt := container.NewStack()
+	res.obj = cnt
+
+	var cleanupMtx sync.Mutex
+	var cleanupFns []func()
+	addCleanup := func(fn func()) {
+		cleanupMtx.Lock()
+		cleanupFns = append(cleanupFns, fn)
+		cleanupMtx.Unlock()
+	}
 
-	res.obj = NewLoadable(
-		ctx,
-		func(ctx context.Context) (fyne.CanvasObject, error) {
-			region, seasons, mgid, err := logic.GetSeries(ctx)
+	var load func(refresh bool)
+
+	// loadCtx lives as long as the panel content, unlike the
+	// Loadable's ctx
+	loadContent := func(loadCtx context.Context, refresh bool) func(ctx context.Context) (fyne.CanvasObject, error) {
+		return func(ctx context.Context) (fyne.CanvasObject, error) {
+			var host string
+			var extraHosts []sp.HostDefinition
+			var ttl time.Duration
+			var err error
+			cfgClient.Examine(func(c *logic.Config) {
+				host = c.Host
+				extraHosts, err = c.CustomHostDefinitions()
+				ttl = c.CacheTTL()
+			})
 			if err != nil {
 				return nil, err
 			}
 
-			cache.Change(func(c *logic.Cache) *logic.Cache {
-				c.Series[region.Host] = logic.Series{
-					Region:  region,
-					Seasons: seasons,
-					MGID:    mgid,
+			// If only some languages fail, returns the series including the
+			// cached seasons of those languages along with the error
+			fetch := func(ctx context.Context) (logic.Series, error) {
+				series, err := logic.GetSeries(ctx, host, extraHosts)
+				if len(series.Seasons) == 0 {
+					return logic.Series{}, err
 				}
-				return c
+				cache.Change(func(c *logic.Cache) *logic.Cache {
+					c.SetSeries(series)
+					series = c.Series[series.Region.Host]
+					return c
+				})
+				return series, err
+			}
+
+			// Shown when refreshing failed and cached data is used
+			offlineText := widget.NewLabel("")
+			offlineErr := ""
+			var offlineMtx sync.Mutex
+			offlineDetails := widget.NewButtonWithIcon("", theme.InfoIcon(), func() {
+				offlineMtx.Lock()
+				msg := offlineErr
+				offlineMtx.Unlock()
+				onInfo("Unable to Refresh", "Showing cached episodes.\n\nError: "+msg)
 			})
+			offlineDetails.Importance = widget.LowImportance
+			offlineRetry := widget.NewButtonWithIcon("Retry", theme.ViewRefreshIcon(), func() {
+				load(true)
+			})
+			offlineRetry.Importance = widget.LowImportance
+			offlineBar := container.NewBorder(
+				nil,
+				nil,
+				widget.NewIcon(theme.WarningIcon()),
+				container.NewHBox(offlineDetails, offlineRetry),
+				offlineText,
+			)
+			offlineBar.Hide()
+			var offline atomic.Bool
+			setOffline := func(err error) {
+				offlineMtx.Lock()
+				offlineErr = err.Error()
+				offlineMtx.Unlock()
+				if offline.Swap(true) {
+					return
+				}
+				var langErrs logic.LanguageErrors
+				if logic.IsNetworkError(err) {
+					offlineText.SetText("Offline, showing cached episodes")
+				} else if errors.As(err, &langErrs) {
+					var langs []string
+					for k := range langErrs {
+						langs = append(langs, k.String())
+					}
+					sort.Strings(langs)
+					offlineText.SetText("Unable to load " + strings.Join(langs, ", "))
+				} else {
+					offlineText.SetText("Unable to refresh, showing cached episodes")
+				}
+				offlineBar.Show()
+			}
+
+			var series logic.Series
+			cached := false
+			cache.Examine(func(c *logic.Cache) {
+				series, cached = c.CachedSeries(host)
+			})
+			if !cached || refresh {
+				newSeries, err := fetch(ctx)
+				if newSeries.Seasons != nil {
+					series = newSeries
+				} else if !cached || ctx.Err() != nil {
+					return nil, err
+				}
+				if err != nil {
+					setOffline(err)
+				}
+			} else if logic.Stale(series.FetchedAt, ttl) {
+				// Show the cached seasons now and
+				// reload the panel if they changed
+				go func() {
+					newSeries, err := fetch(loadCtx)
+					if loadCtx.Err() != nil {
+						return
+					}
+					if err != nil {
+						setOffline(err)
+					}
+					if newSeries.Seasons != nil && !newSeries.SameSeasons(series) {
+						load(false)
+					}
+				}()
+			}
+			// Episodes might have been set while the series was copied
+			cache.Examine(func(c *logic.Cache) {
+				if s, ok := c.Series[series.Region.Host]; ok && s.SameSeasons(series) {
+					series = s
+				}
+			})
+			region, seasons := series.Region, series.Seasons
+
+			prefetchBar := container.NewStack()
+			var prefetch bool
+			cfgClient.Examine(func(c *logic.Config) {
+				prefetch = !c.DisablePrefetch
+			})
+			if prefetch {
+				prefetchCtx, cancel := context.WithCancel(loadCtx)
+				progress := widget.NewProgressBar()
+				progress.TextFormatter = func() string {
+					return fmt.Sprintf("Caching seasons %.0f/%.0f", progress.Value, progress.Max)
+				}
+				cancelBtn := widget.NewButtonWithIcon("", theme.CancelIcon(), cancel)
+				cancelBtn.Importance = widget.LowImportance
+				prefetchBar.Add(container.NewBorder(nil, nil, nil, cancelBtn, progress))
+				prefetchBar.Hide()
+				go func() {
+					defer cancel()
+					err := logic.PrefetchSeasons(prefetchCtx, cache, region.Host, ttl, 4, func(p logic.PrefetchProgress) {
+						if p.Total == 0 {
+							return
+						}
+						prefetchBar.Show()
+						progress.Max = float64(p.Total)
+						progress.SetValue(float64(p.Done + p.Failed))
+					})
+					prefetchBar.Hide()
+					if err != nil && !errors.Is(err, context.Canceled) {
+						if logic.IsNetworkError(err) {
+							setOffline(err)
+						} else {
+							onError(fmt.Errorf("cache seasons: %w", err))
+						}
+					}
+				}()
+			}
 
 			cleanupEpisodesFn := func() {}
+			addCleanup(func() {
+				cleanupEpisodesFn()
+			})
 
 			episodes := container.NewStack()
 
@@ -230,19 +422,26 @@ func NewEpisodesPanel(
 					cleanupEpisodesFn()
 					season := seasons[len(seasons)-1-id]
 					episodes.RemoveAll()
-					episodeList, destroy := NewEpisodeList(ctx, season, dls, cache, cfgClient, onInfo, onError, setClipboard, mobile)
+					episodeList, destroy := NewEpisodeList(ctx, season, dls, cache, cfgClient, thumbnails, onInfo, onError, setClipboard, mobile)
 					cleanupEpisodesFn = destroy
 					episodes.Add(episodeList)
 				}
 				seasonLists[k] = seasonList
 			}
 
-			if len(region.AvailableLanguages) == 0 {
+			// Languages that failed to load and aren't cached are left out
+			var languages []sp.Language
+			for _, v := range region.AvailableLanguages() {
+				if _, ok := seasons[v]; ok {
+					languages = append(languages, v)
+				}
+			}
+			if len(languages) == 0 {
 				return nil, errors.New("no languages available in your region")
 			}
 
-			languageOpts := make([]string, len(region.AvailableLanguages))
-			for i, v := range region.AvailableLanguages {
+			languageOpts := make([]string, len(languages))
+			for i, v := range languages {
 				languageOpts[i] = v.String()
 			}
 
@@ -261,7 +460,7 @@ func NewEpisodesPanel(
 				languageOpts,
 				func(s string) {
 					var newLang sp.Language
-					for _, v := range region.AvailableLanguages {
+					for _, v := range languages {
 						if s == v.String() {
 							newLang = v
 							break
@@ -288,14 +487,19 @@ func NewEpisodesPanel(
 			})
 			languageSelHelp.Hide()
 
-			if len(region.AvailableLanguages) == 1 {
+			if len(languages) == 1 {
 				languageSel.Disable()
 				languageSelHelp.Show()
 			}
 
+			refreshButton := widget.NewButtonWithIcon("Refresh", theme.ViewRefreshIcon(), func() {
+				load(true)
+			})
+			refreshButton.Importance = widget.LowImportance
+
 			split := container.NewHSplit(
 				container.NewBorder(
-					nil,
+					refreshButton,
 					nil,
 					nil,
 					nil,
@@ -314,36 +518,107 @@ func NewEpisodesPanel(
 
 			var clearSearchButton *widget.Button
 			var cleanupSearchResultsFns []func()
+			var cleanupSearchResultsMtx sync.Mutex
+			addCleanup(func() {
+				cleanupSearchResultsMtx.Lock()
+				for _, v := range cleanupSearchResultsFns {
+					v()
+				}
+				cleanupSearchResultsFns = nil
+				cleanupSearchResultsMtx.Unlock()
+			})
 			queryListenerCl.AddListener(func(sq searchQuery) {
+				cleanupSearchResultsMtx.Lock()
 				for _, v := range cleanupSearchResultsFns {
 					v()
 				}
 				cleanupSearchResultsFns = nil
+				cleanupSearchResultsMtx.Unlock()
 				mainCnt.RemoveAll()
 				selLanguage := sp.Language(selectedLanguage.Load())
-				if sq.Text != "" {
+				if u, ok := logic.ParseURL(strings.TrimSpace(sq.Text)); ok {
+					clearSearchButton.Enable()
+					urlCtx, cancel := context.WithCancel(ctx)
+					cleanupSearchResultsMtx.Lock()
+					cleanupSearchResultsFns = append(cleanupSearchResultsFns, cancel)
+					cleanupSearchResultsMtx.Unlock()
+					addDestroy := func(destroy func()) {
+						cleanupSearchResultsMtx.Lock()
+						defer cleanupSearchResultsMtx.Unlock()
+						if urlCtx.Err() != nil {
+							// URL was replaced or cleared
+							destroy()
+							return
+						}
+						cleanupSearchResultsFns = append(cleanupSearchResultsFns, destroy)
+					}
+					mainCnt.Add(NewLoadable(urlCtx,
+						func(ctx context.Context) (fyne.CanvasObject, error) {
+							return loadURLPreview(ctx, u, series, dls, cache, cfgClient, thumbnails, onInfo, onError, addDestroy, mobile)
+						},
+						setClipboard,
+					))
+				} else if sq.Text != "" {
 					clearSearchButton.Enable()
+					searchCtx, cancel := context.WithCancel(ctx)
+					cleanupSearchResultsMtx.Lock()
+					cleanupSearchResultsFns = append(cleanupSearchResultsFns, cancel)
+					cleanupSearchResultsMtx.Unlock()
+					var index *sp.SearchIndex
+					var uncached map[sp.Language]map[int]struct{}
+					cache.Examine(func(c *logic.Cache) {
+						index, uncached = c.SearchIndex(region.Host)
+					})
+					// The remote search is only used for seasons whose
+					// episodes aren't cached yet
+					uncachedSeasons := uncached[selLanguage]
+					it := sp.NewSearchIterator(region, series.SeriesMGID(selLanguage), sq.Text, 35)
+					it.Filter = func(e sp.EpisodeMetadata) bool {
+						_, ok := uncachedSeasons[e.SeasonNumber]
+						return e.Language == selLanguage && ok
+					}
+					remoteDone := func() bool {
+						return len(uncachedSeasons) == 0 || it.Done()
+					}
 					mainCnt.Add(NewLoadable(ctx,
 						func(ctx context.Context) (fyne.CanvasObject, error) {
-							text := "Results for \"" + sq.Text + "\" in " + selLanguage.String() + ":"
-							results, err := sp.Search(ctx, region, mgid, sq.Text, 0, 35)
-							if err != nil {
-								return nil, err
+							results := index.Search(sq.Text, func(e sp.EpisodeMetadata) bool {
+								return e.Language == selLanguage
+							})
+							// The first remote page is loaded right away, so
+							// results from uncached seasons don't only show up
+							// after scrolling past the local ones
+							if !remoteDone() {
+								remote, err := it.Next(ctx)
+								if err != nil {
+									if len(results) == 0 {
+										return nil, err
+									}
+									onError(err)
+								}
+								results = append(results, remote...)
 							}
-							resultFound := false
+
 							vbox := container.NewVBox()
-							for _, v := range results {
-								result := v
-								if result.Language == selLanguage {
+							addResults := func(results []sp.EpisodeMetadata) {
+								cleanupSearchResultsMtx.Lock()
+								defer cleanupSearchResultsMtx.Unlock()
+								if searchCtx.Err() != nil {
+									// Search was replaced or cleared
+									return
+								}
+								for _, v := range results {
+									result := v
 									ep, destroy := NewEpisode(
-										ctx,
+										searchCtx,
 										onInfo,
 										onError,
 										dls,
 										cfgClient,
+										thumbnails,
 										result,
 										func() (sp.Episode, error) {
-											return sp.GetEpisode(ctx, region, result.URL)
+											return sp.GetEpisode(searchCtx, region, result.URL)
 										},
 										true,
 										true,
@@ -352,12 +627,56 @@ func NewEpisodesPanel(
 									)
 									vbox.Add(ep)
 									cleanupSearchResultsFns = append(cleanupSearchResultsFns, destroy)
-									resultFound = true
 								}
 							}
-							if !resultFound {
+							addResults(results)
+
+							text := "Results for \"" + sq.Text + "\" in " + selLanguage.String() + ":"
+							if len(results) == 0 {
 								text = "No Results for \"" + sq.Text + "\" in " + selLanguage.String() + " :("
 							}
+
+							// Loads the next page when scrolled to the
+							// bottom or when the button is tapped
+							var loadMoreBtn *widget.Button
+							var loading atomic.Bool
+							loadMore := func() {
+								if remoteDone() || !loading.CompareAndSwap(false, true) {
+									return
+								}
+								loadMoreBtn.Disable()
+								loadMoreBtn.SetText("Loading...")
+								go func() {
+									defer loading.Store(false)
+									results, err := it.Next(searchCtx)
+									if searchCtx.Err() != nil {
+										return
+									}
+									addResults(results)
+									if err != nil {
+										onError(err)
+									}
+									if remoteDone() {
+										loadMoreBtn.Hide()
+									} else {
+										loadMoreBtn.SetText("Load More")
+										loadMoreBtn.Enable()
+									}
+								}()
+							}
+							loadMoreBtn = widget.NewButtonWithIcon("Load More", theme.MoreVerticalIcon(), loadMore)
+							loadMoreBtn.Importance = widget.LowImportance
+							if remoteDone() {
+								loadMoreBtn.Hide()
+							}
+
+							scroll := container.NewVScroll(container.NewVBox(vbox, loadMoreBtn))
+							scroll.OnScrolled = func(pos fyne.Position) {
+								if pos.Y+scroll.Size().Height >= scroll.Content.MinSize().Height-loadMoreBtn.MinSize().Height {
+									loadMore()
+								}
+							}
+
 							return container.NewPadded(
 								container.NewBorder(
 									widget.NewRichText(
@@ -373,7 +692,7 @@ func NewEpisodesPanel(
 									nil,
 									nil,
 									nil,
-									container.NewVScroll(vbox),
+									scroll,
 								),
 							), nil
 						},
@@ -388,7 +707,7 @@ func NewEpisodesPanel(
 			})
 
 			search := widget.NewEntry()
-			search.PlaceHolder = "Search Episodes"
+			search.PlaceHolder = "Search Episodes or Paste URL"
 			search.ActionItem = widget.NewIcon(theme.SearchIcon())
 			search.OnChanged = func(s string) {
 				querySetterCl.Change(func(sq searchQuery) searchQuery {
@@ -437,15 +756,30 @@ func NewEpisodesPanel(
 			}
 
 			return container.NewBorder(
-				searchAndLanguage,
-				nil,
+				container.NewVBox(offlineBar, searchAndLanguage),
+				prefetchBar,
 				nil,
 				nil,
 				mainCnt,
 			), nil
-		},
-		setClipboard,
-	)
+		}
+	}
+
+	// Replaces the panel, if refresh is set, the cache isn't used
+	load = func(refresh bool) {
+		cleanupMtx.Lock()
+		for _, fn := range cleanupFns {
+			fn()
+		}
+		cleanupFns = nil
+		cleanupMtx.Unlock()
+
+		loadCtx, cancel := context.WithCancel(panelCtx)
+		addCleanup(cancel)
+		cnt.RemoveAll()
+		cnt.Add(NewLoadable(loadCtx, loadContent(loadCtx, refresh), setClipboard))
+	}
+	load(false)
 
 	return res
 }
@@ -7,9 +7,12 @@ import (
 	"image/color"
 	"strconv"
 	"strings"
+	"time"
 	"unicode"
 
 	"github.com/xypwn/southpark-downloader-ui/internal/logic"
+	"github.com/xypwn/southpark-downloader-ui/pkg/diskcache"
+	sp "github.com/xypwn/southpark-downloader-ui/pkg/southpark"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/canvas"
@@ -48,12 +51,16 @@ func (e *SelectableEntry) FocusLost() {
 type Preferences struct {
 	widget.BaseWidget
 	secDownloads *fyne.Container
+	secRegion    *fyne.Container
+	secCache     *fyne.Container
 	obj          fyne.CanvasObject
 }
 
-func NewPreferences(ctx context.Context, cfgStor *logic.StorageItem[*logic.Config], onError func(error), window fyne.Window) *Preferences {
+func NewPreferences(ctx context.Context, cfgStor *logic.StorageItem[*logic.Config], thumbnails *diskcache.Cache, onError func(error), window fyne.Window) *Preferences {
 	res := &Preferences{
 		secDownloads: container.NewVBox(),
+		secRegion:    container.NewVBox(),
+		secCache:     container.NewVBox(),
 	}
 	res.ExtendBaseWidget(res)
 
@@ -413,8 +420,413 @@ func NewPreferences(ctx context.Context, cfgStor *logic.StorageItem[*logic.Confi
 		)
 	}
 
+	// Video Codec
+	{
+		label := widget.NewLabel("Video Codec:")
+		codecs := []sp.Codec{sp.CodecUnknown, sp.CodecH264, sp.CodecH265}
+		codecString := func(c sp.Codec) string {
+			if c == sp.CodecUnknown {
+				return "Any"
+			}
+			return c.String()
+		}
+		opts := make([]string, len(codecs))
+		for i, v := range codecs {
+			opts[i] = codecString(v)
+		}
+		sel := widget.NewSelect(opts, nil)
+		cfg.Examine(func(c *logic.Config) {
+			sel.SetSelected(codecString(c.VideoCodec))
+		})
+		sel.OnChanged = func(s string) {
+			for _, codec := range codecs {
+				if s == codecString(codec) {
+					cfg.Change(func(c *logic.Config) *logic.Config {
+						c.VideoCodec = codec
+						return c
+					})
+					break
+				}
+			}
+		}
+		cfg.AddListener(func(c *logic.Config) {
+			sel.SetSelected(codecString(c.VideoCodec))
+		})
+		res.secDownloads.Add(
+			container.NewBorder(
+				nil,
+				nil,
+				label,
+				nil,
+				sel,
+			),
+		)
+	}
+
+	// Output Format
+	{
+		label := widget.NewLabel("Output Format:")
+		formats := []sp.OutputFormat{sp.OutputFormatMP4, sp.OutputFormatHLS}
+		opts := make([]string, len(formats))
+		for i, v := range formats {
+			opts[i] = v.String()
+		}
+		sel := widget.NewSelect(opts, nil)
+		cfg.Examine(func(c *logic.Config) {
+			sel.SetSelected(c.OutputFormat.String())
+		})
+		sel.OnChanged = func(s string) {
+			for _, format := range formats {
+				if s == format.String() {
+					cfg.Change(func(c *logic.Config) *logic.Config {
+						c.OutputFormat = format
+						return c
+					})
+					break
+				}
+			}
+		}
+		cfg.AddListener(func(c *logic.Config) {
+			sel.SetSelected(c.OutputFormat.String())
+		})
+		help := widget.NewButtonWithIcon("", theme.InfoIcon(), func() {
+			dialog.ShowInformation(
+				"Output Format",
+				"MP4: A single video file.\n"+
+					"HLS Folder: The segments exactly as delivered (but decrypted), plus\n"+
+					"playlists. Open master.m3u8 in VLC or mpv to play it.",
+				window,
+			)
+		})
+		res.secDownloads.Add(
+			container.NewBorder(
+				nil,
+				nil,
+				label,
+				help,
+				sel,
+			),
+		)
+	}
+
+	// MP4 Layout
+	{
+		label := widget.NewLabel("MP4 Layout:")
+		layouts := []sp.MP4Layout{sp.MP4LayoutStandard, sp.MP4LayoutFastStart, sp.MP4LayoutFragmented}
+		opts := make([]string, len(layouts))
+		for i, v := range layouts {
+			opts[i] = v.String()
+		}
+		sel := widget.NewSelect(opts, nil)
+		cfg.Examine(func(c *logic.Config) {
+			sel.SetSelected(c.MP4Layout.String())
+		})
+		sel.OnChanged = func(s string) {
+			for _, layout := range layouts {
+				if s == layout.String() {
+					cfg.Change(func(c *logic.Config) *logic.Config {
+						c.MP4Layout = layout
+						return c
+					})
+					break
+				}
+			}
+		}
+		cfg.AddListener(func(c *logic.Config) {
+			sel.SetSelected(c.MP4Layout.String())
+		})
+		help := widget.NewButtonWithIcon("", theme.InfoIcon(), func() {
+			dialog.ShowInformation(
+				"MP4 Layout",
+				"Standard: Fastest to create.\n"+
+					"Fast Start: Starts playing sooner when streamed over a network.\n"+
+					"Fragmented: Playable while it's still being written, but less widely supported.",
+				window,
+			)
+		})
+		res.secDownloads.Add(
+			container.NewBorder(
+				nil,
+				nil,
+				label,
+				help,
+				sel,
+			),
+		)
+	}
+
+	// Host
+	{
+		const automatic = "Automatic"
+		label := widget.NewLabel("Host:")
+		getOpts := func(c *logic.Config) []string {
+			opts := []string{automatic}
+			for _, v := range c.CustomHosts {
+				opts = append(opts, v.Host)
+			}
+			for _, v := range sp.BuiltinHostDefinitions() {
+				opts = append(opts, v.Host.String())
+			}
+			return opts
+		}
+		hostString := func(host string) string {
+			if host == "" {
+				return automatic
+			}
+			return host
+		}
+		sel := widget.NewSelect(nil, nil)
+		cfg.Examine(func(c *logic.Config) {
+			sel.Options = getOpts(c)
+			sel.SetSelected(hostString(c.Host))
+		})
+		sel.OnChanged = func(s string) {
+			if s == automatic {
+				s = ""
+			}
+			cfg.Change(func(c *logic.Config) *logic.Config {
+				c.Host = s
+				return c
+			})
+		}
+		cfg.AddListener(func(c *logic.Config) {
+			sel.Options = getOpts(c)
+			sel.SetSelected(hostString(c.Host))
+		})
+		testBtn := widget.NewButtonWithIcon("Test", theme.SearchIcon(), nil)
+		testBtn.OnTapped = func() {
+			var host string
+			cfg.Examine(func(c *logic.Config) {
+				host = c.Host
+			})
+			testBtn.Disable()
+			go func() {
+				defer testBtn.Enable()
+				msg, err := func() (string, error) {
+					var prefix string
+					if host == "" {
+						detected, err := sp.DetectHost(ctx)
+						if err != nil {
+							return "", err
+						}
+						host = detected
+						prefix = fmt.Sprintf("Detected host: %v\n", host)
+					}
+					elapsed, err := sp.ProbeHost(ctx, sp.Host(host))
+					if err != nil {
+						return "", err
+					}
+					return fmt.Sprintf("%v%v is reachable (responded in %v ms).", prefix, host, elapsed.Milliseconds()), nil
+				}()
+				if err != nil {
+					dialog.ShowInformation("Host Unavailable", err.Error(), window)
+					return
+				}
+				dialog.ShowInformation("Host Available", msg, window)
+			}()
+		}
+		help := widget.NewButtonWithIcon("", theme.InfoIcon(), func() {
+			dialog.ShowInformation(
+				"Host",
+				"The South Park website redirects to a different host depending on\n"+
+					"your location. Select a host to stop relying on that redirect, e.g.\n"+
+					"on networks that redirect somewhere unexpected.\n"+
+					"Use Test to check whether the host is reachable from your IP address.\n"+
+					"Additional hosts can be defined via CustomHosts in the config file.\n"+
+					"Changes take effect after restarting the app.",
+				window,
+			)
+		})
+		res.secRegion.Add(
+			container.NewBorder(
+				nil,
+				nil,
+				label,
+				container.NewHBox(testBtn, help),
+				sel,
+			),
+		)
+	}
+
+	// Self-Check
+	{
+		var btn *widget.Button
+		btn = widget.NewButtonWithIcon("Run Self-Check", theme.MediaPlayIcon(), func() {
+			var cfgCopy logic.Config
+			cfg.Examine(func(c *logic.Config) {
+				cfgCopy = *c
+			})
+			btn.Disable()
+			progress := widget.NewLabel("Starting...")
+			progressDlg := dialog.NewCustomWithoutButtons("Running Self-Check", container.NewVBox(
+				widget.NewProgressBarInfinite(),
+				progress,
+			), window)
+			progressDlg.Show()
+			go func() {
+				defer btn.Enable()
+				report, err := logic.RunSelfCheck(ctx, &cfgCopy, func(s sp.SelfCheckStep) {
+					progress.SetText(fmt.Sprintf("%v: %v", s.Name, s.Status()))
+				})
+				progressDlg.Hide()
+				if err != nil {
+					onError(err)
+					return
+				}
+				title := "Self-Check Passed"
+				if !report.Passed() {
+					title = "Self-Check Failed"
+				}
+				text := widget.NewLabel(report.String())
+				text.TextStyle.Monospace = true
+				dialog.ShowCustom(title, "Close", container.NewVBox(
+					container.NewHScroll(text),
+					widget.NewButtonWithIcon("Copy", theme.ContentCopyIcon(), func() {
+						window.Clipboard().SetContent(report.String())
+					}),
+				), window)
+			}()
+		})
+		help := widget.NewButtonWithIcon("", theme.InfoIcon(), func() {
+			dialog.ShowInformation(
+				"Self-Check",
+				"Runs every step needed to list and download episodes and reports\n"+
+					"which ones fail. Please attach the report when filing an issue.\n"+
+					"Can also be run from the command line with --self-check.",
+				window,
+			)
+		})
+		res.secRegion.Add(
+			container.NewBorder(
+				nil,
+				nil,
+				nil,
+				help,
+				btn,
+			),
+		)
+	}
+
+	// Prefetch
+	{
+		check := widget.NewCheck("Cache all seasons in the background", nil)
+		cfg.Examine(func(c *logic.Config) {
+			check.SetChecked(!c.DisablePrefetch)
+		})
+		check.OnChanged = func(b bool) {
+			cfg.Change(func(c *logic.Config) *logic.Config {
+				c.DisablePrefetch = !b
+				return c
+			})
+		}
+		cfg.AddListener(func(c *logic.Config) {
+			check.SetChecked(!c.DisablePrefetch)
+		})
+		help := widget.NewButtonWithIcon("", theme.InfoIcon(), func() {
+			dialog.ShowInformation(
+				"Cache All Seasons",
+				"Fetches the episode lists of all seasons in all languages after\n"+
+					"startup, so browsing and searching is instant and works offline.",
+				window,
+			)
+		})
+		res.secCache.Add(
+			container.NewBorder(
+				nil,
+				nil,
+				nil,
+				help,
+				check,
+			),
+		)
+	}
+
+	// Cache TTL
+	{
+		label := widget.NewLabel("Refresh After:")
+		opts := []struct {
+			Name  string
+			Hours int
+		}{
+			{"1 Hour", 1},
+			{"6 Hours", 6},
+			{"1 Day", 24},
+			{"1 Week", 24 * 7},
+			{"Never", -1},
+		}
+		optNames := make([]string, len(opts))
+		for i, v := range opts {
+			optNames[i] = v.Name
+		}
+		sel := widget.NewSelect(optNames, nil)
+		setSelected := func(c *logic.Config) {
+			ttl := c.CacheTTL()
+			for _, v := range opts {
+				if (v.Hours < 0 && ttl < 0) || time.Duration(v.Hours)*time.Hour == ttl {
+					sel.SetSelected(v.Name)
+					return
+				}
+			}
+			sel.ClearSelected()
+		}
+		cfg.Examine(setSelected)
+		sel.OnChanged = func(s string) {
+			for _, v := range opts {
+				if v.Name == s {
+					cfg.Change(func(c *logic.Config) *logic.Config {
+						c.CacheTTLHours = v.Hours
+						return c
+					})
+				}
+			}
+		}
+		cfg.AddListener(setSelected)
+		help := widget.NewButtonWithIcon("", theme.InfoIcon(), func() {
+			dialog.ShowInformation(
+				"Refresh After",
+				"Cached seasons and episode lists older than this are shown\n"+
+					"right away and refreshed in the background.\n"+
+					"Use the refresh buttons to refresh them manually.",
+				window,
+			)
+		})
+		res.secCache.Add(
+			container.NewBorder(
+				nil,
+				nil,
+				label,
+				help,
+				sel,
+			),
+		)
+	}
+
+	// Thumbnail Cache
+	{
+		var btn *widget.Button
+		updateText := func() {
+			btn.SetText(fmt.Sprintf("Clear Thumbnail Cache (%.1f MiB)", float64(thumbnails.Size())/(1<<20)))
+		}
+		btn = widget.NewButtonWithIcon("", theme.DeleteIcon(), func() {
+			if err := thumbnails.Clear(); err != nil {
+				onError(err)
+			}
+		})
+		if thumbnails == nil {
+			btn.SetText("Thumbnail Cache Unavailable")
+			btn.Disable()
+		} else {
+			updateText()
+			// Thumbnails are cached while browsing
+			thumbnails.AddSizeListener(updateText)
+		}
+		res.secCache.Add(btn)
+	}
+
 	sections := widget.NewAccordion(
 		widget.NewAccordionItem("Downloads", res.secDownloads),
+		widget.NewAccordionItem("Region", res.secRegion),
+		widget.NewAccordionItem("Cache", res.secCache),
 	)
 	sections.MultiOpen = true
 	sections.OpenAll()
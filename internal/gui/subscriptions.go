@@ -0,0 +1,244 @@
+package gui
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/xypwn/southpark-downloader-ui/internal/logic"
+	"github.com/xypwn/southpark-downloader-ui/pkg/data"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+type Subscriptions struct {
+	widget.BaseWidget
+	obj fyne.CanvasObject
+}
+
+func NewSubscriptions(
+	ctx context.Context,
+	lock *logic.StorageLock,
+	subsStor *logic.StorageItem[*logic.Subscriptions],
+	cacheClient *data.Client[*logic.Cache],
+	cfgClient *data.Client[*logic.Config],
+	dls *logic.Downloads,
+	onError func(error),
+	window fyne.Window,
+) *Subscriptions {
+	res := &Subscriptions{}
+	res.ExtendBaseWidget(res)
+
+	subs := subsStor.NewClient()
+	// Separate client, so changes made by checks reach the listener of subs
+	checkClient := subsStor.NewClient()
+	var update func(*logic.Subscriptions)
+
+	// Add Subscription
+	addBtn := widget.NewButtonWithIcon("Subscribe...", theme.ContentAddIcon(), func() {
+		var host string
+		cfgClient.Examine(func(c *logic.Config) {
+			host = c.Host
+		})
+		var series logic.Series
+		var ok bool
+		cacheClient.Examine(func(c *logic.Cache) {
+			series, ok = c.CachedSeries(host)
+		})
+		languages, languageOpts := seriesLanguages(series)
+		if !ok || len(languages) == 0 {
+			dialog.ShowInformation(
+				"No Episodes Loaded",
+				"Please wait for the episodes to load in the Episodes tab.",
+				window,
+			)
+			return
+		}
+
+		languageSel := widget.NewSelect(languageOpts, nil)
+		seasonSel := widget.NewSelect(nil, nil)
+		var seasonNumbers []int
+		languageSel.OnChanged = func(string) {
+			lang := languages[languageSel.SelectedIndex()]
+			seasonNumbers = []int{0}
+			opts := []string{"Latest Season"}
+			seasons := series.Seasons[lang]
+			for i := len(seasons) - 1; i >= 0; i-- {
+				seasonNumbers = append(seasonNumbers, seasons[i].SeasonNumber)
+				opts = append(opts, fmt.Sprintf("Season %v", seasons[i].SeasonNumber))
+			}
+			seasonSel.Options = opts
+			seasonSel.SetSelectedIndex(0)
+		}
+		languageSel.SetSelectedIndex(0)
+
+		dialog.ShowForm(
+			"Subscribe",
+			"Subscribe",
+			"Cancel",
+			[]*widget.FormItem{
+				widget.NewFormItem("Language", languageSel),
+				widget.NewFormItem("Season", seasonSel),
+			},
+			func(ok bool) {
+				if !ok || seasonSel.SelectedIndex() < 0 {
+					return
+				}
+				sub := logic.Subscription{
+					Host:     series.Region.Host,
+					Language: languages[languageSel.SelectedIndex()],
+					Season:   seasonNumbers[seasonSel.SelectedIndex()],
+				}
+				added := false
+				subs.Change(func(s *logic.Subscriptions) *logic.Subscriptions {
+					added = s.Add(sub)
+					// Check the new subscription right away
+					s.LastCheck = time.Time{}
+					return s
+				})
+				subs.Examine(update)
+				if !added {
+					dialog.ShowInformation("Subscribe", "You are already subscribed to "+sub.String()+".", window)
+				}
+			},
+			window,
+		)
+	})
+
+	// Check Now
+	var checkBtn *widget.Button
+	checkBtn = widget.NewButtonWithIcon("Check Now", theme.ViewRefreshIcon(), func() {
+		checkBtn.Disable()
+		go func() {
+			defer checkBtn.Enable()
+			queued, err := logic.CheckSubscriptions(ctx, lock, checkClient, cacheClient, cfgClient, dls, onError)
+			if err != nil {
+				onError(err)
+				return
+			}
+			if len(queued) == 0 {
+				dialog.ShowInformation("Check Now", "No new episodes.", window)
+			}
+		}()
+	})
+
+	// Interval
+	intervalOpts := []struct {
+		Name    string
+		Minutes int
+	}{
+		{"15 Minutes", 15},
+		{"30 Minutes", 30},
+		{"1 Hour", 60},
+		{"3 Hours", 3 * 60},
+		{"6 Hours", 6 * 60},
+		{"1 Day", 24 * 60},
+	}
+	intervalNames := make([]string, len(intervalOpts))
+	for i, v := range intervalOpts {
+		intervalNames[i] = v.Name
+	}
+	intervalSel := widget.NewSelect(intervalNames, nil)
+	setInterval := func(c *logic.Config) {
+		interval := c.SubscriptionInterval()
+		for _, v := range intervalOpts {
+			if time.Duration(v.Minutes)*time.Minute == interval {
+				intervalSel.SetSelected(v.Name)
+				return
+			}
+		}
+		intervalSel.ClearSelected()
+	}
+	cfgClient.Examine(setInterval)
+	intervalSel.OnChanged = func(s string) {
+		for _, v := range intervalOpts {
+			if v.Name == s {
+				cfgClient.Change(func(c *logic.Config) *logic.Config {
+					c.SubscriptionMinutes = v.Minutes
+					return c
+				})
+			}
+		}
+	}
+	cfgClient.AddListener(setInterval)
+
+	status := widget.NewLabel("")
+	status.Wrapping = fyne.TextWrapWord
+	subsList := container.NewVBox()
+	history := container.NewVBox()
+
+	update = func(s *logic.Subscriptions) {
+		if s.LastCheck.IsZero() {
+			status.SetText("Not checked yet")
+		} else {
+			text := "Last checked " + s.LastCheck.Format("2006-01-02 15:04")
+			if s.LastError != "" {
+				text += "\nError: " + s.LastError
+			}
+			status.SetText(text)
+		}
+
+		subsList.RemoveAll()
+		if len(s.Subscriptions) == 0 {
+			subsList.Add(widget.NewLabel("No subscriptions. Subscribe to a season to queue its new episodes automatically."))
+		}
+		for _, v := range s.Subscriptions {
+			sub := v
+			removeBtn := widget.NewButtonWithIcon("", theme.DeleteIcon(), func() {
+				subs.Change(func(s *logic.Subscriptions) *logic.Subscriptions {
+					s.Remove(sub)
+					return s
+				})
+				subs.Examine(update)
+			})
+			removeBtn.Importance = widget.LowImportance
+			subsList.Add(container.NewBorder(nil, nil, nil, removeBtn, widget.NewLabel(sub.String())))
+		}
+
+		history.RemoveAll()
+		if len(s.History) == 0 {
+			history.Add(widget.NewLabel("Nothing yet"))
+		}
+		for _, v := range s.History {
+			history.Add(widget.NewLabel(fmt.Sprintf(
+				"%v  S%02vE%02v %v  %v",
+				v.Time.Format("2006-01-02 15:04"),
+				v.Episode.SeasonNumber,
+				v.Episode.EpisodeNumber,
+				v.Episode.Language.Code(),
+				v.Episode.Title,
+			)))
+		}
+	}
+	subs.Examine(update)
+	subs.AddListener(update)
+
+	heading := func(text string) fyne.CanvasObject {
+		return widget.NewLabelWithStyle(text, fyne.TextAlignLeading, fyne.TextStyle{Bold: true})
+	}
+
+	res.obj = container.NewBorder(
+		container.NewVBox(
+			container.NewGridWithColumns(2, addBtn, checkBtn),
+			container.NewBorder(nil, nil, widget.NewLabel("Check Every:"), nil, intervalSel),
+			status,
+			heading("Subscriptions"),
+			subsList,
+			heading("Auto-Queued Episodes"),
+		),
+		nil,
+		nil,
+		nil,
+		container.NewVScroll(history),
+	)
+
+	return res
+}
+
+func (s *Subscriptions) CreateRenderer() fyne.WidgetRenderer {
+	return widget.NewSimpleRenderer(s.obj)
+}
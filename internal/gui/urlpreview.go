@@ -0,0 +1,140 @@
+package gui
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/xypwn/southpark-downloader-ui/internal/logic"
+	"github.com/xypwn/southpark-downloader-ui/pkg/data"
+	"github.com/xypwn/southpark-downloader-ui/pkg/diskcache"
+	sp "github.com/xypwn/southpark-downloader-ui/pkg/southpark"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// Resolves an episode or season URL entered by the user and shows the
+// episodes it refers to along with a button to queue all of them.
+// addDestroy is called with the destroy functions of the episode widgets.
+func loadURLPreview(
+	ctx context.Context,
+	rawURL string,
+	series logic.Series,
+	dls *logic.Downloads,
+	cache *data.Client[*logic.Cache],
+	cfgClient *data.Client[*logic.Config],
+	thumbnails *diskcache.Cache,
+	onInfo func(title, text string),
+	onError func(error),
+	addDestroy func(func()),
+	mobile bool,
+) (fyne.CanvasObject, error) {
+	var extraHosts []sp.HostDefinition
+	var ttl time.Duration
+	var err error
+	cfgClient.Examine(func(c *logic.Config) {
+		extraHosts, err = c.CustomHostDefinitions()
+		ttl = c.CacheTTL()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := logic.ResolveURL(ctx, cache, series, rawURL, extraHosts, ttl)
+	if err != nil {
+		if _, msg, ok := logic.ShortUserMessage(err); ok {
+			return nil, fmt.Errorf("%v\n\n%w", msg, err)
+		}
+		return nil, err
+	}
+
+	var title string
+	if content.IsSeason {
+		title = fmt.Sprintf("%v (%v, %v episodes)", content.Season.Title, content.Season.Language, len(content.Episodes))
+	} else if len(content.Episodes) == 1 {
+		ep := content.Episodes[0]
+		title = fmt.Sprintf("Season %v Episode %v (%v)", ep.SeasonNumber, ep.EpisodeNumber, ep.Language)
+	}
+
+	vbox := container.NewVBox()
+	var episodeWidgets []*Episode
+	queueBtn := widget.NewButtonWithIcon("", theme.DownloadIcon(), nil)
+	queueBtn.Importance = widget.HighImportance
+	queueText := "Queue Episode"
+	if content.IsSeason {
+		queueText = "Queue Season"
+	}
+
+	updateButtonState := func() {
+		allQueued := true
+		for i, epWid := range episodeWidgets {
+			if !content.Episodes[i].Unavailable && !epWid.isDownloaded && !epWid.isDownloading {
+				allQueued = false
+				break
+			}
+		}
+		if allQueued {
+			queueBtn.SetText("Queued")
+			queueBtn.Disable()
+		} else {
+			queueBtn.SetText(queueText)
+			queueBtn.Enable()
+		}
+	}
+
+	queueBtn.OnTapped = func() {
+		for i, epWid := range episodeWidgets {
+			if content.Episodes[i].Unavailable || epWid.isDownloaded || epWid.isDownloading {
+				continue
+			}
+			epWid.button.OnTapped()
+		}
+		updateButtonState()
+	}
+
+	for _, v := range content.Episodes {
+		ep := v
+		epWid, destroy := NewEpisode(
+			ctx,
+			onInfo,
+			onError,
+			dls,
+			cfgClient,
+			thumbnails,
+			ep.EpisodeMetadata,
+			func() (sp.Episode, error) {
+				return ep, nil
+			},
+			!content.IsSeason,
+			true,
+			mobile,
+			updateButtonState,
+		)
+		episodeWidgets = append(episodeWidgets, epWid)
+		addDestroy(destroy)
+		vbox.Add(container.NewPadded(epWid))
+	}
+	updateButtonState()
+
+	return container.NewPadded(
+		container.NewBorder(
+			widget.NewRichText(
+				&widget.TextSegment{
+					Style: widget.RichTextStyle{
+						Alignment: fyne.TextAlignCenter,
+						Inline:    false,
+						SizeName:  theme.SizeNameHeadingText,
+					},
+					Text: title,
+				},
+			),
+			container.NewPadded(queueBtn),
+			nil,
+			nil,
+			container.NewVScroll(vbox),
+		),
+	), nil
+}
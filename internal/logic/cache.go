@@ -2,61 +2,167 @@ package logic
 
 import (
 	"context"
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	sp "github.com/xypwn/southpark-downloader-ui/pkg/southpark"
 )
 
 type Season struct {
 	sp.Season
-	Region   sp.RegionInfo
-	Index    int
-	Episodes []sp.Episode
-	MGID     string
+	Region    sp.RegionInfo
+	Index     int
+	Episodes  []sp.Episode
+	MGID      string
+	FetchedAt time.Time // When Episodes were fetched
 }
 
+// Replaced in tests
+var getEpisodes = sp.GetEpisodes
+
 func GetSeason(ctx context.Context, season sp.Season) (episodes []sp.Episode, mgid string, err error) {
-	return sp.GetEpisodes(ctx, season)
+	return getEpisodes(ctx, season)
 }
 
 type Series struct {
-	Region  sp.RegionInfo
-	Seasons map[sp.Language][]Season
-	MGID    string
+	Region    sp.RegionInfo
+	Seasons   map[sp.Language][]Season
+	MGIDs     map[sp.Language]string
+	MGID      string // Only set by older versions, use SeriesMGID
+	FetchedAt time.Time
+}
+
+func (s Series) SeriesMGID(language sp.Language) string {
+	if mgid, ok := s.MGIDs[language]; ok {
+		return mgid
+	}
+	return s.MGID
+}
+
+// Returned by GetSeries if some languages failed to load.
+type LanguageErrors map[sp.Language]error
+
+func (e LanguageErrors) Error() string {
+	langs := make([]sp.Language, 0, len(e))
+	for k := range e {
+		langs = append(langs, k)
+	}
+	sort.Slice(langs, func(i, j int) bool { return langs[i] < langs[j] })
+	msgs := make([]string, len(langs))
+	for i, v := range langs {
+		msgs[i] = fmt.Sprintf("%v: %v", v, e[v])
+	}
+	return strings.Join(msgs, "; ")
 }
 
-// Gets current region info and season metadata.
-func GetSeries(ctx context.Context) (region sp.RegionInfo, seasons map[sp.Language][]Season, mgid string, err error) {
-	region, err = sp.GetRegionInfo(ctx)
+func (e LanguageErrors) Unwrap() []error {
+	res := make([]error, 0, len(e))
+	for _, v := range e {
+		res = append(res, v)
+	}
+	return res
+}
+
+// Gets region info and the seasons of all languages concurrently. If host
+// is empty, the host is detected automatically. If only some languages
+// fail, the others are returned along with a LanguageErrors.
+func GetSeries(ctx context.Context, host string, extraHosts []sp.HostDefinition) (Series, error) {
+	var region sp.RegionInfo
+	var err error
+	if host == "" {
+		region, err = sp.GetRegionInfo(ctx, extraHosts)
+	} else {
+		region, err = sp.GetRegionInfoForHost(host, extraHosts)
+	}
 	if err != nil {
-		return sp.RegionInfo{}, nil, "", err
+		return Series{}, err
 	}
 
-	seasons = make(map[sp.Language][]Season)
+	res := Series{
+		Region:    region,
+		Seasons:   make(map[sp.Language][]Season),
+		MGIDs:     make(map[sp.Language]string),
+		FetchedAt: time.Now(),
+	}
+	errs := make(LanguageErrors)
 
-	for _, language := range region.AvailableLanguages {
-		var seasonsArr []sp.Season
-		seasonsArr, mgid, err = sp.GetSeasons(ctx, region, language)
-		if err != nil {
-			return sp.RegionInfo{}, nil, "", err
-		}
+	var mtx sync.Mutex
+	var wg sync.WaitGroup
+	for _, language := range region.AvailableLanguages() {
+		language := language
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			seasonsArr, mgid, err := sp.GetSeasons(ctx, region, language)
+
+			mtx.Lock()
+			defer mtx.Unlock()
+			if err != nil {
+				errs[language] = err
+				return
+			}
+			seasons := make([]Season, len(seasonsArr))
+			for i, v := range seasonsArr {
+				seasons[i] = Season{
+					Season: v,
+					Region: region,
+					Index:  i,
+				}
+			}
+			res.Seasons[language] = seasons
+			res.MGIDs[language] = mgid
+		}()
+	}
+	wg.Wait()
 
-		var seasonsConv []Season
-		for i, v := range seasonsArr {
-			seasonsConv = append(seasonsConv, Season{
-				Season: v,
-				Region: region,
-				Index:  i,
-			})
+	if len(errs) == 0 {
+		return res, nil
+	}
+	if len(res.Seasons) == 0 {
+		if ctx.Err() != nil {
+			return Series{}, ctx.Err()
 		}
+		return Series{}, errs
+	}
+	return res, errs
+}
 
-		seasons[language] = seasonsConv
+// Reports whether both have the same seasons, ignoring episodes.
+func (s Series) SameSeasons(other Series) bool {
+	if len(s.Seasons) != len(other.Seasons) {
+		return false
 	}
+	for lang, seasons := range s.Seasons {
+		otherSeasons := other.Seasons[lang]
+		if len(seasons) != len(otherSeasons) {
+			return false
+		}
+		for i := range seasons {
+			if seasons[i].Season != otherSeasons[i].Season {
+				return false
+			}
+		}
+	}
+	return true
+}
 
-	return
+// Reports whether something fetched at fetchedAt should be fetched
+// again. A negative ttl means entries never expire.
+func Stale(fetchedAt time.Time, ttl time.Duration) bool {
+	if ttl < 0 {
+		return false
+	}
+	return time.Since(fetchedAt) > ttl
 }
 
 type Cache struct {
-	Series map[sp.Host]Series
+	Series   map[sp.Host]Series
+	LastHost sp.Host // Host of the last series that was set
 }
 
 func NewCache() *Cache {
@@ -64,3 +170,137 @@ func NewCache() *Cache {
 		Series: make(map[sp.Host]Series),
 	}
 }
+
+// Returns the cached series for host. If host is empty,
+// the series that was set last is returned.
+func (c *Cache) CachedSeries(host string) (Series, bool) {
+	if host == "" {
+		host = string(c.LastHost)
+	}
+	for k, v := range c.Series {
+		if host != "" && strings.EqualFold(strings.TrimPrefix(string(k), "www."), strings.TrimPrefix(host, "www.")) {
+			return v, true
+		}
+	}
+	return Series{}, false
+}
+
+// Replaces the series for s.Region.Host, keeping the episodes of
+// seasons that were already cached. Languages of s.Region missing from
+// s, e.g. because they failed to load, are kept from the cached series.
+func (c *Cache) SetSeries(s Series) {
+	if old, ok := c.Series[s.Region.Host]; ok {
+		for lang, seasons := range s.Seasons {
+			oldSeasons := make(map[int]Season)
+			for _, v := range old.Seasons[lang] {
+				oldSeasons[v.SeasonNumber] = v
+			}
+			for i, v := range seasons {
+				if o, ok := oldSeasons[v.SeasonNumber]; ok && o.Episodes != nil {
+					seasons[i].Episodes = o.Episodes
+					seasons[i].MGID = o.MGID
+					seasons[i].FetchedAt = o.FetchedAt
+				}
+			}
+		}
+		for _, lang := range s.Region.AvailableLanguages() {
+			if _, ok := s.Seasons[lang]; ok {
+				continue
+			}
+			if seasons, ok := old.Seasons[lang]; ok {
+				s.Seasons[lang] = seasons
+				if s.MGIDs == nil {
+					s.MGIDs = make(map[sp.Language]string)
+				}
+				s.MGIDs[lang] = old.SeriesMGID(lang)
+			}
+		}
+	}
+	c.Series[s.Region.Host] = s
+	c.LastHost = s.Region.Host
+}
+
+// Sets the episodes of a season. Does nothing if the series
+// was replaced in a way that season no longer exists.
+// Returns whether the episodes changed.
+//
+// The series is copied on write, since copies of it handed out
+// earlier share its seasons and may still be read.
+func (c *Cache) SetSeasonEpisodes(season Season, episodes []sp.Episode, mgid string) (changed bool) {
+	series := c.Series[season.Region.Host]
+	oldSeasons := series.Seasons[season.Language]
+	if season.Index >= len(oldSeasons) ||
+		oldSeasons[season.Index].SeasonNumber != season.SeasonNumber {
+		return false
+	}
+	seasons := append([]Season(nil), oldSeasons...)
+	s := &seasons[season.Index]
+	changed = !reflect.DeepEqual(s.Episodes, episodes)
+	s.Episodes = episodes
+	s.MGID = mgid
+	s.FetchedAt = time.Now()
+
+	seasonsByLang := make(map[sp.Language][]Season, len(series.Seasons))
+	for k, v := range series.Seasons {
+		seasonsByLang[k] = v
+	}
+	seasonsByLang[season.Language] = seasons
+	series.Seasons = seasonsByLang
+	c.Series[season.Region.Host] = series
+	return changed
+}
+
+// Returns the path of a URL without trailing slash, or
+// an empty string if it's invalid.
+func urlPath(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(u.Path, "/")
+}
+
+// Looks up a cached episode by its URL.
+func (c *Cache) FindEpisodeByURL(episodeURL string) (sp.Episode, bool) {
+	u, err := url.Parse(episodeURL)
+	if err != nil || u.Host == "" {
+		return sp.Episode{}, false
+	}
+	series, ok := c.CachedSeries(u.Host)
+	if !ok {
+		return sp.Episode{}, false
+	}
+	path := urlPath(episodeURL)
+	for _, seasons := range series.Seasons {
+		for _, s := range seasons {
+			for _, ep := range s.Episodes {
+				if urlPath(ep.URL) == path {
+					return ep, true
+				}
+			}
+		}
+	}
+	return sp.Episode{}, false
+}
+
+// Returns a search index over the cached episodes of all languages
+// and the season numbers per language whose episodes aren't cached.
+func (c *Cache) SearchIndex(host sp.Host) (index *sp.SearchIndex, uncached map[sp.Language]map[int]struct{}) {
+	var eps []sp.EpisodeMetadata
+	uncached = make(map[sp.Language]map[int]struct{})
+	for lang, seasons := range c.Series[host].Seasons {
+		for _, s := range seasons {
+			if s.Episodes == nil {
+				if uncached[lang] == nil {
+					uncached[lang] = make(map[int]struct{})
+				}
+				uncached[lang][s.SeasonNumber] = struct{}{}
+				continue
+			}
+			for _, ep := range s.Episodes {
+				eps = append(eps, ep.EpisodeMetadata)
+			}
+		}
+	}
+	return sp.NewSearchIndex(eps), uncached
+}
@@ -0,0 +1,144 @@
+package logic
+
+import (
+	"testing"
+	"time"
+
+	sp "github.com/xypwn/southpark-downloader-ui/pkg/southpark"
+)
+
+func testSeries(host sp.Host, seasonNumbers ...int) Series {
+	region := sp.RegionInfo{
+		Host:      host,
+		Languages: []sp.HostLanguage{{Language: sp.LanguageEnglish}},
+	}
+	seasons := make([]Season, len(seasonNumbers))
+	for i, v := range seasonNumbers {
+		seasons[i] = Season{
+			Season: sp.Season{SeasonNumber: v, Language: sp.LanguageEnglish},
+			Region: region,
+			Index:  i,
+		}
+	}
+	return Series{
+		Region:  region,
+		Seasons: map[sp.Language][]Season{sp.LanguageEnglish: seasons},
+		MGIDs:   map[sp.Language]string{sp.LanguageEnglish: "mgid"},
+	}
+}
+
+func testEpisodes(season int, episodeNumbers ...int) []sp.Episode {
+	res := make([]sp.Episode, len(episodeNumbers))
+	for i, v := range episodeNumbers {
+		res[i] = sp.Episode{EpisodeMetadata: sp.EpisodeMetadata{
+			SeasonNumber:  season,
+			EpisodeNumber: v,
+			Language:      sp.LanguageEnglish,
+		}}
+	}
+	return res
+}
+
+func TestSetSeasonEpisodesCopiesOnWrite(t *testing.T) {
+	c := NewCache()
+	c.SetSeries(testSeries("sp.example", 1, 2))
+	before, _ := c.CachedSeries("sp.example")
+	season := before.Seasons[sp.LanguageEnglish][1]
+
+	if !c.SetSeasonEpisodes(season, testEpisodes(2, 1, 2), "mgid2") {
+		t.Errorf("expected new episodes to be a change")
+	}
+	if c.SetSeasonEpisodes(season, testEpisodes(2, 1, 2), "mgid2") {
+		t.Errorf("expected the same episodes not to be a change")
+	}
+	if eps := before.Seasons[sp.LanguageEnglish][1].Episodes; eps != nil {
+		t.Errorf("expected the earlier copy of the series to be unchanged, got %v", eps)
+	}
+	after, _ := c.CachedSeries("sp.example")
+	if s := after.Seasons[sp.LanguageEnglish][1]; len(s.Episodes) != 2 || s.MGID != "mgid2" || s.FetchedAt.IsZero() {
+		t.Errorf("expected the episodes to be set, got %+v", s)
+	}
+
+	// The season no longer exists
+	c.SetSeries(testSeries("sp.example", 1))
+	if c.SetSeasonEpisodes(season, testEpisodes(2, 1), "") {
+		t.Errorf("expected episodes of a removed season to be ignored")
+	}
+}
+
+func TestStale(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		FetchedAt time.Time
+		TTL       time.Duration
+		Want      bool
+	}{
+		{now.Add(-time.Minute), time.Hour, false},
+		{now.Add(-2 * time.Hour), time.Hour, true},
+		{time.Time{}, time.Hour, true},
+		// Negative TTLs never expire
+		{time.Time{}, -1, false},
+		{now.Add(-1000 * time.Hour), -time.Hour, false},
+	}
+	for _, tt := range tests {
+		if got := Stale(tt.FetchedAt, tt.TTL); got != tt.Want {
+			t.Errorf("Stale(%v ago, %v): expected %v, got %v", time.Since(tt.FetchedAt).Round(time.Minute), tt.TTL, tt.Want, got)
+		}
+	}
+
+	cfg := NewConfig()
+	for _, tt := range []struct {
+		Hours int
+		Want  time.Duration
+	}{
+		{0, DefaultCacheTTL},
+		{-1, -1},
+		{3, 3 * time.Hour},
+	} {
+		cfg.CacheTTLHours = tt.Hours
+		if got := cfg.CacheTTL(); got != tt.Want {
+			t.Errorf("CacheTTLHours %v: expected TTL %v, got %v", tt.Hours, tt.Want, got)
+		}
+	}
+}
+
+func TestSetSeries(t *testing.T) {
+	c := NewCache()
+	old := testSeries("sp.example", 1, 2)
+	old.Region.Languages = append(old.Region.Languages, sp.HostLanguage{Language: sp.LanguageGerman})
+	old.Seasons[sp.LanguageGerman] = []Season{{Season: sp.Season{SeasonNumber: 1, Language: sp.LanguageGerman}}}
+	old.MGIDs[sp.LanguageGerman] = "mgid-de"
+	fetchedAt := time.Now().Add(-time.Hour)
+	old.Seasons[sp.LanguageEnglish][0].Episodes = testEpisodes(1, 1, 2)
+	old.Seasons[sp.LanguageEnglish][0].MGID = "mgid-s1"
+	old.Seasons[sp.LanguageEnglish][0].FetchedAt = fetchedAt
+	c.SetSeries(old)
+
+	// German failed to load, season 3 is new
+	s := testSeries("sp.example", 1, 2, 3)
+	s.Region = old.Region
+	c.SetSeries(s)
+	if c.LastHost != "sp.example" {
+		t.Errorf("expected sp.example to be the last host, got %v", c.LastHost)
+	}
+	got, ok := c.CachedSeries("www.sp.example")
+	if !ok {
+		t.Fatalf("expected the series to be cached")
+	}
+	en := got.Seasons[sp.LanguageEnglish]
+	if len(en) != 3 {
+		t.Fatalf("expected 3 seasons, got %v", len(en))
+	}
+	if len(en[0].Episodes) != 2 || en[0].MGID != "mgid-s1" || !en[0].FetchedAt.Equal(fetchedAt) {
+		t.Errorf("expected the cached episodes of season 1 to be kept, got %+v", en[0])
+	}
+	if en[1].Episodes != nil || en[2].Episodes != nil {
+		t.Errorf("expected seasons 2 and 3 to have no episodes")
+	}
+	if len(got.Seasons[sp.LanguageGerman]) != 1 || got.SeriesMGID(sp.LanguageGerman) != "mgid-de" {
+		t.Errorf("expected the cached German seasons to be kept, got %v", got.Seasons[sp.LanguageGerman])
+	}
+	if !got.SameSeasons(s) {
+		t.Errorf("expected the seasons to be the same as the ones set")
+	}
+}
@@ -1,7 +1,13 @@
 package logic
 
 import (
+	"fmt"
+	"strings"
+	"time"
+
 	"github.com/adrg/xdg"
+
+	sp "github.com/xypwn/southpark-downloader-ui/pkg/southpark"
 )
 
 type Config struct {
@@ -9,6 +15,72 @@ type Config struct {
 	ConcurrentDownloads int
 	MaximumQuality      Quality
 	OutputFilePattern   string
+	VideoCodec          sp.Codec // sp.CodecUnknown for any codec
+	MP4Layout           sp.MP4Layout
+	OutputFormat        sp.OutputFormat
+	Host                string       // Empty to detect the host automatically
+	CustomHosts         []HostConfig // Take precedence over built-in hosts
+	DisablePrefetch     bool         // Don't cache all seasons in the background
+	CacheTTLHours       int          // 0 for the default, negative to never refresh
+	SubscriptionMinutes int          // Interval between subscription checks, 0 for the default
+}
+
+const DefaultCacheTTL = 24 * time.Hour
+
+// How long cached series and episode lists are used before
+// they are fetched again.
+func (c *Config) CacheTTL() time.Duration {
+	switch {
+	case c.CacheTTLHours == 0:
+		return DefaultCacheTTL
+	case c.CacheTTLHours < 0:
+		return -1
+	default:
+		return time.Duration(c.CacheTTLHours) * time.Hour
+	}
+}
+
+const DefaultSubscriptionInterval = time.Hour
+
+// How often subscriptions are checked for new episodes.
+func (c *Config) SubscriptionInterval() time.Duration {
+	if c.SubscriptionMinutes <= 0 {
+		return DefaultSubscriptionInterval
+	}
+	return time.Duration(c.SubscriptionMinutes) * time.Minute
+}
+
+// User-defined host. Each language is given as a string accepted by
+// sp.LanguageFromString, optionally followed by a colon and its URL
+// path prefix, e.g. "DE" or "EN:/en".
+type HostConfig struct {
+	Host      string
+	Languages []string
+}
+
+func (c *Config) CustomHostDefinitions() ([]sp.HostDefinition, error) {
+	var res []sp.HostDefinition
+	for _, h := range c.CustomHosts {
+		def := sp.HostDefinition{
+			Host: sp.Host(h.Host),
+		}
+		for _, l := range h.Languages {
+			langStr, prefix, _ := strings.Cut(l, ":")
+			lang, ok := sp.LanguageFromString(strings.TrimSpace(langStr))
+			if !ok {
+				return nil, fmt.Errorf("custom host %v: unknown language: %v", h.Host, langStr)
+			}
+			def.Languages = append(def.Languages, sp.HostLanguage{
+				Language:   lang,
+				PathPrefix: strings.TrimSpace(prefix),
+			})
+		}
+		if err := def.Validate(); err != nil {
+			return nil, fmt.Errorf("custom host: %w", err)
+		}
+		res = append(res, def)
+	}
+	return res, nil
 }
 
 func NewConfig() *Config {
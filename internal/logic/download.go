@@ -5,8 +5,10 @@ import (
 	"errors"
 	"fmt"
 	"os"
-
+	"path"
+	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/xypwn/southpark-downloader-ui/pkg/asynctask"
 	"github.com/xypwn/southpark-downloader-ui/pkg/data"
@@ -63,6 +65,27 @@ const (
 	DownloadStatusInterrupted
 )
 
+var downloadStatusNames = map[DownloadStatus]string{
+	DownloadStatusWaiting:                 "waiting",
+	DownloadStatusFetchingMetadata:        "fetching_metadata",
+	DownloadStatusDownloadingVideo:        "downloading_video",
+	DownloadStatusDownloadingAudio:        "downloading_audio",
+	DownloadStatusDownloadingSubtitles:    "downloading_subtitles",
+	DownloadStatusPostprocessingVideo:     "postprocessing_video",
+	DownloadStatusPostprocessingSubtitles: "postprocessing_subtitles",
+	DownloadStatusDone:                    "done",
+	DownloadStatusInterrupted:             "interrupted",
+}
+
+// Machine-readable name, e.g. "downloading_video".
+func (s DownloadStatus) MarshalText() ([]byte, error) {
+	name, ok := downloadStatusNames[s]
+	if !ok {
+		return nil, fmt.Errorf("invalid download status: %d", int(s))
+	}
+	return []byte(name), nil
+}
+
 type DownloadProgress struct {
 	Status DownloadStatus
 	Value  float64 // -1 if unable to determine
@@ -96,20 +119,123 @@ func (v DownloadProgress) String() string {
 
 type DownloadParams struct {
 	Episode            sp.Episode
+	MasterURL          string // If set, download this HLS master playlist instead of Episode
 	MaxQuality         Quality
+	VideoCodec         sp.Codec // sp.CodecUnknown for any codec
+	MP4Layout          sp.MP4Layout
+	OutputFormat       sp.OutputFormat
 	TmpDirPath         string
-	OutputVideoPath    string
+	OutputVideoPath    string // A directory for sp.OutputFormatHLS
 	OutputSubtitlePath string
 }
 
+func toValidFilename(s string) string {
+	var result strings.Builder
+	for i := 0; i < len(s); i++ {
+		b := s[i]
+		if ('a' <= b && b <= 'z') ||
+			('A' <= b && b <= 'Z') ||
+			('0' <= b && b <= '9') {
+			result.WriteByte(b)
+		} else {
+			result.WriteByte('_')
+		}
+	}
+	return result.String()
+}
+
+func newDownloadParams(cfg *Config, ep sp.Episode, outputBase string) DownloadParams {
+	outputVideoPath := path.Join(cfg.DownloadPath, outputBase+".mp4")
+	if cfg.OutputFormat == sp.OutputFormatHLS {
+		outputVideoPath = path.Join(cfg.DownloadPath, outputBase)
+	}
+	return DownloadParams{
+		Episode:            ep,
+		MaxQuality:         cfg.MaximumQuality,
+		VideoCodec:         cfg.VideoCodec,
+		MP4Layout:          cfg.MP4Layout,
+		OutputFormat:       cfg.OutputFormat,
+		TmpDirPath:         path.Join(cfg.DownloadPath, "~TMP_"+outputBase),
+		OutputVideoPath:    outputVideoPath,
+		OutputSubtitlePath: path.Join(cfg.DownloadPath, outputBase+".vtt"),
+	}
+}
+
+// Returns the parameters for downloading ep according to cfg.
+func NewEpisodeDownloadParams(cfg *Config, ep sp.Episode) DownloadParams {
+	outputBase := strings.NewReplacer(
+		"$S", fmt.Sprintf("%02v", ep.SeasonNumber),
+		"$E", fmt.Sprintf("%02v", ep.EpisodeNumber),
+		"$L", strings.ReplaceAll(ep.Language.String(), " ", "_"),
+		"$T", toValidFilename(ep.Title),
+		"$Q", cfg.MaximumQuality.String(),
+	).Replace(cfg.OutputFilePattern)
+	return newDownloadParams(cfg, ep, outputBase)
+}
+
+// Returns the parameters for downloading the HLS master playlist at
+// masterURL according to cfg. The output files are named after name.
+func NewURLDownloadParams(cfg *Config, masterURL string, name string) DownloadParams {
+	res := newDownloadParams(cfg, sp.Episode{EpisodeMetadata: sp.EpisodeMetadata{Title: name}}, toValidFilename(name))
+	res.MasterURL = masterURL
+	return res
+}
+
+// Returns the path of the file a media player should open.
+func (p DownloadParams) PlayablePath() string {
+	if p.OutputFormat == sp.OutputFormatHLS {
+		return path.Join(p.OutputVideoPath, "master.m3u8")
+	}
+	// Streams that can't be muxed into MP4 are saved as an HLS folder
+	if _, err := os.Stat(p.OutputVideoPath); err != nil {
+		fallback := path.Join(sp.HLSFallbackPath(p.OutputVideoPath), "master.m3u8")
+		if _, err := os.Stat(fallback); err == nil {
+			return fallback
+		}
+	}
+	return p.OutputVideoPath
+}
+
+// Reports whether the download finished before. An interrupted download
+// may leave a playable partial file, but also leaves its temporary
+// directory behind.
+func (p DownloadParams) Downloaded() bool {
+	if _, err := os.Stat(p.PlayablePath()); err != nil {
+		return false
+	}
+	_, err := os.Stat(p.TmpDirPath)
+	return err != nil
+}
+
 type Download struct {
 	*asynctask.AsyncTask[struct{}, DownloadProgress, struct{}]
 	mtx            sync.RWMutex
+	id             uint64
 	params         DownloadParams
 	progress       *data.Binding[DownloadProgress]
 	progressClient *data.Client[DownloadProgress]
 }
 
+// Passed to the onError function given to Downloads.Add if
+// a download fails. Its text is that of Err.
+type DownloadError struct {
+	ID  uint64 // See Download.ID
+	Err error
+}
+
+func (e *DownloadError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *DownloadError) Unwrap() error {
+	return e.Err
+}
+
+// Unique among the downloads of this process.
+func (dl *Download) ID() uint64 {
+	return dl.id
+}
+
 func (dl *Download) Params() DownloadParams {
 	return dl.params
 }
@@ -130,6 +256,7 @@ type Downloads struct {
 	*data.ListBinding[*Download]
 	client *data.ListClient[*Download]
 	queue  *taskqueue.TaskQueue[*Download]
+	lastID atomic.Uint64
 }
 
 func NewDownloads(cfgClient *data.Client[*Config], onError func(error)) *Downloads {
@@ -174,28 +301,50 @@ func NewDownloads(cfgClient *data.Client[*Config], onError func(error)) *Downloa
 
 func (dls *Downloads) Add(ctx context.Context, params DownloadParams, onError func(error)) *Download {
 	res := &Download{
+		id:       dls.lastID.Add(1),
 		params:   params,
 		progress: data.NewBinding[DownloadProgress](),
 	}
 	res.progressClient = res.progress.NewClient()
 
 	doDownload := func(ctx context.Context, _ struct{}, setProgress func(DownloadProgress)) (struct{}, error) {
-		dl := sp.NewDownloader(
-			ctx,
-			params.Episode,
-			params.TmpDirPath,
-			params.OutputVideoPath,
-			func(fmts []sp.HLSFormat) (sp.HLSFormat, error) {
-				// fmts are already sorted from best to worst
-				for _, v := range fmts {
-					if v.Height <= uint(params.MaxQuality) {
-						return v, nil
-					}
+		selectFormat := func(fmts []sp.HLSFormat) (sp.HLSFormat, error) {
+			if params.VideoCodec != sp.CodecUnknown {
+				fmts = sp.FilterFormatsByVideoCodec(fmts, params.VideoCodec)
+				if len(fmts) == 0 {
+					return sp.HLSFormat{}, fmt.Errorf("no format with video codec %v found", params.VideoCodec)
 				}
-				return sp.HLSFormat{}, fmt.Errorf("no viable format found for maximum quality of %v", params.MaxQuality.String())
-			},
-			params.OutputSubtitlePath,
-		)
+			}
+			// fmts are already sorted from best to worst
+			for _, v := range fmts {
+				if v.Height <= uint(params.MaxQuality) {
+					return v, nil
+				}
+			}
+			return sp.HLSFormat{}, fmt.Errorf("no viable format found for maximum quality of %v", params.MaxQuality.String())
+		}
+		var dl *sp.Downloader
+		if params.MasterURL != "" {
+			dl = sp.NewHLSDownloader(
+				ctx,
+				params.MasterURL,
+				params.TmpDirPath,
+				params.OutputVideoPath,
+				selectFormat,
+				params.OutputSubtitlePath,
+			)
+		} else {
+			dl = sp.NewDownloader(
+				ctx,
+				params.Episode,
+				params.TmpDirPath,
+				params.OutputVideoPath,
+				selectFormat,
+				params.OutputSubtitlePath,
+			)
+		}
+		dl.MP4Layout = params.MP4Layout
+		dl.OutputFormat = params.OutputFormat
 
 		setProgress(DownloadProgress{
 			Status: DownloadStatusWaiting,
@@ -267,7 +416,7 @@ func (dls *Downloads) Add(ctx context.Context, params DownloadParams, onError fu
 				})
 
 				if !errors.Is(err, context.Canceled) {
-					onError(err)
+					onError(&DownloadError{ID: res.id, Err: err})
 				}
 			}
 		},
@@ -285,6 +434,101 @@ func (dls *Downloads) Add(ctx context.Context, params DownloadParams, onError fu
 	return res
 }
 
+// Returns the download with the given ID, or nil.
+func (dls *Downloads) Find(id uint64) *Download {
+	var res *Download
+	dls.client.Examine(func(arr []*Download) {
+		for _, v := range arr {
+			if v.id == id {
+				res = v
+				break
+			}
+		}
+	})
+	return res
+}
+
+// Moves dl to index pos of the list, which is clamped to the list's
+// bounds. Waiting downloads closer to the front are started first.
+// Returns false if dl isn't in the list.
+func (dls *Downloads) Move(dl *Download, pos int) bool {
+	found := false
+	dls.client.Change(func(arr []*Download) []*Download {
+		for i, v := range arr {
+			if v == dl {
+				arr = append(arr[:i], arr[i+1:]...)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return arr
+		}
+		if pos < 0 {
+			pos = 0
+		} else if pos > len(arr) {
+			pos = len(arr)
+		}
+		arr = append(arr, nil)
+		copy(arr[pos+1:], arr[pos:])
+		arr[pos] = dl
+		return arr
+	})
+	return found
+}
+
+// Removes a download that isn't running from the list.
+// Returns false if dl isn't in the list or is running.
+func (dls *Downloads) Remove(dl *Download) bool {
+	if st := dl.Progress().Status; st != DownloadStatusDone && st != DownloadStatusInterrupted {
+		return false
+	}
+	found := false
+	dls.client.Change(func(arr []*Download) []*Download {
+		for i, v := range arr {
+			if v == dl {
+				found = true
+				return append(arr[:i], arr[i+1:]...)
+			}
+		}
+		return arr
+	})
+	return found
+}
+
+// Queues and starts downloads of the given episodes using the settings
+// of cfg. Episodes that are unavailable, already queued or already
+// downloaded are skipped. Returns the new downloads.
+func (dls *Downloads) AddEpisodes(ctx context.Context, cfg *Config, eps []sp.Episode, onError func(error)) (added []*Download) {
+	var queued []*Download
+	dls.client.Examine(func(arr []*Download) {
+		queued = append(queued, arr...)
+	})
+	isQueued := func(ep sp.Episode) bool {
+		for _, v := range queued {
+			if v.Params().MasterURL == "" && v.Params().Episode.Is(ep.EpisodeMetadata) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, ep := range eps {
+		if ep.Unavailable || isQueued(ep) {
+			continue
+		}
+		params := NewEpisodeDownloadParams(cfg, ep)
+		if params.Downloaded() {
+			continue
+		}
+		dl := dls.Add(ctx, params, onError)
+		dl.Go(struct{}{})
+		queued = append(queued, dl)
+		added = append(added, dl)
+	}
+	return added
+}
+
 // Used for caching downloads
 type DownloadInfo struct {
 	Params DownloadParams
@@ -305,7 +549,7 @@ func ConnectDownloadsToDownloadsInfo(ctx context.Context, dls *Downloads, dlInfo
 		for _, v := range di {
 			tmpDirPresent := false
 			downloadFilesPresent := false
-			if info, err := os.Stat(v.Params.OutputVideoPath); err == nil && !info.IsDir() {
+			if info, err := os.Stat(v.Params.PlayablePath()); err == nil && !info.IsDir() {
 				if info, err := os.Stat(v.Params.OutputSubtitlePath); err == nil && !info.IsDir() {
 					downloadFilesPresent = true
 				}
@@ -0,0 +1,79 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+
+	sp "github.com/xypwn/southpark-downloader-ui/pkg/southpark"
+)
+
+var userMessages = []struct {
+	Err     error
+	Title   string
+	Msg     string
+	Details bool // Whether to append the error text, e.g. for bug reports
+}{
+	{sp.ErrGeoBlocked, "Not available in your region",
+		"This content isn't available from your location. Try a VPN or pick a different host in Preferences > Region.", false},
+	{sp.ErrEpisodeLocked, "Episode unavailable",
+		"This episode is locked or no longer available due to date or rights restrictions.", false},
+	{sp.ErrRegionUnsupported, "Region not supported",
+		"Your South Park website region isn't supported. Pick or define a host in Preferences > Region.", false},
+	{sp.ErrSiteLayoutChanged, "Website changed",
+		"The South Park website seems to have changed. Please run the self-check in Preferences > Region and attach its report to a bug report.", true},
+	{sp.ErrRateLimited, "Too many requests",
+		"The server is rate limiting requests. Wait a few minutes or reduce the number of concurrent downloads.", false},
+	{ErrHostMismatch, "Different website",
+		"This URL belongs to a different South Park website than the one episodes are loaded from. Pick its host in Preferences > Region.", false},
+	{ErrSubscriptionsLocked, "Checked elsewhere",
+		"Subscriptions are checked by another program using the same data, e.g. southpark-dl serve. Close it to check them here.", false},
+	{sp.ErrDecryptFailed, "Decryption failed",
+		"A video segment couldn't be decrypted, it may have been corrupted in transit. Please try again.", false},
+}
+
+// Returns a message the user can act on if err is a known error
+// condition, as opposed to a bug. If the error text helps with a bug
+// report, it is appended to msg.
+func UserMessage(err error) (title, msg string, ok bool) {
+	return userMessage(err, true)
+}
+
+// Like UserMessage, but never appends the error text. Use it if the
+// error text is shown anyway.
+func ShortUserMessage(err error) (title, msg string, ok bool) {
+	return userMessage(err, false)
+}
+
+func userMessage(err error, details bool) (title, msg string, ok bool) {
+	for _, v := range userMessages {
+		if errors.Is(err, v.Err) {
+			msg = v.Msg
+			if details && v.Details {
+				msg += "\n\nDetails: " + err.Error()
+			}
+			return v.Title, msg, true
+		}
+	}
+	if IsNetworkError(err) {
+		return "No connection", "Unable to reach the server. Please check your internet connection.", true
+	}
+	if vserr := (&sp.VideoServiceError{}); errors.As(err, &vserr) {
+		msg := vserr.Error()
+		if len(msg) > 0 {
+			msg = strings.ToUpper(string(msg[0])) + msg[1:]
+		}
+		return "Video unavailable", msg + ".", true
+	}
+	return "", "", false
+}
+
+// Reports whether err is caused by a server being unreachable,
+// e.g. because there is no internet connection.
+func IsNetworkError(err error) bool {
+	var urlErr *url.Error
+	return errors.As(err, &urlErr) &&
+		!errors.Is(err, context.Canceled) &&
+		!errors.Is(err, context.DeadlineExceeded)
+}
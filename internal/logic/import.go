@@ -0,0 +1,165 @@
+package logic
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xypwn/southpark-downloader-ui/pkg/data"
+	sp "github.com/xypwn/southpark-downloader-ui/pkg/southpark"
+)
+
+// A non-empty line of an import list.
+type ImportEntry struct {
+	Line      int    // From 1
+	Text      string // Without comments and surrounding space
+	URL       string // Set if the line is a URL
+	Selection sp.Selection
+	Err       error // Set if the line couldn't be parsed or resolved
+}
+
+// Parses an import list, e.g. a wishlist file or pasted text. Every line
+// holds an episode URL, a URL without "https://" or a selection
+// expression (see sp.Selection). Text after a '#' is ignored.
+func ParseImport(text string) []ImportEntry {
+	var res []ImportEntry
+	sc := bufio.NewScanner(strings.NewReader(text))
+	for line := 1; sc.Scan(); line++ {
+		s := sc.Text()
+		if i := strings.IndexByte(s, '#'); i != -1 {
+			s = s[:i]
+		}
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		e := ImportEntry{Line: line, Text: s}
+		if u, ok := ParseURL(s); ok {
+			e.URL = u
+		} else {
+			e.Selection, e.Err = sp.ParseSelection(s)
+		}
+		res = append(res, e)
+	}
+	return res
+}
+
+type ImportOptions struct {
+	Host       string // Host of the series selections refer to (see Cache.CachedSeries)
+	ExtraHosts []sp.HostDefinition
+	Language   sp.Language // Language of selection terms without one
+	TTL        time.Duration
+}
+
+// Resolves the entries of an import list. URLs of cached episodes are
+// taken from the cache, other URLs are fetched. If there are selections
+// and no series is cached for opts.Host, the series is fetched and
+// cached. Returns the episodes in order of the entries without
+// duplicates and the entries that failed, with Err set.
+func ResolveImport(ctx context.Context, cacheClient *data.Client[*Cache], entries []ImportEntry, opts ImportOptions) (episodes []sp.Episode, failed []ImportEntry) {
+	results := make([][]sp.Episode, len(entries))
+	errs := make([]error, len(entries))
+
+	var series Series
+	var seriesErr error
+	var seriesOnce sync.Once
+	getSeries := func() (Series, error) {
+		seriesOnce.Do(func() {
+			var ok bool
+			cacheClient.Examine(func(c *Cache) {
+				series, ok = c.CachedSeries(opts.Host)
+			})
+			if ok {
+				return
+			}
+			series, seriesErr = GetSeries(ctx, opts.Host, opts.ExtraHosts)
+			if len(series.Seasons) == 0 {
+				return
+			}
+			seriesErr = nil
+			cacheClient.Change(func(c *Cache) *Cache {
+				c.SetSeries(series)
+				series = c.Series[series.Region.Host]
+				return c
+			})
+		})
+		return series, seriesErr
+	}
+
+	sem := make(chan struct{}, resolveConcurrency)
+	var wg sync.WaitGroup
+	for i, e := range entries {
+		if e.Err != nil {
+			errs[i] = e.Err
+			continue
+		}
+		i, e := i, e
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				errs[i] = ctx.Err()
+				return
+			}
+			defer func() { <-sem }()
+
+			if e.URL != "" {
+				var ep sp.Episode
+				var ok bool
+				cacheClient.Examine(func(c *Cache) {
+					ep, ok = c.FindEpisodeByURL(e.URL)
+				})
+				if !ok {
+					var err error
+					ep, err = GetEpisodeByURL(ctx, e.URL, opts.ExtraHosts)
+					if err != nil {
+						errs[i] = err
+						return
+					}
+				}
+				results[i] = []sp.Episode{ep}
+				return
+			}
+
+			series, err := getSeries()
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			eps, err := ResolveSelection(ctx, cacheClient, series, e.Selection, opts.Language, opts.TTL)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			if len(eps) == 0 {
+				errs[i] = fmt.Errorf("no episodes match \"%v\"", e.Text)
+				return
+			}
+			results[i] = eps
+		}()
+	}
+	wg.Wait()
+
+	for i, e := range entries {
+		if errs[i] != nil {
+			e.Err = errs[i]
+			failed = append(failed, e)
+			continue
+		}
+	eps:
+		for _, ep := range results[i] {
+			for _, v := range episodes {
+				if v.Is(ep.EpisodeMetadata) {
+					continue eps
+				}
+			}
+			episodes = append(episodes, ep)
+		}
+	}
+	return episodes, failed
+}
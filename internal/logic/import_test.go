@@ -0,0 +1,95 @@
+package logic
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/xypwn/southpark-downloader-ui/pkg/data"
+	sp "github.com/xypwn/southpark-downloader-ui/pkg/southpark"
+)
+
+func TestParseImport(t *testing.T) {
+	text := "# Wishlist\n" +
+		"\n" +
+		"https://sp.example/episodes/abc/s01e01  # The first one\n" +
+		"sp.example/episodes/def/s01e02\n" +
+		"  S02E01-E03, S03  \n" +
+		"S99E\n"
+	want := []struct {
+		Line int
+		URL  string
+		Err  bool
+	}{
+		{3, "https://sp.example/episodes/abc/s01e01", false},
+		{4, "https://sp.example/episodes/def/s01e02", false},
+		{5, "", false},
+		{6, "", true},
+	}
+
+	entries := ParseImport(text)
+	if len(entries) != len(want) {
+		t.Fatalf("expected %v entries, got %v", len(want), len(entries))
+	}
+	for i, e := range entries {
+		if e.Line != want[i].Line || e.URL != want[i].URL || (e.Err != nil) != want[i].Err {
+			t.Errorf("entry %v: expected %+v, got %+v", i, want[i], e)
+		}
+	}
+	if entries[2].Text != "S02E01-E03, S03" {
+		t.Errorf("expected the text to be trimmed, got %q", entries[2].Text)
+	}
+}
+
+func TestResolveImport(t *testing.T) {
+	series := testSeries("sp.example", 1, 2)
+	for i, s := range series.Seasons[sp.LanguageEnglish] {
+		eps := testEpisodes(s.SeasonNumber, 1, 2)
+		for j := range eps {
+			eps[j].URL = fmt.Sprintf("https://sp.example/episodes/s%02de%02d", s.SeasonNumber, j+1)
+		}
+		series.Seasons[sp.LanguageEnglish][i].Episodes = eps
+	}
+	cacheClient := data.NewBinding[*Cache]().NewClient()
+	cacheClient.Change(func(*Cache) *Cache {
+		c := NewCache()
+		c.SetSeries(series)
+		return c
+	})
+
+	entries := ParseImport(strings.Join([]string{
+		"sp.example/episodes/s02e01",
+		"S01E02",
+		"S02", // S02E01 is already listed
+		"S05",
+		"S01E",
+		"https://sp.example/episodes/s01e02", // Listed through the selection
+	}, "\n"))
+	eps, failed := ResolveImport(context.Background(), cacheClient, entries, ImportOptions{
+		Host:     "sp.example",
+		Language: sp.LanguageEnglish,
+		TTL:      -1, // Only use the cache
+	})
+
+	var got []string
+	for _, v := range eps {
+		got = append(got, fmt.Sprintf("S%vE%v", v.SeasonNumber, v.EpisodeNumber))
+	}
+	if want := "[S2E1 S1E2 S2E2]"; fmt.Sprint(got) != want {
+		t.Errorf("expected episodes %v, got %v", want, got)
+	}
+	var failedLines []int
+	for _, v := range failed {
+		if v.Err == nil {
+			t.Errorf("line %v: expected an error", v.Line)
+		}
+		failedLines = append(failedLines, v.Line)
+	}
+	if want := "[4 5]"; fmt.Sprint(failedLines) != want {
+		t.Errorf("expected lines %v to fail, got %v", want, failedLines)
+	}
+	if len(failed) > 0 && failed[0].Err.Error() != `no episodes match "S05"` {
+		t.Errorf("expected no episodes to match S05, got %v", failed[0].Err)
+	}
+}
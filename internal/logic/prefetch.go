@@ -0,0 +1,154 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/xypwn/southpark-downloader-ui/pkg/data"
+	sp "github.com/xypwn/southpark-downloader-ui/pkg/southpark"
+)
+
+type seasonKey struct {
+	Host         sp.Host
+	Language     sp.Language
+	SeasonNumber int
+}
+
+type seasonFetch struct {
+	done     chan struct{}
+	episodes []sp.Episode
+	changed  bool
+	err      error
+}
+
+var (
+	seasonFetchesMtx sync.Mutex
+	seasonFetches    = make(map[seasonKey]*seasonFetch)
+)
+
+// Fetches the episodes of season and stores them in the cache, if
+// cacheClient isn't nil. If the season is already being fetched, e.g.
+// by PrefetchSeasons, waits for that result instead of fetching it
+// again. changed reports whether the cached episodes changed.
+func FetchSeason(ctx context.Context, cacheClient *data.Client[*Cache], season Season) (episodes []sp.Episode, changed bool, err error) {
+	key := seasonKey{season.Region.Host, season.Language, season.SeasonNumber}
+	for {
+		seasonFetchesMtx.Lock()
+		f, ok := seasonFetches[key]
+		if !ok {
+			f = &seasonFetch{done: make(chan struct{})}
+			seasonFetches[key] = f
+			seasonFetchesMtx.Unlock()
+
+			eps, mgid, err := GetSeason(ctx, season.Season)
+			if err == nil && cacheClient != nil {
+				cacheClient.Change(func(c *Cache) *Cache {
+					f.changed = c.SetSeasonEpisodes(season, eps, mgid)
+					return c
+				})
+			}
+			f.episodes, f.err = eps, err
+
+			seasonFetchesMtx.Lock()
+			delete(seasonFetches, key)
+			seasonFetchesMtx.Unlock()
+			close(f.done)
+			return f.episodes, f.changed, f.err
+		}
+		seasonFetchesMtx.Unlock()
+
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+		if ctx.Err() == nil && (errors.Is(f.err, context.Canceled) || errors.Is(f.err, context.DeadlineExceeded)) {
+			// The other caller gave up, try again
+			continue
+		}
+		return f.episodes, f.changed, f.err
+	}
+}
+
+type PrefetchProgress struct {
+	Done   int
+	Failed int
+	Total  int
+}
+
+// Fetches the episodes of every season in every language of host
+// that aren't cached yet or are older than ttl, using up to
+// concurrency requests at a time.
+// Seasons that fail are skipped, the last error is returned after all
+// other seasons are done. onProgress may be nil.
+func PrefetchSeasons(
+	ctx context.Context,
+	cacheClient *data.Client[*Cache],
+	host sp.Host,
+	ttl time.Duration,
+	concurrency int,
+	onProgress func(PrefetchProgress),
+) error {
+	var jobs []Season
+	cacheClient.Examine(func(c *Cache) {
+		for _, seasons := range c.Series[host].Seasons {
+			for _, s := range seasons {
+				if s.Episodes == nil || Stale(s.FetchedAt, ttl) {
+					s.Episodes = nil
+					jobs = append(jobs, s)
+				}
+			}
+		}
+	})
+
+	var mtx sync.Mutex
+	progress := PrefetchProgress{Total: len(jobs)}
+	var lastErr error
+	if onProgress != nil {
+		onProgress(progress)
+	}
+
+	jobCh := make(chan Season)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobCh {
+				_, _, err := FetchSeason(ctx, cacheClient, job)
+
+				mtx.Lock()
+				if err != nil {
+					progress.Failed++
+					lastErr = fmt.Errorf("%v season %v: %w", job.Language, job.SeasonNumber, err)
+				} else {
+					progress.Done++
+				}
+				p := progress
+				mtx.Unlock()
+				if onProgress != nil && ctx.Err() == nil {
+					onProgress(p)
+				}
+			}
+		}()
+	}
+
+loop:
+	for _, job := range jobs {
+		select {
+		case jobCh <- job:
+		case <-ctx.Done():
+			break loop
+		}
+	}
+	close(jobCh)
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return lastErr
+}
@@ -0,0 +1,302 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xypwn/southpark-downloader-ui/pkg/data"
+	"github.com/xypwn/southpark-downloader-ui/pkg/httputils"
+	sp "github.com/xypwn/southpark-downloader-ui/pkg/southpark"
+)
+
+var (
+	ErrLanguageUnavailable = errors.New("language is not available on this website")
+	ErrHostMismatch        = errors.New("URL belongs to a different website")
+)
+
+// Looks up a season of the given language by its number.
+func (s Series) FindSeason(language sp.Language, number int) (Season, bool) {
+	for _, v := range s.Seasons[language] {
+		if v.SeasonNumber == number {
+			return v, true
+		}
+	}
+	return Season{}, false
+}
+
+// Returns s as an absolute URL if it looks like one,
+// e.g. "https://southpark.de/..." or "southpark.de/...".
+func ParseURL(s string) (string, bool) {
+	if strings.ContainsAny(s, " \t,") {
+		return "", false
+	}
+	if !strings.HasPrefix(s, "http://") && !strings.HasPrefix(s, "https://") {
+		host, _, _ := strings.Cut(s, "/")
+		if !strings.Contains(host, ".") {
+			return "", false
+		}
+		s = "https://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return "", false
+	}
+	return u.String(), true
+}
+
+// Returns the region of the URL's host and the URL. URLs on unknown
+// hosts, e.g. short links, are followed to where they redirect, in
+// which case the URL they redirect to is returned.
+func regionForURL(ctx context.Context, rawURL string, extraHosts []sp.HostDefinition) (sp.RegionInfo, string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return sp.RegionInfo{}, "", fmt.Errorf("parse URL: %w", err)
+	}
+	region, err := sp.GetRegionInfoForHost(u.Host, extraHosts)
+	if errors.Is(err, sp.ErrRegionUnsupported) {
+		target, redirErr := followRedirects(ctx, rawURL)
+		if redirErr != nil {
+			return sp.RegionInfo{}, "", fmt.Errorf("follow redirects: %w", redirErr)
+		}
+		if target != rawURL {
+			return regionForURL(ctx, target, extraHosts)
+		}
+	}
+	if err != nil {
+		return sp.RegionInfo{}, "", err
+	}
+	return region, rawURL, nil
+}
+
+// Gets the episode at episodeURL. The region is determined by the
+// URL's host (see regionForURL).
+func GetEpisodeByURL(ctx context.Context, episodeURL string, extraHosts []sp.HostDefinition) (sp.Episode, error) {
+	region, episodeURL, err := regionForURL(ctx, episodeURL, extraHosts)
+	if err != nil {
+		return sp.Episode{}, err
+	}
+	return sp.GetEpisode(ctx, region, episodeURL)
+}
+
+// What a URL entered by the user refers to.
+type URLContent struct {
+	IsSeason bool
+	Season   Season       // Only set if IsSeason
+	Episodes []sp.Episode // The episode or the episodes of the season
+}
+
+// Resolves an episode or season URL of series. Returns ErrHostMismatch
+// if the URL belongs to a different host than series. Episodes are taken
+// from the cache if possible, as in ResolveSelection.
+func ResolveURL(
+	ctx context.Context,
+	cacheClient *data.Client[*Cache],
+	series Series,
+	rawURL string,
+	extraHosts []sp.HostDefinition,
+	ttl time.Duration,
+) (URLContent, error) {
+	region, rawURL, err := regionForURL(ctx, rawURL, extraHosts)
+	if err != nil {
+		return URLContent{}, err
+	}
+	if region.Host != series.Region.Host {
+		return URLContent{}, fmt.Errorf("%w: URL is on %v, but episodes are from %v", ErrHostMismatch, region.Host, series.Region.Host)
+	}
+
+	path := urlPath(rawURL)
+	for _, seasons := range series.Seasons {
+		for _, s := range seasons {
+			if urlPath(s.URL) != path {
+				continue
+			}
+			if s.Episodes != nil && !Stale(s.FetchedAt, ttl) {
+				return URLContent{IsSeason: true, Season: s, Episodes: s.Episodes}, nil
+			}
+			eps, _, err := FetchSeason(ctx, cacheClient, s)
+			if err != nil {
+				return URLContent{}, err
+			}
+			return URLContent{IsSeason: true, Season: s, Episodes: eps}, nil
+		}
+	}
+
+	var ep sp.Episode
+	var ok bool
+	cacheClient.Examine(func(c *Cache) {
+		ep, ok = c.FindEpisodeByURL(rawURL)
+	})
+	if !ok {
+		ep, err = sp.GetEpisode(ctx, region, rawURL)
+		if err != nil {
+			return URLContent{}, err
+		}
+	}
+	return URLContent{Episodes: []sp.Episode{ep}}, nil
+}
+
+// Returns the URL that u finally redirects to.
+func followRedirects(ctx context.Context, u string) (string, error) {
+	resp, err := httputils.GetWithContext(ctx, u)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return resp.Request.URL.String(), nil
+}
+
+// Max. number of seasons fetched at once by ResolveSelection.
+const resolveConcurrency = 4
+
+// Returns the episodes of series selected by sel. Terms without a
+// language select from defaultLanguage. Seasons that are cached in
+// series and not older than ttl are taken from there, the others are
+// fetched and, if cacheClient isn't nil, cached. If the newest season
+// has no available episodes yet, "latest" falls back to earlier ones.
+func ResolveSelection(
+	ctx context.Context,
+	cacheClient *data.Client[*Cache],
+	series Series,
+	sel sp.Selection,
+	defaultLanguage sp.Language,
+	ttl time.Duration,
+) ([]sp.Episode, error) {
+	seasonEps := make(map[seasonKey][]sp.Episode)
+	key := func(s Season) seasonKey {
+		return seasonKey{s.Region.Host, s.Language, s.SeasonNumber}
+	}
+	var jobs []Season
+	for _, lang := range sel.Languages(defaultLanguage) {
+		seasons, ok := series.Seasons[lang]
+		if !ok {
+			return nil, fmt.Errorf("%v: %w", lang, ErrLanguageUnavailable)
+		}
+		latest := 0
+		for _, s := range seasons {
+			if s.SeasonNumber > latest {
+				latest = s.SeasonNumber
+			}
+		}
+		for _, s := range seasons {
+			if !sel.SelectsSeason(defaultLanguage, lang, s.SeasonNumber, latest) {
+				continue
+			}
+			if s.Episodes != nil && !Stale(s.FetchedAt, ttl) {
+				seasonEps[key(s)] = s.Episodes
+			} else {
+				jobs = append(jobs, s)
+			}
+		}
+	}
+
+	var mtx sync.Mutex
+	var firstErr error
+	sem := make(chan struct{}, resolveConcurrency)
+	var wg sync.WaitGroup
+	for _, job := range jobs {
+		job := job
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			defer func() { <-sem }()
+
+			eps, _, err := FetchSeason(ctx, cacheClient, job)
+			mtx.Lock()
+			defer mtx.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("%v season %v: %w", job.Language, job.SeasonNumber, err)
+				}
+				return
+			}
+			seasonEps[key(job)] = eps
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	// A season is often listed before its first episode is out
+	for _, lang := range sel.Languages(defaultLanguage) {
+		if !sel.SelectsLatest(defaultLanguage, lang) {
+			continue
+		}
+		seasons := append([]Season(nil), series.Seasons[lang]...)
+		sort.Slice(seasons, func(i, j int) bool {
+			return seasons[i].SeasonNumber > seasons[j].SeasonNumber
+		})
+		for _, s := range seasons {
+			eps, ok := seasonEps[key(s)]
+			if !ok {
+				if s.Episodes != nil && !Stale(s.FetchedAt, ttl) {
+					eps = s.Episodes
+				} else {
+					var err error
+					eps, _, err = FetchSeason(ctx, cacheClient, s)
+					if err != nil {
+						return nil, fmt.Errorf("%v season %v: %w", s.Language, s.SeasonNumber, err)
+					}
+				}
+				seasonEps[key(s)] = eps
+			}
+			if anyAvailable(eps) {
+				break
+			}
+		}
+	}
+
+	var eps []sp.Episode
+	for _, v := range seasonEps {
+		eps = append(eps, v...)
+	}
+	return sel.Select(defaultLanguage, eps), nil
+}
+
+func anyAvailable(eps []sp.Episode) bool {
+	for _, v := range eps {
+		if !v.Unavailable {
+			return true
+		}
+	}
+	return false
+}
+
+// Parses and resolves a selection expression against the series that
+// is cached for host (see Cache.CachedSeries).
+func ResolveSelectionString(
+	ctx context.Context,
+	cacheClient *data.Client[*Cache],
+	host string,
+	expr string,
+	defaultLanguage sp.Language,
+	ttl time.Duration,
+) ([]sp.Episode, error) {
+	sel, err := sp.ParseSelection(expr)
+	if err != nil {
+		return nil, err
+	}
+	var series Series
+	var ok bool
+	cacheClient.Examine(func(c *Cache) {
+		series, ok = c.CachedSeries(host)
+	})
+	if !ok {
+		return nil, errors.New("no series loaded")
+	}
+	return ResolveSelection(ctx, cacheClient, series, sel, defaultLanguage, ttl)
+}
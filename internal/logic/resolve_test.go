@@ -0,0 +1,60 @@
+package logic
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	sp "github.com/xypwn/southpark-downloader-ui/pkg/southpark"
+)
+
+func TestResolveSelectionLatest(t *testing.T) {
+	series := testSeries("sp.example", 1, 2, 3)
+	seasons := series.Seasons[sp.LanguageEnglish]
+	for i := range seasons {
+		seasons[i].Episodes = testEpisodes(seasons[i].SeasonNumber, 1, 2)
+	}
+	// Season 3 is listed, but none of its episodes are out yet
+	for i := range seasons[2].Episodes {
+		seasons[2].Episodes[i].Unavailable = true
+	}
+
+	tests := []struct {
+		Expr string
+		Want string
+	}{
+		{"latest", "[S2E2]"},
+		{"S01E01, latest", "[S1E1 S2E2]"},
+		{"S03E01", "[S3E1]"},
+	}
+	for _, tt := range tests {
+		sel, err := sp.ParseSelection(tt.Expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// A negative TTL only uses the cached episodes
+		eps, err := ResolveSelection(context.Background(), nil, series, sel, sp.LanguageEnglish, -1)
+		if err != nil {
+			t.Errorf("%q: %v", tt.Expr, err)
+			continue
+		}
+		var got []string
+		for _, v := range eps {
+			got = append(got, fmt.Sprintf("S%vE%v", v.SeasonNumber, v.EpisodeNumber))
+		}
+		if fmt.Sprint(got) != tt.Want {
+			t.Errorf("%q: expected %v, got %v", tt.Expr, tt.Want, got)
+		}
+	}
+
+	// No season has available episodes
+	for i := range seasons {
+		for j := range seasons[i].Episodes {
+			seasons[i].Episodes[j].Unavailable = true
+		}
+	}
+	sel, _ := sp.ParseSelection("latest")
+	if eps, err := ResolveSelection(context.Background(), nil, series, sel, sp.LanguageEnglish, -1); err != nil || len(eps) != 0 {
+		t.Errorf("expected no episodes, got %v (%v)", eps, err)
+	}
+}
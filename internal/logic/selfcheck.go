@@ -0,0 +1,16 @@
+package logic
+
+import (
+	"context"
+
+	sp "github.com/xypwn/southpark-downloader-ui/pkg/southpark"
+)
+
+// Runs sp.SelfCheck using the host settings from cfg.
+func RunSelfCheck(ctx context.Context, cfg *Config, onStep func(sp.SelfCheckStep)) (sp.SelfCheckReport, error) {
+	extraHosts, err := cfg.CustomHostDefinitions()
+	if err != nil {
+		return sp.SelfCheckReport{}, err
+	}
+	return sp.SelfCheck(ctx, cfg.Host, extraHosts, onStep), nil
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"path"
 	"sync"
+	"time"
 
 	"github.com/xypwn/southpark-downloader-ui/pkg/data"
 )
@@ -76,6 +77,26 @@ func NewStorageItem[T any](s *Storage, id string, getDefault func() T, onError f
 	}, nil
 }
 
+// Reads the item with the given ID from the storage at pathBase without
+// creating any files or directories, e.g. for a read-only check. Returns
+// the default if the item doesn't exist.
+func ReadStorageItem[T any](pathBase string, id string, getDefault func() T) (T, error) {
+	data, err := os.ReadFile(path.Join(pathBase, id+".json"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return getDefault(), nil
+		}
+		var zero T
+		return zero, err
+	}
+	var v T
+	if err := json.Unmarshal(data, &v); err != nil {
+		var zero T
+		return zero, err
+	}
+	return v, nil
+}
+
 type Storage struct {
 	pathBase string
 	items    map[string]struct{}
@@ -92,3 +113,81 @@ func NewStorage(pathBase string) (*Storage, error) {
 	}
 	return res, nil
 }
+
+// Lock files that weren't refreshed for this long are taken over,
+// since the process holding them probably exited without removing them.
+const staleLockAge = 5 * time.Minute
+
+// A lock file in a storage directory, so only one of the processes
+// sharing the directory does something. Whoever holds it needs to call
+// TryLock at least every few minutes to keep it.
+type StorageLock struct {
+	mtx   sync.Mutex
+	path  string
+	token string // Written to the file, to tell if it was taken over
+	held  bool
+}
+
+func (s *Storage) NewLock(name string) *StorageLock {
+	return &StorageLock{
+		path:  path.Join(s.pathBase, name+".lock"),
+		token: fmt.Sprintf("%v-%v", os.Getpid(), time.Now().UnixNano()),
+	}
+}
+
+// Acquires the lock or refreshes it if it's held already.
+// Returns whether it is held.
+func (l *StorageLock) TryLock() bool {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if l.held {
+		if data, err := os.ReadFile(l.path); err == nil && string(data) == l.token {
+			now := time.Now()
+			if os.Chtimes(l.path, now, now) == nil {
+				return true
+			}
+		}
+		// Taken over or removed
+		l.held = false
+	}
+
+	if info, err := os.Stat(l.path); err == nil && time.Since(info.ModTime()) > staleLockAge {
+		os.Remove(l.path)
+	}
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return false
+	}
+	_, err = f.WriteString(l.token)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(l.path)
+		return false
+	}
+	l.held = true
+	return true
+}
+
+// Reports whether the lock was held the last time it was acquired or
+// refreshed.
+func (l *StorageLock) Held() bool {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return l.held
+}
+
+// Removes the lock file if the lock is held.
+func (l *StorageLock) Unlock() {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if !l.held {
+		return
+	}
+	l.held = false
+	if data, err := os.ReadFile(l.path); err == nil && string(data) == l.token {
+		os.Remove(l.path)
+	}
+}
@@ -0,0 +1,91 @@
+package logic
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestStorageLock(t *testing.T) {
+	dir := t.TempDir()
+	// Two processes sharing a storage directory
+	s1, err := NewStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s2, err := NewStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l1 := s1.NewLock("test")
+	l2 := s2.NewLock("test")
+
+	if !l1.TryLock() || !l1.Held() {
+		t.Fatalf("expected the first lock to be acquired")
+	}
+	if !l1.TryLock() {
+		t.Errorf("expected the lock to be refreshed")
+	}
+	if l2.TryLock() || l2.Held() {
+		t.Errorf("expected the lock to be held by the first process")
+	}
+
+	l1.Unlock()
+	if !l2.TryLock() {
+		t.Fatalf("expected the released lock to be acquired")
+	}
+	// Not the holder's file anymore
+	l1.Unlock()
+	if _, err := os.Stat(path.Join(dir, "test.lock")); err != nil {
+		t.Errorf("expected the lock file to stay: %v", err)
+	}
+
+	// The holder stopped refreshing
+	stale := time.Now().Add(-2 * staleLockAge)
+	if err := os.Chtimes(path.Join(dir, "test.lock"), stale, stale); err != nil {
+		t.Fatal(err)
+	}
+	if !l1.TryLock() {
+		t.Fatalf("expected a stale lock to be taken over")
+	}
+	if l2.TryLock() || l2.Held() {
+		t.Errorf("expected the previous holder to notice it lost the lock")
+	}
+	l1.Unlock()
+	if _, err := os.Stat(path.Join(dir, "test.lock")); !os.IsNotExist(err) {
+		t.Errorf("expected the lock file to be removed, got %v", err)
+	}
+}
+
+func TestReadStorageItem(t *testing.T) {
+	dir := path.Join(t.TempDir(), "data")
+	v, err := ReadStorageItem(dir, "config", NewConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.ConcurrentDownloads != NewConfig().ConcurrentDownloads {
+		t.Errorf("expected the default config, got %+v", v)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("expected the directory not to be created, got %v", err)
+	}
+
+	s, err := NewStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	item, err := NewStorageItem(s, "config", NewConfig, func(err error) {
+		t.Errorf("unexpected error: %v", err)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	item.NewClient().Change(func(c *Config) *Config {
+		c.ConcurrentDownloads = 7
+		return c
+	})
+	if v, err := ReadStorageItem(dir, "config", NewConfig); err != nil || v.ConcurrentDownloads != 7 {
+		t.Errorf("expected the saved config, got %+v (%v)", v, err)
+	}
+}
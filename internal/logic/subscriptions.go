@@ -0,0 +1,355 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xypwn/southpark-downloader-ui/pkg/data"
+	sp "github.com/xypwn/southpark-downloader-ui/pkg/southpark"
+)
+
+type Subscription struct {
+	Host     sp.Host
+	Language sp.Language
+	Season   int  // 0 for whichever season is the latest
+	Checked  bool // Episodes available on the first check aren't queued
+}
+
+// Reports whether both refer to the same episodes.
+func (s Subscription) Is(other Subscription) bool {
+	return s.Host == other.Host &&
+		s.Language == other.Language &&
+		s.Season == other.Season
+}
+
+func (s Subscription) String() string {
+	season := "latest season"
+	if s.Season != 0 {
+		season = fmt.Sprintf("season %v", s.Season)
+	}
+	return fmt.Sprintf("%v, %v (%v)", s.Language, season, s.Host)
+}
+
+// An episode that was queued because of a subscription.
+type AutoQueued struct {
+	Episode sp.EpisodeMetadata
+	Time    time.Time
+}
+
+const maxSubscriptionHistory = 100
+
+type Subscriptions struct {
+	Subscriptions []Subscription
+	Seen          map[string]bool // Episodes that were available before, by seenKey
+	History       []AutoQueued    // Newest first
+	LastCheck     time.Time
+	LastError     string // Empty if the last check succeeded
+}
+
+func NewSubscriptions() *Subscriptions {
+	return &Subscriptions{}
+}
+
+func seenLanguagePrefix(host sp.Host, language sp.Language) string {
+	return fmt.Sprintf("%v/%v/", host, language.Code())
+}
+
+func seenSeasonPrefix(host sp.Host, language sp.Language, season int) string {
+	return fmt.Sprintf("%vS%vE", seenLanguagePrefix(host, language), season)
+}
+
+func seenKey(host sp.Host, ep sp.EpisodeMetadata) string {
+	return seenSeasonPrefix(host, ep.Language, ep.SeasonNumber) + strconv.Itoa(ep.EpisodeNumber)
+}
+
+// Removes the Seen entries of seasons that aren't subscribed anymore.
+// latestSeasons holds the season numbers that subscriptions to the
+// latest season were last checked with, by seenLanguagePrefix.
+func (s *Subscriptions) pruneSeen(latestSeasons map[string]int) {
+	var keep []string
+	for _, sub := range s.Subscriptions {
+		langPrefix := seenLanguagePrefix(sub.Host, sub.Language)
+		if sub.Season != 0 {
+			keep = append(keep, seenSeasonPrefix(sub.Host, sub.Language, sub.Season))
+		} else if n, ok := latestSeasons[langPrefix]; ok {
+			keep = append(keep, seenSeasonPrefix(sub.Host, sub.Language, n))
+		} else {
+			// Not checked yet, so the season is unknown
+			keep = append(keep, langPrefix)
+		}
+	}
+	for k := range s.Seen {
+		kept := false
+		for _, prefix := range keep {
+			if strings.HasPrefix(k, prefix) {
+				kept = true
+				break
+			}
+		}
+		if !kept {
+			delete(s.Seen, k)
+		}
+	}
+}
+
+// Returns false if an equivalent subscription exists.
+func (s *Subscriptions) Add(sub Subscription) bool {
+	for _, v := range s.Subscriptions {
+		if v.Is(sub) {
+			return false
+		}
+	}
+	sub.Checked = false
+	s.Subscriptions = append(s.Subscriptions, sub)
+	return true
+}
+
+func (s *Subscriptions) Remove(sub Subscription) {
+	for i, v := range s.Subscriptions {
+		if v.Is(sub) {
+			s.Subscriptions = append(s.Subscriptions[:i], s.Subscriptions[i+1:]...)
+			return
+		}
+	}
+}
+
+// Held while checking, so checks started at the same time (e.g. by
+// WatchSubscriptions and a manual check) don't queue episodes twice.
+var checkSubscriptionsSem = make(chan struct{}, 1)
+
+// Returned by CheckSubscriptions if another process holds the lock.
+var ErrSubscriptionsLocked = errors.New("subscriptions are checked by another program using the same data directory")
+
+// Name of the StorageLock held by the process that checks subscriptions,
+// e.g. so the GUI and southpark-dl serve sharing a data directory don't
+// both queue new episodes. The other process doesn't see the changes to
+// Seen until it's restarted, so editing subscriptions in both at the
+// same time can still lose changes.
+const SubscriptionsLockName = "subscriptions"
+
+// Fetches the subscribed seasons and queues episodes that are new or
+// became available since the last check. The seasons of each host are
+// fetched again, so new seasons are found. Returns the queued episodes
+// and the last error. Subscriptions that fail don't prevent the others
+// from being checked. Waits for any check already in progress. If lock
+// isn't nil, it is acquired first and kept after the check.
+func CheckSubscriptions(
+	ctx context.Context,
+	lock *StorageLock,
+	subsClient *data.Client[*Subscriptions],
+	cacheClient *data.Client[*Cache],
+	cfgClient *data.Client[*Config],
+	dls *Downloads,
+	onError func(error), // For downloads
+) ([]AutoQueued, error) {
+	select {
+	case checkSubscriptionsSem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-checkSubscriptionsSem }()
+
+	if lock != nil && !lock.TryLock() {
+		return nil, ErrSubscriptionsLocked
+	}
+
+	var subs []Subscription
+	subsClient.Examine(func(s *Subscriptions) {
+		subs = append(subs, s.Subscriptions...)
+	})
+	var cfg Config
+	var extraHosts []sp.HostDefinition
+	var err error
+	cfgClient.Examine(func(c *Config) {
+		cfg = *c
+		extraHosts, err = c.CustomHostDefinitions()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	series := make(map[sp.Host]Series)
+	getSeries := func(host sp.Host) (Series, error) {
+		if s, ok := series[host]; ok {
+			return s, nil
+		}
+		s, err := GetSeries(ctx, string(host), extraHosts)
+		if len(s.Seasons) > 0 {
+			cacheClient.Change(func(c *Cache) *Cache {
+				c.SetSeries(s)
+				s = c.Series[host]
+				return c
+			})
+		} else {
+			// Use what is cached if the site is unreachable
+			var ok bool
+			cacheClient.Examine(func(c *Cache) {
+				s, ok = c.Series[host]
+			})
+			if !ok {
+				return Series{}, err
+			}
+		}
+		series[host] = s
+		return s, nil
+	}
+
+	var lastErr error
+	var candidates []sp.Episode
+	var checked []Subscription
+	var newSeen []string
+	latestSeasons := make(map[string]int)
+	seen := make(map[string]bool)
+	subsClient.Examine(func(s *Subscriptions) {
+		for k, v := range s.Seen {
+			seen[k] = v
+		}
+	})
+	for _, sub := range subs {
+		s, err := getSeries(sub.Host)
+		if err != nil {
+			lastErr = fmt.Errorf("%v: %w", sub, err)
+			continue
+		}
+		seasons, ok := s.Seasons[sub.Language]
+		if !ok {
+			lastErr = fmt.Errorf("%v: %w", sub, ErrLanguageUnavailable)
+			continue
+		}
+		var season Season
+		found := false
+		for _, v := range seasons {
+			if (sub.Season == 0 && (!found || v.SeasonNumber > season.SeasonNumber)) ||
+				(sub.Season != 0 && v.SeasonNumber == sub.Season) {
+				season = v
+				found = true
+			}
+		}
+		if !found {
+			lastErr = fmt.Errorf("%v: season not found", sub)
+			continue
+		}
+		if sub.Season == 0 {
+			latestSeasons[seenLanguagePrefix(sub.Host, sub.Language)] = season.SeasonNumber
+		}
+
+		eps, _, err := FetchSeason(ctx, cacheClient, season)
+		if err != nil {
+			lastErr = fmt.Errorf("%v: %w", sub, err)
+			continue
+		}
+
+		for _, ep := range eps {
+			if ep.Unavailable {
+				continue
+			}
+			k := seenKey(sub.Host, ep.EpisodeMetadata)
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+			newSeen = append(newSeen, k)
+			if sub.Checked {
+				candidates = append(candidates, ep)
+			}
+		}
+		checked = append(checked, sub)
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	added := dls.AddEpisodes(ctx, &cfg, candidates, onError)
+	now := time.Now()
+	queued := make([]AutoQueued, len(added))
+	for i, v := range added {
+		queued[i] = AutoQueued{
+			Episode: v.Params().Episode.EpisodeMetadata,
+			Time:    now,
+		}
+	}
+
+	subsClient.Change(func(s *Subscriptions) *Subscriptions {
+		if s.Seen == nil {
+			s.Seen = make(map[string]bool)
+		}
+		for _, k := range newSeen {
+			s.Seen[k] = true
+		}
+		s.pruneSeen(latestSeasons)
+		for i := range s.Subscriptions {
+			for _, v := range checked {
+				if s.Subscriptions[i].Is(v) {
+					s.Subscriptions[i].Checked = true
+				}
+			}
+		}
+		// Newest first
+		for _, v := range queued {
+			s.History = append([]AutoQueued{v}, s.History...)
+		}
+		if len(s.History) > maxSubscriptionHistory {
+			s.History = s.History[:maxSubscriptionHistory]
+		}
+		s.LastCheck = now
+		s.LastError = ""
+		if lastErr != nil {
+			s.LastError = lastErr.Error()
+		}
+		return s
+	})
+
+	return queued, lastErr
+}
+
+// Calls CheckSubscriptions whenever the interval set in the config has
+// passed since the last check, until ctx is done. onQueued is called
+// with the episodes that were queued, if any. If lock isn't nil,
+// subscriptions are only checked if it can be acquired, and it is
+// kept until ctx is done.
+func WatchSubscriptions(
+	ctx context.Context,
+	lock *StorageLock,
+	subsClient *data.Client[*Subscriptions],
+	cacheClient *data.Client[*Cache],
+	cfgClient *data.Client[*Config],
+	dls *Downloads,
+	onQueued func([]AutoQueued),
+	onError func(error), // For downloads
+) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	if lock != nil {
+		defer lock.Unlock()
+	}
+	for {
+		var interval time.Duration
+		cfgClient.Examine(func(c *Config) {
+			interval = c.SubscriptionInterval()
+		})
+		var due bool
+		subsClient.Examine(func(s *Subscriptions) {
+			due = len(s.Subscriptions) > 0 && time.Since(s.LastCheck) >= interval
+		})
+		if due {
+			// Errors are kept in Subscriptions.LastError
+			queued, _ := CheckSubscriptions(ctx, lock, subsClient, cacheClient, cfgClient, dls, onError)
+			if len(queued) > 0 && onQueued != nil {
+				onQueued(queued)
+			}
+		} else if lock != nil && lock.Held() {
+			// Keep it from going stale
+			lock.TryLock()
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/xypwn/southpark-downloader-ui/pkg/data"
+	sp "github.com/xypwn/southpark-downloader-ui/pkg/southpark"
+)
+
+func TestSubscriptionsPruneSeen(t *testing.T) {
+	s := &Subscriptions{
+		Subscriptions: []Subscription{
+			{Host: "sp.example", Language: sp.LanguageEnglish, Season: 2},
+			{Host: "sp.example", Language: sp.LanguageGerman}, // Latest season
+			{Host: "other.example", Language: sp.LanguageEnglish},
+		},
+		Seen: make(map[string]bool),
+	}
+	for _, host := range []sp.Host{"sp.example", "other.example", "gone.example"} {
+		for _, lang := range []sp.Language{sp.LanguageEnglish, sp.LanguageGerman} {
+			for season := 1; season <= 3; season++ {
+				for _, episode := range []int{1, 12} {
+					s.Seen[seenKey(host, sp.EpisodeMetadata{
+						Language:      lang,
+						SeasonNumber:  season,
+						EpisodeNumber: episode,
+					})] = true
+				}
+			}
+		}
+	}
+
+	// other.example wasn't checked, so its latest season is unknown
+	s.pruneSeen(map[string]int{seenLanguagePrefix("sp.example", sp.LanguageGerman): 3})
+
+	var got []string
+	for k := range s.Seen {
+		got = append(got, k)
+	}
+	sort.Strings(got)
+	want := []string{
+		"other.example/EN/S1E1", "other.example/EN/S1E12",
+		"other.example/EN/S2E1", "other.example/EN/S2E12",
+		"other.example/EN/S3E1", "other.example/EN/S3E12",
+		"sp.example/DE/S3E1", "sp.example/DE/S3E12",
+		"sp.example/EN/S2E1", "sp.example/EN/S2E12",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestCheckSubscriptions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	var seasonEps []sp.Episode // Of season 2
+	getEpisodes = func(ctx context.Context, season sp.Season) ([]sp.Episode, string, error) {
+		if season.SeasonNumber != 2 {
+			return nil, "", errors.New("unexpected season")
+		}
+		return seasonEps, "mgid2", nil
+	}
+	t.Cleanup(func() { getEpisodes = sp.GetEpisodes })
+
+	// sp.example is unknown, so the cached series is used
+	cacheClient := data.NewBinding[*Cache]().NewClient()
+	cacheClient.Change(func(*Cache) *Cache {
+		c := NewCache()
+		c.SetSeries(testSeries("sp.example", 1, 2))
+		return c
+	})
+	// Downloads never start, since the config allows none at a time
+	cfgClient := data.NewBinding[*Config]().NewClient()
+	cfgClient.Change(func(*Config) *Config {
+		cfg := NewConfig()
+		cfg.DownloadPath = t.TempDir()
+		cfg.ConcurrentDownloads = 0
+		return cfg
+	})
+	onError := func(err error) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	dls := NewDownloads(cfgClient, onError)
+	subsClient := data.NewBinding[*Subscriptions]().NewClient()
+	subsClient.Change(func(*Subscriptions) *Subscriptions {
+		s := NewSubscriptions()
+		s.Add(Subscription{Host: "sp.example", Language: sp.LanguageEnglish})
+		return s
+	})
+
+	check := func(episodeNumbers ...int) []string {
+		t.Helper()
+		seasonEps = testEpisodes(2, episodeNumbers...)
+		queued, err := CheckSubscriptions(ctx, nil, subsClient, cacheClient, cfgClient, dls, onError)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var res []string
+		for _, v := range queued {
+			res = append(res, fmt.Sprintf("S%vE%v", v.Episode.SeasonNumber, v.Episode.EpisodeNumber))
+		}
+		return res
+	}
+
+	// The first check only marks the available episodes as seen
+	if got := check(1, 2); len(got) != 0 {
+		t.Errorf("expected nothing to be queued on the first check, got %v", got)
+	}
+	subsClient.Examine(func(s *Subscriptions) {
+		if !s.Subscriptions[0].Checked {
+			t.Errorf("expected the subscription to be checked")
+		}
+		if len(s.Seen) != 2 || !s.Seen["sp.example/EN/S2E1"] || !s.Seen["sp.example/EN/S2E2"] {
+			t.Errorf("expected episodes 1 and 2 to be seen, got %v", s.Seen)
+		}
+		if s.LastCheck.IsZero() || s.LastError != "" {
+			t.Errorf("expected a successful check, got %v (%q)", s.LastCheck, s.LastError)
+		}
+	})
+
+	// Episode 4 isn't out yet
+	seasonEps = testEpisodes(2, 1, 2, 3, 4)
+	seasonEps[3].Unavailable = true
+	queued, err := CheckSubscriptions(ctx, nil, subsClient, cacheClient, cfgClient, dls, onError)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queued) != 1 || queued[0].Episode.EpisodeNumber != 3 {
+		t.Errorf("expected episode 3 to be queued, got %v", queued)
+	}
+	if got := check(1, 2, 3); len(got) != 0 {
+		t.Errorf("expected seen episodes not to be queued again, got %v", got)
+	}
+
+	// Only the newest entries of the history are kept
+	subsClient.Change(func(s *Subscriptions) *Subscriptions {
+		for len(s.History) < maxSubscriptionHistory {
+			s.History = append(s.History, AutoQueued{Time: time.Now()})
+		}
+		return s
+	})
+	if got := check(1, 2, 3, 4, 5); fmt.Sprint(got) != "[S2E4 S2E5]" {
+		t.Errorf("expected episodes 4 and 5 to be queued, got %v", got)
+	}
+	subsClient.Examine(func(s *Subscriptions) {
+		if len(s.History) != maxSubscriptionHistory {
+			t.Errorf("expected %v history entries, got %v", maxSubscriptionHistory, len(s.History))
+		}
+		if s.History[0].Episode.EpisodeNumber != 5 || s.History[1].Episode.EpisodeNumber != 4 {
+			t.Errorf("expected the queued episodes to be first in the history, got %v", s.History[:2])
+		}
+	})
+
+	// Another process checks subscriptions
+	stor, err := NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := stor.NewLock(SubscriptionsLockName)
+	if !other.TryLock() {
+		t.Fatal("expected the lock to be acquired")
+	}
+	defer other.Unlock()
+	if _, err := CheckSubscriptions(ctx, stor.NewLock(SubscriptionsLockName), subsClient, cacheClient, cfgClient, dls, onError); !errors.Is(err, ErrSubscriptionsLocked) {
+		t.Errorf("expected ErrSubscriptionsLocked, got %v", err)
+	}
+}
@@ -0,0 +1,244 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/xypwn/southpark-downloader-ui/internal/logic"
+	"github.com/xypwn/southpark-downloader-ui/pkg/data"
+)
+
+// GET /api/events streams server-sent events, each with a JSON object
+// as data:
+//
+//	downloads  The download list (see GET /api/downloads), sent on connect
+//	           and whenever downloads are queued, moved or removed
+//	progress   A download's progress changed, see progressJSON
+//	status     A download's status changed, see statusJSON
+//	done       A download finished, see doneJSON
+//	error      A download or subscription check failed, see errorJSON
+//
+// Progress events are sent once per percent. Clients that fall too far
+// behind are disconnected; EventSource reconnects on its own and gets a
+// new download list.
+
+const eventBufferSize = 256
+
+var keepAliveInterval = 30 * time.Second
+
+type event struct {
+	Name string
+	Data []byte
+}
+
+type progressJSON struct {
+	ID       uint64               `json:"id"`
+	Status   logic.DownloadStatus `json:"status"`
+	Progress float64              `json:"progress"`
+	Text     string               `json:"text"`
+}
+
+type statusJSON struct {
+	progressJSON
+	Previous logic.DownloadStatus `json:"previous"`
+}
+
+type doneJSON struct {
+	ID      uint64       `json:"id"`
+	Episode *episodeJSON `json:"episode,omitempty"`
+	Path    string       `json:"path"`
+}
+
+type errorJSON struct {
+	ID      uint64 `json:"id,omitempty"` // Set if a download failed
+	Error   string `json:"error"`
+	Message string `json:"message,omitempty"` // Explanation for users, if known
+}
+
+// Passes events to all subscribers without blocking.
+type eventHub struct {
+	mtx  sync.Mutex
+	subs map[chan event]struct{}
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{
+		subs: make(map[chan event]struct{}),
+	}
+}
+
+func (h *eventHub) subscribe() chan event {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	ch := make(chan event, eventBufferSize)
+	h.subs[ch] = struct{}{}
+	return ch
+}
+
+// Closes ch unless it was dropped already.
+func (h *eventHub) unsubscribe(ch chan event) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	if _, ok := h.subs[ch]; ok {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+func (h *eventHub) publish(name string, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic("server: eventHub.publish: " + err.Error())
+	}
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- event{name, data}:
+		default:
+			// Too slow
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Publishes an error event and passes err on to the onError function
+// given to New. Use it for downloads not queued through the server.
+func (s *Server) ReportError(err error) {
+	v := errorJSON{Error: err.Error()}
+	var dlErr *logic.DownloadError
+	if errors.As(err, &dlErr) {
+		v.ID = dlErr.ID
+	}
+	if _, msg, ok := logic.ShortUserMessage(err); ok {
+		v.Message = msg
+	}
+	s.events.publish("error", v)
+	s.onError(err)
+}
+
+// Publishes changes of the download list and the progress of each
+// download. Progress listeners must not access the download list,
+// since the list's listeners access the progress of its downloads.
+func (s *Server) watchDownloads() {
+	var mtx sync.Mutex
+	watched := make(map[*logic.Download]*data.Client[logic.DownloadProgress])
+
+	watch := func(dl *logic.Download) {
+		progress := dl.Progress()
+		lastStatus, lastStep := progress.Status, int(progress.Value*100)
+		cl := dl.ProgressBinding().NewClient()
+		cl.AddListener(func(p logic.DownloadProgress) {
+			v := progressJSON{
+				ID:       dl.ID(),
+				Status:   p.Status,
+				Progress: p.Value,
+				Text:     p.String(),
+			}
+			step := int(p.Value * 100)
+			if p.Status != lastStatus {
+				s.events.publish("status", statusJSON{v, lastStatus})
+			} else if step == lastStep {
+				return
+			}
+			lastStatus, lastStep = p.Status, step
+			s.events.publish("progress", v)
+			if p.Status == logic.DownloadStatusDone {
+				params := dl.Params()
+				done := doneJSON{
+					ID:   dl.ID(),
+					Path: params.PlayablePath(),
+				}
+				if params.MasterURL == "" {
+					ep := newEpisodeJSON(params.Episode.EpisodeMetadata)
+					done.Episode = &ep
+				}
+				s.events.publish("done", done)
+			}
+		})
+		watched[dl] = cl
+	}
+
+	update := func(arr []*logic.Download) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		present := make(map[*logic.Download]bool, len(arr))
+		for _, v := range arr {
+			present[v] = true
+			if _, ok := watched[v]; !ok {
+				watch(v)
+			}
+		}
+		for dl, cl := range watched {
+			if !present[dl] {
+				dl.ProgressBinding().RemoveClient(cl)
+				delete(watched, dl)
+			}
+		}
+	}
+
+	s.dlsClient.AddListener(func(arr []*logic.Download) {
+		update(arr)
+		s.events.publish("downloads", newDownloadsJSON(arr))
+	})
+	s.dlsClient.Examine(update)
+}
+
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming not supported"))
+		return
+	}
+
+	// Subscribe before sending the list, so no change is missed
+	ch := s.events.subscribe()
+	defer s.events.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	write := func(e event) error {
+		_, err := fmt.Fprintf(w, "event: %v\ndata: %s\n\n", e.Name, e.Data)
+		flusher.Flush()
+		return err
+	}
+
+	list, _ := json.Marshal(s.downloads())
+	if write(event{"downloads", list}) != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			if write(e) != nil {
+				return
+			}
+		case <-keepAlive.C:
+			// Comment line, ignored by clients
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xypwn/southpark-downloader-ui/internal/logic"
+	sp "github.com/xypwn/southpark-downloader-ui/pkg/southpark"
+)
+
+type eventStream struct {
+	r *bufio.Reader
+}
+
+func (s *testServer) events(t *testing.T) *eventStream {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(s.ctx, 5*time.Second)
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL+"/api/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := s.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %v", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("expected an event stream, got %q", ct)
+	}
+	return &eventStream{bufio.NewReader(resp.Body)}
+}
+
+// Returns the lines of the next frame, without the blank line
+// ending it.
+func (es *eventStream) frame(t *testing.T) []string {
+	t.Helper()
+	var res []string
+	for {
+		line, err := es.r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read event: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return res
+		}
+		res = append(res, line)
+	}
+}
+
+// Returns the next event, skipping comments.
+func (es *eventStream) next(t *testing.T) (name string, data map[string]any) {
+	t.Helper()
+	for {
+		lines := es.frame(t)
+		if strings.HasPrefix(lines[0], ":") {
+			continue
+		}
+		if len(lines) != 2 || !strings.HasPrefix(lines[0], "event: ") || !strings.HasPrefix(lines[1], "data: ") {
+			t.Fatalf("malformed event: %q", lines)
+		}
+		name = strings.TrimPrefix(lines[0], "event: ")
+		var v any
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[1], "data: ")), &v); err != nil {
+			t.Fatalf("%v: parse data: %v", name, err)
+		}
+		if arr, ok := v.([]any); ok {
+			// Download lists are summarized by their length
+			return name, map[string]any{"len": float64(len(arr))}
+		}
+		return name, v.(map[string]any)
+	}
+}
+
+func TestServerEvents(t *testing.T) {
+	s := newTestServer(t)
+	es := s.events(t)
+
+	expect := func(name string, fields map[string]any) {
+		t.Helper()
+		gotName, got := es.next(t)
+		if gotName != name {
+			t.Fatalf("expected %v event, got %v %v", name, gotName, got)
+		}
+		for k, v := range fields {
+			if fmt.Sprint(got[k]) != fmt.Sprint(v) {
+				t.Errorf("%v event: expected %v %v, got %v", name, k, v, got[k])
+			}
+		}
+	}
+
+	expect("downloads", map[string]any{"len": 0})
+
+	var cfg logic.Config
+	s.cfgClient.Examine(func(c *logic.Config) {
+		cfg = *c
+	})
+	ep := sp.Episode{EpisodeMetadata: sp.EpisodeMetadata{
+		SeasonNumber:  1,
+		EpisodeNumber: 1,
+		Language:      sp.LanguageEnglish,
+	}}
+	dl := s.dls.Add(s.ctx, logic.NewEpisodeDownloadParams(&cfg, ep), func(error) {})
+	expect("downloads", map[string]any{"len": 1})
+
+	cl := dl.ProgressBinding().NewClient()
+	defer dl.ProgressBinding().RemoveClient(cl)
+	setProgress := func(status logic.DownloadStatus, value float64) {
+		cl.Change(func(logic.DownloadProgress) logic.DownloadProgress {
+			return logic.DownloadProgress{Status: status, Value: value}
+		})
+	}
+
+	setProgress(logic.DownloadStatusDownloadingVideo, 0.5)
+	expect("status", map[string]any{"id": dl.ID(), "status": "downloading_video", "previous": "waiting"})
+	expect("progress", map[string]any{"id": dl.ID(), "progress": 0.5})
+	// Changes below one percent aren't sent
+	setProgress(logic.DownloadStatusDownloadingVideo, 0.504)
+	setProgress(logic.DownloadStatusDownloadingVideo, 0.6)
+	expect("progress", map[string]any{"id": dl.ID(), "progress": 0.6})
+
+	setProgress(logic.DownloadStatusDone, -1)
+	expect("status", map[string]any{"id": dl.ID(), "status": "done", "previous": "downloading_video"})
+	expect("progress", map[string]any{"id": dl.ID(), "status": "done"})
+	expect("done", map[string]any{"id": dl.ID(), "path": dl.Params().PlayablePath()})
+
+	var reported []error
+	s.onError = func(err error) {
+		reported = append(reported, err)
+	}
+	s.api.ReportError(&logic.DownloadError{ID: dl.ID(), Err: sp.ErrRateLimited})
+	expect("error", map[string]any{"id": dl.ID(), "error": sp.ErrRateLimited.Error()})
+	s.api.ReportError(errors.New("check subscriptions"))
+	expect("error", map[string]any{"id": nil, "error": "check subscriptions"})
+	if len(reported) != 2 {
+		t.Errorf("expected errors to be passed on, got %v", reported)
+	}
+}
+
+func TestServerEventsKeepAlive(t *testing.T) {
+	oldKeepAliveInterval := keepAliveInterval
+	keepAliveInterval = 10 * time.Millisecond
+	t.Cleanup(func() { keepAliveInterval = oldKeepAliveInterval })
+
+	s := newTestServer(t)
+	es := s.events(t)
+	if lines := es.frame(t); lines[0] != "event: downloads" {
+		t.Fatalf("expected the download list first, got %q", lines)
+	}
+	if lines := es.frame(t); fmt.Sprint(lines) != "[: keep-alive]" {
+		t.Errorf("expected a keep-alive comment, got %q", lines)
+	}
+}
+
+func TestEventHubDropsSlowSubscribers(t *testing.T) {
+	h := newEventHub()
+	slow := h.subscribe()
+	fast := h.subscribe()
+	defer h.unsubscribe(fast)
+
+	for i := 0; i <= eventBufferSize; i++ {
+		h.publish("progress", i)
+		<-fast
+	}
+
+	// The buffered events are still delivered, then the channel is closed
+	n := 0
+	for range slow {
+		n++
+	}
+	if n != eventBufferSize {
+		t.Errorf("expected %v buffered events, got %v", eventBufferSize, n)
+	}
+	h.mtx.Lock()
+	_, fastOK := h.subs[fast]
+	nSubs := len(h.subs)
+	h.mtx.Unlock()
+	if !fastOK || nSubs != 1 {
+		t.Errorf("expected only the slow subscriber to be dropped")
+	}
+	// Unsubscribing a dropped subscriber doesn't close it again
+	h.unsubscribe(slow)
+}
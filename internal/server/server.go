@@ -0,0 +1,608 @@
+// Package server provides a JSON API over HTTP for listing episodes and
+// controlling downloads, meant to be served on localhost.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/xypwn/southpark-downloader-ui/internal/logic"
+	"github.com/xypwn/southpark-downloader-ui/pkg/data"
+	sp "github.com/xypwn/southpark-downloader-ui/pkg/southpark"
+)
+
+// Handles the following endpoints:
+//
+//	GET    /api/series               Seasons of every language (?refresh=1 to fetch again)
+//	GET    /api/episodes             Episodes (?select=S05&lang=EN, see sp.Selection)
+//	GET    /api/downloads            Downloads in order of priority
+//	POST   /api/downloads            Queue episodes, see enqueueRequest
+//	GET    /api/downloads/{id}       A single download
+//	DELETE /api/downloads/{id}       Cancel a download, or remove it if it's finished
+//	POST   /api/downloads/{id}/move  Change the priority, see moveRequest
+//	GET    /api/config               The config
+//	PUT    /api/config               Change some or all fields of the config
+//	GET    /api/events               Server-sent events, see events.go
+//
+// Errors are returned as {"error": "..."} with a matching status code.
+type Server struct {
+	ctx         context.Context // Downloads live as long as ctx
+	dls         *logic.Downloads
+	cfgClient   *data.Client[*logic.Config]
+	cacheClient *data.Client[*logic.Cache]
+	onError     func(error) // For errors of downloads
+	mux         *http.ServeMux
+	dlsClient   *data.ListClient[*logic.Download]
+	events      *eventHub
+}
+
+func New(
+	ctx context.Context,
+	dls *logic.Downloads,
+	cfgClient *data.Client[*logic.Config],
+	cacheClient *data.Client[*logic.Cache],
+	onError func(error),
+) *Server {
+	s := &Server{
+		ctx:         ctx,
+		dls:         dls,
+		cfgClient:   cfgClient,
+		cacheClient: cacheClient,
+		onError:     onError,
+		mux:         http.NewServeMux(),
+		dlsClient:   dls.NewClient(),
+		events:      newEventHub(),
+	}
+	s.watchDownloads()
+	s.mux.HandleFunc("/api/series", s.handleSeries)
+	s.mux.HandleFunc("/api/episodes", s.handleEpisodes)
+	s.mux.HandleFunc("/api/downloads", s.handleDownloads)
+	s.mux.HandleFunc("/api/downloads/", s.handleDownload)
+	s.mux.HandleFunc("/api/config", s.handleConfig)
+	s.mux.HandleFunc("/api/events", s.handleEvents)
+	s.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+	})
+	return s
+}
+
+// Reports whether host names this machine.
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Reports whether origin is a page served from this machine.
+func isLocalOrigin(origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return isLoopbackHost(u.Hostname())
+}
+
+// Reports whether r arrived on a loopback interface.
+func isLoopbackRequest(r *http.Request) bool {
+	addr, ok := r.Context().Value(http.LocalAddrContextKey).(*net.TCPAddr)
+	return ok && addr.IP.IsLoopback()
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Websites opened in a browser must not control downloads
+	if origin := r.Header.Get("Origin"); origin != "" && !isLocalOrigin(origin) {
+		writeError(w, http.StatusForbidden, errors.New("cross-origin requests are not allowed"))
+		return
+	}
+	// A website whose domain resolves to 127.0.0.1 (DNS rebinding)
+	// counts as same-origin, so it only shows in the Host header
+	if isLoopbackRequest(r) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if !isLoopbackHost(strings.Trim(host, "[]")) {
+			writeError(w, http.StatusForbidden, fmt.Errorf("host %v is not allowed", r.Host))
+			return
+		}
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
+
+// Returns the status code for an error of the logic or sp packages.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return http.StatusServiceUnavailable
+	case logic.IsNetworkError(err), errors.Is(err, sp.ErrRateLimited):
+		return http.StatusBadGateway
+	case errors.Is(err, sp.ErrRegionUnsupported),
+		errors.Is(err, logic.ErrLanguageUnavailable),
+		errors.As(err, new(*sp.SelectionError)):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, v := range methods {
+		if r.Method == v {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %v not allowed", r.Method))
+	return false
+}
+
+func decodeBody(r *http.Request, v any) error {
+	dec := json.NewDecoder(http.MaxBytesReader(nil, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("invalid request body: %w", err)
+	}
+	return nil
+}
+
+func (s *Server) config() *logic.Config {
+	var res *logic.Config
+	s.cfgClient.Examine(func(c *logic.Config) {
+		res = c
+	})
+	return res
+}
+
+// Returns the cached series of the configured host, fetching it
+// if it isn't cached, is stale or refresh is set. If fetching fails,
+// the cached series is returned if there is one.
+func (s *Server) series(ctx context.Context, refresh bool) (logic.Series, error) {
+	cfg := s.config()
+	extraHosts, err := cfg.CustomHostDefinitions()
+	if err != nil {
+		return logic.Series{}, err
+	}
+
+	var series logic.Series
+	var cached bool
+	s.cacheClient.Examine(func(c *logic.Cache) {
+		series, cached = c.CachedSeries(cfg.Host)
+	})
+	if cached && !refresh && !logic.Stale(series.FetchedAt, cfg.CacheTTL()) {
+		return series, nil
+	}
+
+	newSeries, err := logic.GetSeries(ctx, cfg.Host, extraHosts)
+	if len(newSeries.Seasons) == 0 {
+		if cached {
+			return series, nil
+		}
+		return logic.Series{}, err
+	}
+	s.cacheClient.Change(func(c *logic.Cache) *logic.Cache {
+		c.SetSeries(newSeries)
+		series = c.Series[newSeries.Region.Host]
+		return c
+	})
+	return series, nil
+}
+
+// Returns the language given by the "lang" query parameter, or the
+// first language of series if there is none.
+func queryLanguage(r *http.Request, series logic.Series) (sp.Language, error) {
+	if s := r.URL.Query().Get("lang"); s != "" {
+		lang, ok := sp.LanguageFromString(s)
+		if !ok {
+			return 0, fmt.Errorf("unknown language: %v", s)
+		}
+		return lang, nil
+	}
+	return defaultLanguage(series)
+}
+
+// Returns the first language of series.
+func defaultLanguage(series logic.Series) (sp.Language, error) {
+	for _, v := range series.Region.AvailableLanguages() {
+		if _, ok := series.Seasons[v]; ok {
+			return v, nil
+		}
+	}
+	return 0, errors.New("no languages available")
+}
+
+type seasonJSON struct {
+	Number         int    `json:"number"`
+	Title          string `json:"title"`
+	URL            string `json:"url"`
+	EpisodesCached bool   `json:"episodesCached"`
+}
+
+type languageJSON struct {
+	Code    string       `json:"code"`
+	Name    string       `json:"name"`
+	Seasons []seasonJSON `json:"seasons"`
+}
+
+type seriesJSON struct {
+	Host      string         `json:"host"`
+	Languages []languageJSON `json:"languages"`
+}
+
+func (s *Server) handleSeries(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	series, err := s.series(r.Context(), r.URL.Query().Get("refresh") == "1")
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	res := seriesJSON{
+		Host:      string(series.Region.Host),
+		Languages: []languageJSON{},
+	}
+	for _, lang := range series.Region.AvailableLanguages() {
+		seasons, ok := series.Seasons[lang]
+		if !ok {
+			continue
+		}
+		l := languageJSON{
+			Code:    lang.Code(),
+			Name:    lang.String(),
+			Seasons: []seasonJSON{},
+		}
+		for _, v := range seasons {
+			l.Seasons = append(l.Seasons, seasonJSON{
+				Number:         v.SeasonNumber,
+				Title:          v.Title,
+				URL:            v.URL,
+				EpisodesCached: v.Episodes != nil,
+			})
+		}
+		res.Languages = append(res.Languages, l)
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+type episodeJSON struct {
+	Season       int    `json:"season"`
+	Episode      int    `json:"episode"`
+	Language     string `json:"language"`
+	Title        string `json:"title"`
+	Description  string `json:"description"`
+	URL          string `json:"url"`
+	ThumbnailURL string `json:"thumbnailUrl"`
+	Unavailable  bool   `json:"unavailable"`
+}
+
+func newEpisodeJSON(ep sp.EpisodeMetadata) episodeJSON {
+	return episodeJSON{
+		Season:       ep.SeasonNumber,
+		Episode:      ep.EpisodeNumber,
+		Language:     ep.Language.Code(),
+		Title:        ep.Title,
+		Description:  ep.Description,
+		URL:          ep.URL,
+		ThumbnailURL: ep.RawThumbnailURL,
+		Unavailable:  ep.Unavailable,
+	}
+}
+
+func (s *Server) handleEpisodes(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	expr := r.URL.Query().Get("select")
+	if expr == "" {
+		expr = "all"
+	}
+	sel, err := sp.ParseSelection(expr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	series, err := s.series(r.Context(), false)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	lang, err := queryLanguage(r, series)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	eps, err := logic.ResolveSelection(r.Context(), s.cacheClient, series, sel, lang, s.config().CacheTTL())
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	res := make([]episodeJSON, len(eps))
+	for i, v := range eps {
+		res[i] = newEpisodeJSON(v.EpisodeMetadata)
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+type downloadJSON struct {
+	ID       uint64               `json:"id"`
+	Position int                  `json:"position"`          // Lower positions start first
+	Episode  *episodeJSON         `json:"episode,omitempty"` // Not set for HLS URL downloads
+	Status   logic.DownloadStatus `json:"status"`
+	Progress float64              `json:"progress"` // From 0 to 1, -1 if unknown
+	Text     string               `json:"text"`     // Human-readable progress
+	Path     string               `json:"path"`     // Where the downloaded video is saved
+}
+
+func newDownloadJSON(dl *logic.Download, pos int) downloadJSON {
+	params := dl.Params()
+	progress := dl.Progress()
+	res := downloadJSON{
+		ID:       dl.ID(),
+		Position: pos,
+		Status:   progress.Status,
+		Progress: progress.Value,
+		Text:     progress.String(),
+		Path:     params.PlayablePath(),
+	}
+	if params.MasterURL == "" {
+		ep := newEpisodeJSON(params.Episode.EpisodeMetadata)
+		res.Episode = &ep
+	}
+	return res
+}
+
+func newDownloadsJSON(arr []*logic.Download) []downloadJSON {
+	res := make([]downloadJSON, len(arr))
+	for i, v := range arr {
+		res[i] = newDownloadJSON(v, i)
+	}
+	return res
+}
+
+func (s *Server) downloads() []downloadJSON {
+	var res []downloadJSON
+	s.dlsClient.Examine(func(arr []*logic.Download) {
+		res = newDownloadsJSON(arr)
+	})
+	return res
+}
+
+func (s *Server) downloadJSON(dl *logic.Download) (downloadJSON, bool) {
+	for _, v := range s.downloads() {
+		if v.ID == dl.ID() {
+			return v, true
+		}
+	}
+	return downloadJSON{}, false
+}
+
+// Body of POST /api/downloads.
+type enqueueRequest struct {
+	// Episode URLs and selection expressions, see logic.ParseImport
+	Episodes []string `json:"episodes"`
+	// Language of selection terms without one, e.g. "EN". The first
+	// available language if empty.
+	Language string `json:"language"`
+}
+
+type failedJSON struct {
+	Entry string `json:"entry"`
+	Error string `json:"error"`
+}
+
+type enqueueResponse struct {
+	Queued  []downloadJSON `json:"queued"`
+	Skipped []episodeJSON  `json:"skipped"` // Unavailable, already queued or downloaded
+	Failed  []failedJSON   `json:"failed"`
+}
+
+func (s *Server) handleDownloads(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+	if r.Method == http.MethodGet {
+		writeJSON(w, http.StatusOK, s.downloads())
+		return
+	}
+
+	var req enqueueRequest
+	if err := decodeBody(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(req.Episodes) == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("no episodes given"))
+		return
+	}
+
+	cfg := s.config()
+	opts := logic.ImportOptions{
+		Host: cfg.Host,
+		TTL:  cfg.CacheTTL(),
+	}
+	var err error
+	opts.ExtraHosts, err = cfg.CustomHostDefinitions()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// Entries hold one line each
+	for i, v := range req.Episodes {
+		req.Episodes[i] = strings.ReplaceAll(v, "\n", " ")
+	}
+	entries := logic.ParseImport(strings.Join(req.Episodes, "\n"))
+	for _, e := range entries {
+		if e.URL == "" && e.Err == nil {
+			// Selections need the series and a language
+			series, err := s.series(r.Context(), false)
+			if err != nil {
+				writeError(w, errorStatus(err), err)
+				return
+			}
+			if req.Language != "" {
+				var ok bool
+				opts.Language, ok = sp.LanguageFromString(req.Language)
+				if !ok {
+					writeError(w, http.StatusBadRequest, fmt.Errorf("unknown language: %v", req.Language))
+					return
+				}
+			} else {
+				opts.Language, err = defaultLanguage(series)
+				if err != nil {
+					writeError(w, http.StatusBadRequest, err)
+					return
+				}
+			}
+			opts.Host = string(series.Region.Host)
+			break
+		}
+	}
+
+	eps, failed := logic.ResolveImport(r.Context(), s.cacheClient, entries, opts)
+	added := s.dls.AddEpisodes(s.ctx, cfg, eps, s.ReportError)
+
+	res := enqueueResponse{
+		Queued:  []downloadJSON{},
+		Skipped: []episodeJSON{},
+		Failed:  []failedJSON{},
+	}
+	for _, v := range added {
+		if dl, ok := s.downloadJSON(v); ok {
+			res.Queued = append(res.Queued, dl)
+		}
+	}
+eps:
+	for _, ep := range eps {
+		for _, v := range added {
+			if v.Params().Episode.Is(ep.EpisodeMetadata) {
+				continue eps
+			}
+		}
+		res.Skipped = append(res.Skipped, newEpisodeJSON(ep.EpisodeMetadata))
+	}
+	for _, v := range failed {
+		res.Failed = append(res.Failed, failedJSON{
+			Entry: v.Text,
+			Error: v.Err.Error(),
+		})
+	}
+
+	status := http.StatusOK
+	if len(entries) > 0 && len(failed) == len(entries) {
+		status = http.StatusUnprocessableEntity
+	}
+	writeJSON(w, status, res)
+}
+
+// Body of POST /api/downloads/{id}/move.
+type moveRequest struct {
+	Position int `json:"position"` // 0 to start it next
+}
+
+func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/downloads/"), "/")
+	id, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil || len(parts) > 2 || (len(parts) == 2 && parts[1] != "move") {
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+	dl := s.dls.Find(id)
+	if dl == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("download %v not found", id))
+		return
+	}
+
+	if len(parts) == 2 {
+		if !allowMethods(w, r, http.MethodPost) {
+			return
+		}
+		var req moveRequest
+		if err := decodeBody(r, &req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if !s.dls.Move(dl, req.Position) {
+			writeError(w, http.StatusNotFound, fmt.Errorf("download %v not found", id))
+			return
+		}
+		res, _ := s.downloadJSON(dl)
+		writeJSON(w, http.StatusOK, res)
+		return
+	}
+
+	if !allowMethods(w, r, http.MethodGet, http.MethodDelete) {
+		return
+	}
+	if r.Method == http.MethodGet {
+		res, ok := s.downloadJSON(dl)
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("download %v not found", id))
+			return
+		}
+		writeJSON(w, http.StatusOK, res)
+		return
+	}
+	// Cancelled downloads remove themselves from the list
+	if !s.dls.Remove(dl) {
+		dl.Cancel()
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPut) {
+		return
+	}
+	if r.Method == http.MethodGet {
+		writeJSON(w, http.StatusOK, s.config())
+		return
+	}
+
+	// Fields missing from the body keep their values. Decoding onto a
+	// deep copy, since it would write into the current config's slices.
+	var cfg logic.Config
+	if data, err := json.Marshal(s.config()); err != nil || json.Unmarshal(data, &cfg) != nil {
+		writeError(w, http.StatusInternalServerError, errors.New("copy config"))
+		return
+	}
+	if err := decodeBody(r, &cfg); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if cfg.ConcurrentDownloads < 1 {
+		writeError(w, http.StatusBadRequest, errors.New("ConcurrentDownloads must be at least 1"))
+		return
+	}
+	if _, err := cfg.CustomHostDefinitions(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	s.cfgClient.Change(func(*logic.Config) *logic.Config {
+		return &cfg
+	})
+	writeJSON(w, http.StatusOK, &cfg)
+}
@@ -0,0 +1,294 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xypwn/southpark-downloader-ui/internal/logic"
+	"github.com/xypwn/southpark-downloader-ui/pkg/data"
+	sp "github.com/xypwn/southpark-downloader-ui/pkg/southpark"
+)
+
+type testServer struct {
+	*httptest.Server
+	ctx       context.Context
+	api       *Server
+	dls       *logic.Downloads
+	cfgClient *data.Client[*logic.Config]
+	onError   func(error) // Fails the test unless replaced
+}
+
+// Downloads never start, since the config allows none at a time.
+func newTestServer(t *testing.T) *testServer {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	cfgClient := data.NewBinding[*logic.Config]().NewClient()
+	cfgClient.Change(func(*logic.Config) *logic.Config {
+		cfg := logic.NewConfig()
+		cfg.DownloadPath = t.TempDir()
+		cfg.ConcurrentDownloads = 0
+		cfg.CustomHosts = []logic.HostConfig{{Host: "sp.example", Languages: []string{"EN"}}}
+		return cfg
+	})
+	cacheClient := data.NewBinding[*logic.Cache]().NewClient()
+	cacheClient.Change(func(*logic.Cache) *logic.Cache {
+		return logic.NewCache()
+	})
+	res := &testServer{
+		ctx:       ctx,
+		cfgClient: cfgClient,
+		onError: func(err error) {
+			t.Errorf("unexpected error: %v", err)
+		},
+	}
+	onError := func(err error) {
+		res.onError(err)
+	}
+
+	res.dls = logic.NewDownloads(cfgClient, onError)
+	res.api = New(ctx, res.dls, cfgClient, cacheClient, onError)
+	res.Server = httptest.NewServer(res.api)
+	t.Cleanup(res.Server.Close)
+	return res
+}
+
+func (s *testServer) do(t *testing.T, method, path, body string, header map[string]string) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, s.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		if k == "Host" {
+			req.Host = v
+		} else {
+			req.Header.Set(k, v)
+		}
+	}
+	resp, err := s.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(data)
+}
+
+func (s *testServer) addDownload(t *testing.T, episode int, status logic.DownloadStatus) *logic.Download {
+	var cfg *logic.Config
+	s.cfgClient.Examine(func(c *logic.Config) {
+		cfg = c
+	})
+	ep := sp.Episode{EpisodeMetadata: sp.EpisodeMetadata{
+		SeasonNumber:  1,
+		EpisodeNumber: episode,
+		Language:      sp.LanguageEnglish,
+		Title:         fmt.Sprint("Episode ", episode),
+	}}
+	dl := s.dls.Add(s.ctx, logic.NewEpisodeDownloadParams(cfg, ep), func(error) {})
+	if status == logic.DownloadStatusWaiting {
+		dl.Go(struct{}{})
+		return dl
+	}
+	cl := dl.ProgressBinding().NewClient()
+	cl.Change(func(logic.DownloadProgress) logic.DownloadProgress {
+		return logic.DownloadProgress{Status: status, Value: -1}
+	})
+	dl.ProgressBinding().RemoveClient(cl)
+	return dl
+}
+
+func (s *testServer) downloadIDs(t *testing.T) string {
+	t.Helper()
+	_, body := s.do(t, http.MethodGet, "/api/downloads", "", nil)
+	var dls []struct {
+		ID       uint64 `json:"id"`
+		Position int    `json:"position"`
+	}
+	if err := json.Unmarshal([]byte(body), &dls); err != nil {
+		t.Fatalf("parse downloads: %v", err)
+	}
+	var ids []string
+	for i, v := range dls {
+		if v.Position != i {
+			t.Errorf("download %v: expected position %v, got %v", v.ID, i, v.Position)
+		}
+		ids = append(ids, fmt.Sprint(v.ID))
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestServerRejectsOtherSites(t *testing.T) {
+	s := newTestServer(t)
+	tests := []struct {
+		Header map[string]string
+		Status int
+	}{
+		{nil, http.StatusOK},
+		{map[string]string{"Origin": "http://localhost:3000"}, http.StatusOK},
+		{map[string]string{"Origin": "http://127.0.0.1:8765"}, http.StatusOK},
+		{map[string]string{"Origin": "http://[::1]"}, http.StatusOK},
+		{map[string]string{"Origin": "https://evil.example"}, http.StatusForbidden},
+		{map[string]string{"Origin": "null"}, http.StatusForbidden},
+		{map[string]string{"Host": "localhost:8765"}, http.StatusOK},
+		{map[string]string{"Host": "[::1]:8765"}, http.StatusOK},
+		// DNS rebinding
+		{map[string]string{"Host": "evil.example:8765"}, http.StatusForbidden},
+		{map[string]string{"Host": "evil.example"}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		resp, body := s.do(t, http.MethodGet, "/api/downloads", "", tt.Header)
+		if resp.StatusCode != tt.Status {
+			t.Errorf("%v: expected status %v, got %v (%v)", tt.Header, tt.Status, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestServerConfig(t *testing.T) {
+	s := newTestServer(t)
+	get := func() logic.Config {
+		t.Helper()
+		_, body := s.do(t, http.MethodGet, "/api/config", "", nil)
+		var cfg logic.Config
+		if err := json.Unmarshal([]byte(body), &cfg); err != nil {
+			t.Fatalf("parse config: %v", err)
+		}
+		return cfg
+	}
+	before := get()
+
+	// Fields missing from the body keep their values
+	resp, body := s.do(t, http.MethodPut, "/api/config", `{"ConcurrentDownloads": 3}`, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %v (%v)", resp.StatusCode, body)
+	}
+	after := get()
+	if after.ConcurrentDownloads != 3 {
+		t.Errorf("expected ConcurrentDownloads 3, got %v", after.ConcurrentDownloads)
+	}
+	after.ConcurrentDownloads = before.ConcurrentDownloads
+	if fmt.Sprint(after) != fmt.Sprint(before) {
+		t.Errorf("expected other fields to be unchanged, got %+v", after)
+	}
+
+	for _, body := range []string{
+		`{"ConcurrentDownloads": 0}`,
+		`{"CustomHosts": [{"Host": "sp.example", "Languages": ["XX"]}]}`,
+		`{"CustomHosts": [{"Host": "sp.example", "Languages": []}]}`,
+		`{"NoSuchField": true}`,
+		`{"ConcurrentDownloads": "many"}`,
+	} {
+		resp, res := s.do(t, http.MethodPut, "/api/config", body, nil)
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%v: expected status 400, got %v (%v)", body, resp.StatusCode, res)
+		}
+	}
+	if cfg := get(); cfg.ConcurrentDownloads != 3 || fmt.Sprint(cfg.CustomHosts) != fmt.Sprint(before.CustomHosts) {
+		t.Errorf("expected invalid changes not to be applied, got %+v", cfg)
+	}
+}
+
+func TestServerDownloads(t *testing.T) {
+	s := newTestServer(t)
+	dl1 := s.addDownload(t, 1, logic.DownloadStatusDone)
+	s.addDownload(t, 2, logic.DownloadStatusDone)
+	dl3 := s.addDownload(t, 3, logic.DownloadStatusWaiting)
+	if ids := s.downloadIDs(t); ids != "3,2,1" {
+		t.Fatalf("expected newest downloads first, got %v", ids)
+	}
+
+	// Positions are clamped
+	for _, tt := range []struct {
+		Position int
+		Want     string
+	}{
+		{-5, "1,3,2"},
+		{1, "3,1,2"},
+		{100, "3,2,1"},
+	} {
+		path := fmt.Sprintf("/api/downloads/%v/move", dl1.ID())
+		resp, body := s.do(t, http.MethodPost, path, fmt.Sprintf(`{"position": %v}`, tt.Position), nil)
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("move to %v: expected status 200, got %v (%v)", tt.Position, resp.StatusCode, body)
+		}
+		if ids := s.downloadIDs(t); ids != tt.Want {
+			t.Errorf("move to %v: expected %v, got %v", tt.Position, tt.Want, ids)
+		}
+	}
+
+	// Finished downloads are removed right away
+	resp, body := s.do(t, http.MethodDelete, fmt.Sprintf("/api/downloads/%v", dl1.ID()), "", nil)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("expected status 204, got %v (%v)", resp.StatusCode, body)
+	}
+	if ids := s.downloadIDs(t); ids != "3,2" {
+		t.Errorf("expected download 1 to be removed, got %v", ids)
+	}
+
+	// Waiting downloads are cancelled and then remove themselves
+	resp, body = s.do(t, http.MethodDelete, fmt.Sprintf("/api/downloads/%v", dl3.ID()), "", nil)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("expected status 204, got %v (%v)", resp.StatusCode, body)
+	}
+	for deadline := time.Now().Add(5 * time.Second); s.dls.Find(dl3.ID()) != nil; {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected cancelled download to be removed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if ids := s.downloadIDs(t); ids != "2" {
+		t.Errorf("expected download 3 to be removed, got %v", ids)
+	}
+}
+
+func TestServerNotFoundAndMethods(t *testing.T) {
+	s := newTestServer(t)
+	dl := s.addDownload(t, 1, logic.DownloadStatusDone)
+
+	tests := []struct {
+		Method string
+		Path   string
+		Status int
+		Allow  string
+	}{
+		{http.MethodGet, "/api/nothing", http.StatusNotFound, ""},
+		{http.MethodGet, "/api/downloads/999", http.StatusNotFound, ""},
+		{http.MethodGet, "/api/downloads/abc", http.StatusNotFound, ""},
+		{http.MethodGet, fmt.Sprintf("/api/downloads/%v/other", dl.ID()), http.StatusNotFound, ""},
+		{http.MethodPost, "/api/downloads/999/move", http.StatusNotFound, ""},
+		{http.MethodGet, fmt.Sprintf("/api/downloads/%v", dl.ID()), http.StatusOK, ""},
+		{http.MethodPut, "/api/downloads", http.StatusMethodNotAllowed, "GET, POST"},
+		{http.MethodPost, fmt.Sprintf("/api/downloads/%v", dl.ID()), http.StatusMethodNotAllowed, "GET, DELETE"},
+		{http.MethodGet, fmt.Sprintf("/api/downloads/%v/move", dl.ID()), http.StatusMethodNotAllowed, "POST"},
+		{http.MethodDelete, "/api/config", http.StatusMethodNotAllowed, "GET, PUT"},
+		{http.MethodPost, "/api/events", http.StatusMethodNotAllowed, "GET"},
+	}
+	for _, tt := range tests {
+		resp, body := s.do(t, tt.Method, tt.Path, "", nil)
+		if resp.StatusCode != tt.Status {
+			t.Errorf("%v %v: expected status %v, got %v (%v)", tt.Method, tt.Path, tt.Status, resp.StatusCode, body)
+		}
+		if allow := resp.Header.Get("Allow"); allow != tt.Allow {
+			t.Errorf("%v %v: expected Allow %q, got %q", tt.Method, tt.Path, tt.Allow, allow)
+		}
+		if tt.Status != http.StatusOK {
+			var res struct {
+				Error string `json:"error"`
+			}
+			if err := json.Unmarshal([]byte(body), &res); err != nil || res.Error == "" {
+				t.Errorf("%v %v: expected a JSON error, got %q", tt.Method, tt.Path, body)
+			}
+		}
+	}
+}
@@ -0,0 +1,339 @@
+package diskcache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/xypwn/southpark-downloader-ui/pkg/httputils"
+)
+
+const (
+	indexFileName = "index.json"
+	fetchTimeout  = time.Minute
+)
+
+type entry struct {
+	URL          string
+	Size         int64
+	ETag         string
+	LastModified string
+	LastUsed     time.Time
+
+	validated bool // Revalidated during this session
+}
+
+type call struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+// Caches HTTP responses on disk, keyed by URL. Entries are revalidated
+// once per session using ETag or Last-Modified and the least recently
+// used ones are removed when the cache grows beyond its maximum size.
+// A nil *Cache fetches without caching.
+type Cache struct {
+	Client *http.Client // http.DefaultClient if nil
+
+	dir     string
+	maxSize int64
+
+	mtx      sync.Mutex
+	entries  map[string]*entry // By key
+	size     int64
+	inFlight map[string]*call // By key
+	dirty    bool             // Index changed since it was saved
+
+	listeners []func()
+}
+
+// Creates the cache directory if it doesn't exist and loads the index.
+func New(dir string, maxSize int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create cache directory: %w", err)
+	}
+	c := &Cache{
+		dir:      dir,
+		maxSize:  maxSize,
+		entries:  make(map[string]*entry),
+		inFlight: make(map[string]*call),
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, indexFileName))
+	if err == nil {
+		if err := json.Unmarshal(data, &c.entries); err != nil {
+			// A broken index just means starting over
+			c.entries = make(map[string]*entry)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("read cache index: %w", err)
+	}
+	for k, v := range c.entries {
+		if _, err := os.Stat(c.path(k)); err != nil {
+			delete(c.entries, k)
+			continue
+		}
+		c.size += v.Size
+	}
+	return c, nil
+}
+
+func key(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.dir, key)
+}
+
+// Returns the body of url. Concurrent calls for the same URL
+// share a single request.
+func (c *Cache) Get(ctx context.Context, url string) ([]byte, error) {
+	if c == nil {
+		return httputils.GetBodyWithContext(ctx, url)
+	}
+
+	k := key(url)
+
+	c.mtx.Lock()
+	cl, ok := c.inFlight[k]
+	if !ok {
+		cl = &call{done: make(chan struct{})}
+		c.inFlight[k] = cl
+		go func() {
+			// Not tied to ctx, since other callers might still be waiting
+			cl.data, cl.err = c.fetch(k, url)
+			c.mtx.Lock()
+			delete(c.inFlight, k)
+			c.mtx.Unlock()
+			close(cl.done)
+		}()
+	}
+	c.mtx.Unlock()
+
+	select {
+	case <-cl.done:
+		return cl.data, cl.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *Cache) fetch(k, url string) ([]byte, error) {
+	c.mtx.Lock()
+	var e entry
+	cached := false
+	if v, ok := c.entries[k]; ok {
+		e = *v
+		cached = true
+	}
+	c.mtx.Unlock()
+
+	var data []byte
+	if cached {
+		var err error
+		data, err = os.ReadFile(c.path(k))
+		if err != nil {
+			cached = false
+		} else if e.validated {
+			c.touch(k)
+			return data, nil
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if cached {
+		if e.ETag != "" {
+			req.Header.Set("If-None-Match", e.ETag)
+		}
+		if e.LastModified != "" {
+			req.Header.Set("If-Modified-Since", e.LastModified)
+		}
+	}
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		if cached {
+			// Offline, the cached version is better than nothing
+			c.touch(k)
+			return data, nil
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if cached && resp.StatusCode == http.StatusNotModified {
+		c.mtx.Lock()
+		if v, ok := c.entries[k]; ok {
+			v.validated = true
+		}
+		c.mtx.Unlock()
+		c.touch(k)
+		return data, nil
+	}
+	if err := httputils.CheckStatus(resp); err != nil {
+		return nil, err
+	}
+	data, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.WriteFile(c.path(k), data, 0644); err != nil {
+		// Caching is optional
+		return data, nil
+	}
+
+	c.mtx.Lock()
+	if old, ok := c.entries[k]; ok {
+		c.size -= old.Size
+	}
+	c.entries[k] = &entry{
+		URL:          url,
+		Size:         int64(len(data)),
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		LastUsed:     time.Now(),
+		validated:    true,
+	}
+	c.size += int64(len(data))
+	c.evict()
+	c.saveIndex() // Caching is optional
+	listeners := c.listeners
+	c.mtx.Unlock()
+	for _, fn := range listeners {
+		fn()
+	}
+
+	return data, nil
+}
+
+// Only saves the new time along with the next stored entry, since
+// writing the index on every hit would be wasteful.
+func (c *Cache) touch(k string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if v, ok := c.entries[k]; ok {
+		v.LastUsed = time.Now()
+		c.dirty = true
+	}
+}
+
+// Removes the least recently used entries until the size is within
+// bounds. Not thread-safe on its own!
+func (c *Cache) evict() {
+	if c.size <= c.maxSize {
+		return
+	}
+	keys := make([]string, 0, len(c.entries))
+	for k := range c.entries {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return c.entries[keys[i]].LastUsed.Before(c.entries[keys[j]].LastUsed)
+	})
+	for _, k := range keys {
+		if c.size <= c.maxSize {
+			break
+		}
+		c.size -= c.entries[k].Size
+		delete(c.entries, k)
+		os.Remove(c.path(k))
+	}
+}
+
+// Writes the index to a temporary file first, so it isn't left
+// half-written. Not thread-safe on its own!
+func (c *Cache) saveIndex() error {
+	data, err := json.Marshal(c.entries)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(c.dir, indexFileName+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(c.dir, indexFileName))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	c.dirty = false
+	return nil
+}
+
+// Saves the recently used times of entries that were only read since
+// the index was last saved, e.g. before exiting.
+func (c *Cache) Flush() error {
+	if c == nil {
+		return nil
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if !c.dirty {
+		return nil
+	}
+	return c.saveIndex()
+}
+
+// Total size of all cached entries in bytes.
+func (c *Cache) Size() int64 {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.size
+}
+
+// Calls fn whenever entries are stored or removed, e.g. to show the
+// new Size. fn must not block.
+func (c *Cache) AddSizeListener(fn func()) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.listeners = append(c.listeners, fn)
+}
+
+// Removes all entries.
+func (c *Cache) Clear() error {
+	c.mtx.Lock()
+	for k := range c.entries {
+		os.Remove(c.path(k))
+	}
+	c.entries = make(map[string]*entry)
+	c.size = 0
+	c.dirty = false
+	listeners := c.listeners
+	c.mtx.Unlock()
+	for _, fn := range listeners {
+		fn()
+	}
+
+	if err := os.Remove(filepath.Join(c.dir, indexFileName)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
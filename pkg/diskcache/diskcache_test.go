@@ -0,0 +1,174 @@
+package diskcache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	var requests, notModified atomic.Int32
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.URL.Path == "/slow" {
+			select {
+			case started <- struct{}{}:
+			default:
+			}
+			<-release
+		}
+		etag := `"` + r.URL.Path + `"`
+		if r.Header.Get("If-None-Match") == etag {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write([]byte(strings.Repeat("x", 10) + r.URL.Path))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	ctx := context.Background()
+	c, err := New(dir, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Concurrent requests share one fetch
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data, err := c.Get(ctx, srv.URL+"/slow")
+			if err != nil || string(data) != "xxxxxxxxxx/slow" {
+				t.Errorf("unexpected result: %q (%v)", data, err)
+			}
+		}()
+		if i == 0 {
+			// The others start while the first request is pending
+			<-started
+		}
+	}
+	close(release)
+	wg.Wait()
+	if n := requests.Load(); n != 1 {
+		t.Errorf("expected 1 request, got %v", n)
+	}
+
+	// Validated entries are served without a request
+	if _, err := c.Get(ctx, srv.URL+"/slow"); err != nil {
+		t.Fatal(err)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("expected 1 request, got %v", n)
+	}
+
+	// A new session revalidates once
+	c, err = New(dir, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if data, err := c.Get(ctx, srv.URL+"/slow"); err != nil || string(data) != "xxxxxxxxxx/slow" {
+			t.Fatalf("unexpected result: %q (%v)", data, err)
+		}
+	}
+	if n := notModified.Load(); n != 1 {
+		t.Errorf("expected 1 revalidation, got %v", n)
+	}
+
+	// Least recently used entries are evicted, /slow (15 bytes)
+	// was used last, so /a (12 bytes) is evicted
+	for _, p := range []string{"/a", "/slow", "/b", "/c"} {
+		if _, err := c.Get(ctx, srv.URL+p); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if size := c.Size(); size > 40 {
+		t.Errorf("expected size <= 40, got %v", size)
+	}
+	before := requests.Load()
+	if _, err := c.Get(ctx, srv.URL+"/slow"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(ctx, srv.URL+"/a"); err != nil {
+		t.Fatal(err)
+	}
+	if n := requests.Load() - before; n != 1 {
+		t.Errorf("expected only the evicted entry to be fetched, got %v requests", n)
+	}
+
+	// Cached entries are used when offline
+	srv.Close()
+	c, err = New(dir, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := c.Get(ctx, srv.URL+"/a"); err != nil || string(data) != "xxxxxxxxxx/a" {
+		t.Errorf("expected cached data when offline, got %q (%v)", data, err)
+	}
+
+	if err := c.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Size() != 0 {
+		t.Errorf("expected empty cache")
+	}
+}
+
+func TestCacheFlush(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("x"))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	ctx := context.Background()
+	c, err := New(dir, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var changes atomic.Int32
+	c.AddSizeListener(func() {
+		changes.Add(1)
+	})
+	if _, err := c.Get(ctx, srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	stored := c.entries[key(srv.URL)].LastUsed
+
+	// Hits are only saved when flushing
+	time.Sleep(time.Millisecond)
+	if _, err := c.Get(ctx, srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	if n := changes.Load(); n != 1 {
+		t.Errorf("expected 1 size change, got %v", n)
+	}
+	lastUsed := func() time.Time {
+		c, err := New(dir, 40)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c.entries[key(srv.URL)].LastUsed
+	}
+	if !lastUsed().Equal(stored) {
+		t.Errorf("expected hit not to be saved yet")
+	}
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if !lastUsed().After(stored) {
+		t.Errorf("expected hit to be saved after flushing")
+	}
+}
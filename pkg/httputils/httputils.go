@@ -2,6 +2,7 @@ package httputils
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -9,6 +10,35 @@ import (
 	"github.com/xypwn/southpark-downloader-ui/pkg/ioutils"
 )
 
+// Matches any StatusError with status 429.
+var ErrTooManyRequests = errors.New("too many requests")
+
+type StatusError struct {
+	URL        string
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("get '%v': HTTP error: %v", e.URL, e.Status)
+}
+
+func (e *StatusError) Is(target error) bool {
+	return target == ErrTooManyRequests && e.StatusCode == http.StatusTooManyRequests
+}
+
+// Returns a *StatusError if resp's status isn't 200 OK.
+func CheckStatus(resp *http.Response) error {
+	if resp.StatusCode != http.StatusOK {
+		return &StatusError{
+			URL:        resp.Request.URL.String(),
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+		}
+	}
+	return nil
+}
+
 func GetWithContext(ctx context.Context, url string) (*http.Response, error) {
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
@@ -24,8 +54,8 @@ func GetBodyWithContext(ctx context.Context, url string) ([]byte, error) {
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("get '%v': HTTP error: %v", url, resp.Status)
+	if err := CheckStatus(resp); err != nil {
+		return nil, err
 	}
 
 	body, err := io.ReadAll(ioutils.NewCtxReader(ctx, resp.Body))
@@ -24,3 +24,26 @@ func (r *CtxReader) Read(p []byte) (n int, err error) {
 	}
 	return r.Reader.Read(p)
 }
+
+// Reader type which reports the total number of bytes read so far
+type ProgressReader struct {
+	io.Reader
+	n          int64
+	onProgress func(n int64)
+}
+
+func NewProgressReader(r io.Reader, onProgress func(bytesRead int64)) io.Reader {
+	return &ProgressReader{
+		Reader:     r,
+		onProgress: onProgress,
+	}
+}
+
+func (r *ProgressReader) Read(p []byte) (n int, err error) {
+	n, err = r.Reader.Read(p)
+	if n > 0 {
+		r.n += int64(n)
+		r.onProgress(r.n)
+	}
+	return n, err
+}
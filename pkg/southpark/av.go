@@ -2,11 +2,13 @@ package southpark
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 
+	"github.com/xypwn/southpark-downloader-ui/pkg/ioutils"
 	"github.com/yapingcat/gomedia/go-codec"
 	"github.com/yapingcat/gomedia/go-mp4"
 	"github.com/yapingcat/gomedia/go-mpeg2"
@@ -18,11 +20,40 @@ type SegmentFile struct {
 	Skip     bool // HACK for certain episodes with faulty segments
 }
 
-func ConvertTSAndAACToMP4(tsInput []SegmentFile, aacInput []SegmentFile, mp4Output io.WriteSeeker, onProgress func(progress float64)) error {
+// Errors returned by readers are passed through the demuxer, so we use
+// this to abort demuxing as soon as muxing a frame fails.
+type errCheckReader struct {
+	r   io.Reader
+	err *error
+}
+
+func (r errCheckReader) Read(p []byte) (int, error) {
+	if *r.err != nil {
+		return 0, *r.err
+	}
+	return r.r.Read(p)
+}
+
+// Progress is reported in bytes read relative to the total size of all
+// input segments. Returns ctx.Err() if ctx is canceled mid-run, in which
+// case mp4Output contains incomplete data. With MP4LayoutFragmented,
+// incomplete data is playable up to the last complete fragment.
+// MP4LayoutFastStart is written like MP4LayoutStandard; use
+// MoveMP4MoovToFront on the result.
+// If aacInput is empty, AAC audio muxed into the MPEG-TS input is used.
+// Returns ErrUnsupportedMP4 for audio other than AAC, e.g. AC-3.
+func ConvertTSAndAACToMP4(ctx context.Context, tsInput []SegmentFile, aacInput []SegmentFile, mp4Output io.WriteSeeker, layout MP4Layout, onProgress func(progress float64)) error {
 	//var _vdts uint64
 	//var _vpts uint64
 
-	muxer, err := mp4.CreateMp4Muxer(mp4Output)
+	var muxerOpts []mp4.MuxerOption
+	if layout == MP4LayoutFragmented {
+		// Fragments are cut at each video keyframe, which is where
+		// each HLS segment starts
+		muxerOpts = append(muxerOpts, mp4.WithMp4Flag(mp4.MP4_FLAG_FRAGMENT|mp4.MP4_FLAG_KEYFRAME))
+	}
+
+	muxer, err := mp4.CreateMp4Muxer(mp4Output, muxerOpts...)
 	if err != nil {
 		return fmt.Errorf("create mp4 muxer: %w", err)
 	}
@@ -41,54 +72,136 @@ func ConvertTSAndAACToMP4(tsInput []SegmentFile, aacInput []SegmentFile, mp4Outp
 	hasVideo := false
 	var atid uint32 = 0
 	var vtid uint32 = 0
+	var videoCodec Codec
+	var tsAudioCodec Codec
 	prevADTS := float64(0)
 	tsDemuxer := mpeg2.NewTSDemuxer()
+	tsDemuxer.OnTSPacket = func(pkg *mpeg2.TSPacket) {
+		// The demuxer silently drops elementary streams it doesn't know,
+		// so we look at the PMT ourselves to be able to report them
+		pmt, ok := pkg.Payload.(*mpeg2.Pmt)
+		if !ok {
+			return
+		}
+		for _, v := range pmt.Streams {
+			c := codecFromTSStreamType(mpeg2.TS_STREAM_TYPE(v.StreamType))
+			if c.IsVideo() && videoCodec == CodecUnknown {
+				videoCodec = c
+			} else if c.IsAudio() && tsAudioCodec == CodecUnknown {
+				tsAudioCodec = c
+			}
+		}
+	}
+	muxedAudio := len(aacInput) == 0
 	tsDemuxer.OnFrame = func(cid mpeg2.TS_STREAM_TYPE, vframe []byte, vpts uint64 /* in ms */, vdts uint64 /* in ms */) {
-		if cid == mpeg2.TS_STREAM_H264 {
-			//for uint64(float64(vdts) * 1.000642) > prevADTS {
-			for float64(vdts) > prevADTS {
-				if !hasAudio {
-					atid = muxer.AddAudioTrack(mp4.MP4_CODEC_AAC)
-					hasAudio = true
-				}
-				if len(aacFrameBuf) > 0 {
-					if err := muxer.Write(atid, aacFrameBuf[0], uint64(apts), uint64(adts)); err != nil {
-						onFrameErr = err
-					}
-					prevADTS = adts
-				} else {
-					break
-				}
-				aacFrameBuf = aacFrameBuf[1:]
-				apts += aacMillisecondsPerFrame
-				adts += aacMillisecondsPerFrame
+		c := codecFromTSStreamType(cid)
+		if c == CodecAAC && muxedAudio {
+			if !hasAudio {
+				atid = muxer.AddAudioTrack(mp4.MP4_CODEC_AAC)
+				hasAudio = true
 			}
+			if err := muxer.Write(atid, vframe, vpts, vdts); err != nil {
+				onFrameErr = err
+			}
+			return
+		}
+		if !c.IsVideo() {
+			return
+		}
+		if videoCodec == CodecUnknown {
+			videoCodec = c
+		}
+		if c != videoCodec {
+			// Only one video track is supported
+			return
+		}
 
-			if !hasVideo {
-				vtid = muxer.AddVideoTrack(mp4.MP4_CODEC_H264)
-				hasVideo = true
+		//for uint64(float64(vdts) * 1.000642) > prevADTS {
+		for !muxedAudio && float64(vdts) > prevADTS {
+			if !hasAudio {
+				atid = muxer.AddAudioTrack(mp4.MP4_CODEC_AAC)
+				hasAudio = true
 			}
-			if err := muxer.Write(vtid, vframe, uint64(vpts), uint64(vdts)); err != nil {
-				onFrameErr = err
+			if len(aacFrameBuf) > 0 {
+				if err := muxer.Write(atid, aacFrameBuf[0], uint64(apts), uint64(adts)); err != nil {
+					onFrameErr = err
+				}
+				prevADTS = adts
+			} else {
+				break
 			}
-			//_vpts = vpts
-			//_vdts = vdts
+			aacFrameBuf = aacFrameBuf[1:]
+			apts += aacMillisecondsPerFrame
+			adts += aacMillisecondsPerFrame
+		}
+
+		if !hasVideo {
+			mp4Codec, _ := videoCodec.mp4Codec()
+			vtid = muxer.AddVideoTrack(mp4Codec)
+			hasVideo = true
 		}
+		if err := muxer.Write(vtid, vframe, uint64(vpts), uint64(vdts)); err != nil {
+			onFrameErr = err
+		}
+		//_vpts = vpts
+		//_vdts = vdts
 	}
 
-	if len(aacInput) != len(tsInput) {
+	if !muxedAudio && len(aacInput) != len(tsInput) {
 		return fmt.Errorf("number of AAC segments (%v) and TS segments (%v) doesn't match", len(aacInput), len(tsInput))
 	}
 
+	var totalBytes int64
+	for i := range tsInput {
+		segs := []SegmentFile{tsInput[i]}
+		if !muxedAudio {
+			segs = append(segs, aacInput[i])
+		}
+		for _, seg := range segs {
+			if seg.Skip {
+				continue
+			}
+			info, err := os.Stat(seg.Filename)
+			if err != nil {
+				return err
+			}
+			totalBytes += info.Size()
+		}
+	}
+
+	var doneBytes int64
+	var prevPermille int64 = -1
+	setProgress := func(bytes int64) {
+		if totalBytes == 0 {
+			return
+		}
+		// Don't flood the listener; per-mille resolution is plenty
+		if permille := (doneBytes + bytes) * 1000 / totalBytes; permille != prevPermille {
+			prevPermille = permille
+			onProgress(float64(permille) / 1000)
+		}
+	}
+
 	var aHLSTime float64
 	for i := range tsInput {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		adts = aHLSTime * 1000
 		apts = aHLSTime * 1000
-		{
+		if !muxedAudio {
 			data, err := os.ReadFile(aacInput[i].Filename)
 			if err != nil {
 				return err
 			}
+			doneBytes += int64(len(data))
+			setProgress(0)
+			if i == 0 {
+				if c := detectPackedAudioCodec(data); c != CodecAAC {
+					return fmt.Errorf("%v audio: %w", c, ErrUnsupportedMP4)
+				}
+			}
 			codec.SplitAACFrame(data, func(frame []byte) {
 				aacFrameBuf = append(aacFrameBuf, frame)
 			})
@@ -98,26 +211,54 @@ func ConvertTSAndAACToMP4(tsInput []SegmentFile, aacInput []SegmentFile, mp4Outp
 			if err != nil {
 				return err
 			}
-			if err := tsDemuxer.Input(bytes.NewReader(data)); err != nil {
-				if errors.Is(err, io.ErrUnexpectedEOF) {
+			r := ioutils.NewProgressReader(
+				ioutils.NewCtxReader(ctx, errCheckReader{bytes.NewReader(data), &onFrameErr}),
+				setProgress,
+			)
+			if err := tsDemuxer.Input(r); err != nil {
+				if onFrameErr != nil {
+					return fmt.Errorf("mp4 muxer: %w", onFrameErr)
+				} else if ctxErr := ctx.Err(); ctxErr != nil {
+					return ctxErr
+				} else if errors.Is(err, io.ErrUnexpectedEOF) {
 					// File is incomplete, ignore
 				} else {
 					return fmt.Errorf("MPEG-TS demuxer: %w", err)
 				}
 			}
+			if onFrameErr != nil {
+				return fmt.Errorf("mp4 muxer: %w", onFrameErr)
+			}
+			doneBytes += int64(len(data))
+			setProgress(0)
 
-			aHLSTime += aacInput[i].Duration
+			if !muxedAudio {
+				aHLSTime += aacInput[i].Duration
+			}
+		}
+		if muxedAudio {
+			if _, ok := tsAudioCodec.mp4Codec(); tsAudioCodec != CodecUnknown && !ok {
+				return fmt.Errorf("%v audio: %w", tsAudioCodec, ErrUnsupportedMP4)
+			}
 		}
-		onProgress(float64(i) / float64(len(tsInput)))
 	}
 
-	muxer.WriteTrailer()
-
-	//fmt.Printf("**** %f %v %v %f %f\n", adts, _vpts, _vdts, float64(_vdts) - adts, float64(_vdts) / adts)
+	if !hasVideo {
+		if videoCodec == CodecUnknown {
+			return errors.New("no supported video stream found in MPEG-TS input")
+		}
+		return fmt.Errorf("no %v video frames found in MPEG-TS input", videoCodec)
+	}
 
 	if onFrameErr != nil {
 		return fmt.Errorf("mp4 muxer: %w", onFrameErr)
 	}
 
+	if err := muxer.WriteTrailer(); err != nil {
+		return fmt.Errorf("mp4 muxer: write trailer: %w", err)
+	}
+
+	//fmt.Printf("**** %f %v %v %f %f\n", adts, _vpts, _vdts, float64(_vdts) - adts, float64(_vdts) / adts)
+
 	return nil
 }
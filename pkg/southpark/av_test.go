@@ -0,0 +1,128 @@
+package southpark
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/yapingcat/gomedia/go-mpeg2"
+)
+
+var (
+	testH264SPS = []byte{0x00, 0x00, 0x00, 0x01, 0x67, 0x64, 0x00, 0x0A, 0xAC, 0x72, 0x84, 0x44,
+		0x26, 0x84, 0x00, 0x00, 0x03, 0x00, 0x04, 0x00, 0x00, 0x03, 0x00, 0xCA, 0x3C, 0x48, 0x96, 0x11, 0x80}
+	testH264PPS    = []byte{0x00, 0x00, 0x00, 0x01, 0x68, 0xE8, 0x43, 0x8F, 0x13, 0x21, 0x30}
+	testH264IDR    = []byte{0x00, 0x00, 0x00, 0x01, 0x65, 0x88, 0x84, 0x00, 0x33, 0xFF}
+	testH264PSlice = []byte{0x00, 0x00, 0x00, 0x01, 0x41, 0x9A, 0x21, 0x6C, 0x42, 0xFF}
+)
+
+// Writes MPEG-TS segments holding H.264 video, each starting with a
+// keyframe. If audio isn't 0, a stream of that type is added.
+func writeTestTSSegments(t *testing.T, nSegments, framesPerSegment int, audio mpeg2.TS_STREAM_TYPE) []SegmentFile {
+	t.Helper()
+	var res []SegmentFile
+	var pts uint64
+	for i := 0; i < nSegments; i++ {
+		var buf bytes.Buffer
+		mux := mpeg2.NewTSMuxer()
+		mux.OnPacket = func(pkg []byte) {
+			buf.Write(pkg)
+		}
+		pid := mux.AddStream(mpeg2.TS_STREAM_H264)
+		var apid uint16
+		if audio != 0 {
+			apid = mux.AddStream(audio)
+		}
+		for j := 0; j < framesPerSegment; j++ {
+			var frame []byte
+			if j == 0 {
+				frame = append(append(append(frame, testH264SPS...), testH264PPS...), testH264IDR...)
+			} else {
+				frame = append(frame, testH264PSlice...)
+			}
+			if err := mux.Write(pid, frame, pts, pts); err != nil {
+				t.Fatalf("write TS: %v", err)
+			}
+			if audio != 0 {
+				// AC-3 sync frame header; the content doesn't matter
+				if err := mux.Write(apid, []byte{0x0B, 0x77, 0x00, 0x00, 0x00, 0x40, 0x00, 0x00}, pts, pts); err != nil {
+					t.Fatalf("write TS: %v", err)
+				}
+			}
+			pts += 40
+		}
+		filename := path.Join(t.TempDir(), "seg.ts")
+		if err := os.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		res = append(res, SegmentFile{
+			Filename: filename,
+			Duration: float64(framesPerSegment) * 0.04,
+		})
+	}
+	return res
+}
+
+// Counts the top-level boxes of an MP4 file by type.
+func countMP4Boxes(t *testing.T, data []byte) map[string]int {
+	t.Helper()
+	res := make(map[string]int)
+	for len(data) > 0 {
+		if len(data) < 8 {
+			t.Fatalf("truncated box header")
+		}
+		size := binary.BigEndian.Uint32(data)
+		if size < 8 || int(size) > len(data) {
+			t.Fatalf("invalid box size %v", size)
+		}
+		res[string(data[4:8])]++
+		data = data[size:]
+	}
+	return res
+}
+
+func TestConvertTSToFragmentedMP4(t *testing.T) {
+	ts := writeTestTSSegments(t, 3, 5, 0)
+
+	out, err := os.Create(path.Join(t.TempDir(), "out.mp4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	if err := ConvertTSAndAACToMP4(context.Background(), ts, nil, out, MP4LayoutFragmented, func(float64) {}); err != nil {
+		t.Fatalf("ConvertTSAndAACToMP4: %v", err)
+	}
+
+	data, err := os.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	boxes := countMP4Boxes(t, data)
+	if boxes["moov"] != 1 {
+		t.Errorf("expected 1 moov box, got %v", boxes["moov"])
+	}
+	if boxes["moof"] < 2 {
+		t.Errorf("expected a moof box per keyframe, got %v", boxes["moof"])
+	}
+	if boxes["moof"] != boxes["mdat"] {
+		t.Errorf("expected as many mdat boxes as moof boxes, got %v and %v", boxes["mdat"], boxes["moof"])
+	}
+}
+
+func TestConvertTSWithAC3ToMP4(t *testing.T) {
+	ts := writeTestTSSegments(t, 2, 5, 0x81)
+
+	out, err := os.Create(path.Join(t.TempDir(), "out.mp4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	err = ConvertTSAndAACToMP4(context.Background(), ts, nil, out, MP4LayoutStandard, func(float64) {})
+	if !errors.Is(err, ErrUnsupportedMP4) {
+		t.Errorf("expected ErrUnsupportedMP4, got %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+package southpark
+
+import (
+	"strings"
+
+	"github.com/yapingcat/gomedia/go-mp4"
+	"github.com/yapingcat/gomedia/go-mpeg2"
+)
+
+type Codec int
+
+const (
+	CodecUnknown Codec = iota
+	CodecH264
+	CodecH265
+	CodecAAC
+	CodecAC3
+	CodecEAC3
+)
+
+func (c Codec) String() string {
+	switch c {
+	case CodecH264:
+		return "H.264"
+	case CodecH265:
+		return "H.265"
+	case CodecAAC:
+		return "AAC"
+	case CodecAC3:
+		return "AC-3"
+	case CodecEAC3:
+		return "E-AC-3"
+	default:
+		return "Unknown"
+	}
+}
+
+func (c Codec) IsVideo() bool {
+	return c == CodecH264 || c == CodecH265
+}
+
+func (c Codec) IsAudio() bool {
+	return c == CodecAAC || c == CodecAC3 || c == CodecEAC3
+}
+
+// Parses a single RFC 6381 codec string, e.g. "avc1.64001f" or "mp4a.40.2".
+func CodecFromString(s string) Codec {
+	s = strings.TrimSpace(s)
+	typ, _, _ := strings.Cut(s, ".")
+	switch strings.ToLower(typ) {
+	case "avc1", "avc3":
+		return CodecH264
+	case "hvc1", "hev1":
+		return CodecH265
+	case "mp4a":
+		return CodecAAC
+	case "ac-3":
+		return CodecAC3
+	case "ec-3":
+		return CodecEAC3
+	default:
+		return CodecUnknown
+	}
+}
+
+// Parses the value of an HLS CODECS attribute, e.g. "avc1.64001f,mp4a.40.2".
+// Unknown codecs are returned as CodecUnknown.
+func ParseCodecs(s string) []Codec {
+	if s == "" {
+		return nil
+	}
+	var res []Codec
+	for _, v := range strings.Split(s, ",") {
+		res = append(res, CodecFromString(v))
+	}
+	return res
+}
+
+func codecFromTSStreamType(cid mpeg2.TS_STREAM_TYPE) Codec {
+	switch cid {
+	case mpeg2.TS_STREAM_H264:
+		return CodecH264
+	case mpeg2.TS_STREAM_H265:
+		return CodecH265
+	case mpeg2.TS_STREAM_AAC:
+		return CodecAAC
+	case 0x81: // ATSC A/52 AC-3
+		return CodecAC3
+	case 0x87: // ATSC A/52 E-AC-3
+		return CodecEAC3
+	default:
+		return CodecUnknown
+	}
+}
+
+// ok is false if the codec can't be written into an MP4 container.
+func (c Codec) mp4Codec() (cid mp4.MP4_CODEC_TYPE, ok bool) {
+	switch c {
+	case CodecH264:
+		return mp4.MP4_CODEC_H264, true
+	case CodecH265:
+		return mp4.MP4_CODEC_H265, true
+	case CodecAAC:
+		return mp4.MP4_CODEC_AAC, true
+	default:
+		return 0, false
+	}
+}
+
+// Detects the codec of packed (raw elementary stream) HLS audio
+// by looking at its first sync word.
+func detectPackedAudioCodec(data []byte) Codec {
+	// Skip ID3 tag, which HLS packed audio segments start with
+	if len(data) >= 10 && string(data[:3]) == "ID3" {
+		size := int(data[6]&0x7f)<<21 |
+			int(data[7]&0x7f)<<14 |
+			int(data[8]&0x7f)<<7 |
+			int(data[9]&0x7f)
+		if 10+size > len(data) {
+			return CodecUnknown
+		}
+		data = data[10+size:]
+	}
+	if len(data) < 6 {
+		return CodecUnknown
+	}
+	if data[0] == 0xFF && data[1]&0xF0 == 0xF0 {
+		return CodecAAC
+	}
+	if data[0] == 0x0B && data[1] == 0x77 {
+		// bsid > 10 means E-AC-3 (ATSC A/52 Annex E)
+		if bsid := data[5] >> 3; bsid > 10 {
+			return CodecEAC3
+		}
+		return CodecAC3
+	}
+	return CodecUnknown
+}
@@ -0,0 +1,71 @@
+package southpark
+
+import (
+	"testing"
+)
+
+func TestParseCodecs(t *testing.T) {
+	tests := []struct {
+		in    string
+		video Codec
+		audio Codec
+	}{
+		{"avc1.64001f,mp4a.40.2", CodecH264, CodecAAC},
+		{"hvc1.1.6.L93.B0,mp4a.40.2", CodecH265, CodecAAC},
+		{"hev1.1.6.L120.90, ec-3", CodecH265, CodecEAC3},
+		{"avc1.4d401f,ac-3", CodecH264, CodecAC3},
+		{"vp09.00.10.08", CodecUnknown, CodecUnknown},
+		{"", CodecUnknown, CodecUnknown},
+	}
+
+	for _, tt := range tests {
+		f := HLSFormat{Codecs: tt.in}
+		if c := f.VideoCodec(); c != tt.video {
+			t.Errorf("%q: expected video codec %v, got %v", tt.in, tt.video, c)
+		}
+		if c := f.AudioCodec(); c != tt.audio {
+			t.Errorf("%q: expected audio codec %v, got %v", tt.in, tt.audio, c)
+		}
+	}
+}
+
+func TestFilterFormatsByVideoCodec(t *testing.T) {
+	fmts := []HLSFormat{
+		{Codecs: "hvc1.1.6.L120.90,mp4a.40.2", Height: 1080},
+		{Codecs: "avc1.64001f,mp4a.40.2", Height: 1080},
+		{Codecs: "", Height: 720},
+	}
+
+	res := FilterFormatsByVideoCodec(fmts, CodecH264)
+	if len(res) != 2 || res[0].Height != 1080 || res[1].Height != 720 {
+		t.Errorf("expected both H.264 formats in order, got %v", res)
+	}
+
+	res = FilterFormatsByVideoCodec(fmts, CodecH265)
+	if len(res) != 1 || res[0].VideoCodec() != CodecH265 {
+		t.Errorf("expected only the H.265 format, got %v", res)
+	}
+}
+
+func TestDetectPackedAudioCodec(t *testing.T) {
+	id3 := []byte{'I', 'D', '3', 4, 0, 0, 0, 0, 0, 2, 0, 0}
+
+	tests := []struct {
+		name string
+		data []byte
+		want Codec
+	}{
+		{"ADTS", []byte{0xFF, 0xF1, 0x50, 0x80, 0x00, 0x1F}, CodecAAC},
+		{"ADTS with ID3", append(id3, 0xFF, 0xF1, 0x50, 0x80, 0x00, 0x1F), CodecAAC},
+		{"AC-3", []byte{0x0B, 0x77, 0x00, 0x00, 0x00, 8 << 3}, CodecAC3},
+		{"E-AC-3", []byte{0x0B, 0x77, 0x00, 0x00, 0x00, 16 << 3}, CodecEAC3},
+		{"garbage", []byte{1, 2, 3, 4, 5, 6}, CodecUnknown},
+		{"too short", []byte{0xFF}, CodecUnknown},
+	}
+
+	for _, tt := range tests {
+		if c := detectPackedAudioCodec(tt.data); c != tt.want {
+			t.Errorf("%v: expected %v, got %v", tt.name, tt.want, c)
+		}
+	}
+}
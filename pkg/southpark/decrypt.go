@@ -0,0 +1,97 @@
+package southpark
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Decrypts AES-CBC data as it is read from r and strips the
+// PKCS#7 padding once r reaches EOF. Only holds back the last
+// block, since it can't be known to be the last one before EOF.
+type cbcDecryptReader struct {
+	r       io.Reader
+	mode    cipher.BlockMode
+	chunk   []byte // Buffer for reading from r
+	in      []byte // Encrypted bytes that don't make up a full block yet
+	out     []byte // Decrypted bytes ready to be returned
+	held    []byte // Last decrypted block
+	outBuf  []byte
+	done    bool
+	readErr error
+}
+
+func newCBCDecryptReader(r io.Reader, key []byte, iv []byte) (io.Reader, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: aes.NewCipher: %v", ErrDecryptFailed, err)
+	}
+	if len(iv) != block.BlockSize() {
+		return nil, fmt.Errorf("%w: IV length (%v) doesn't match AES block size (%v)", ErrDecryptFailed, len(iv), block.BlockSize())
+	}
+	return &cbcDecryptReader{
+		r:     r,
+		mode:  cipher.NewCBCDecrypter(block, iv),
+		chunk: make([]byte, 32*1024),
+	}, nil
+}
+
+func (r *cbcDecryptReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if r.readErr != nil {
+			return 0, r.readErr
+		}
+		if err := r.fill(); err != nil {
+			r.readErr = err
+		}
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+func (r *cbcDecryptReader) fill() error {
+	n, err := r.r.Read(r.chunk)
+	r.in = append(r.in, r.chunk[:n]...)
+
+	if nBlocks := len(r.in) / aes.BlockSize; nBlocks > 0 {
+		// Reuse the output buffer, r.out is empty at this point
+		r.outBuf = append(r.outBuf[:0], r.held...)
+		start := len(r.outBuf)
+		r.outBuf = append(r.outBuf, r.in[:nBlocks*aes.BlockSize]...)
+		r.mode.CryptBlocks(r.outBuf[start:], r.outBuf[start:])
+		r.in = append(r.in[:0], r.in[nBlocks*aes.BlockSize:]...)
+
+		split := len(r.outBuf) - aes.BlockSize
+		r.held = append(r.held[:0], r.outBuf[split:]...)
+		r.out = r.outBuf[:split]
+	}
+
+	if errors.Is(err, io.EOF) {
+		if len(r.in) != 0 {
+			return fmt.Errorf("%w: encrypted data length is not a multiple of AES block size", ErrDecryptFailed)
+		}
+		if len(r.held) == 0 {
+			return fmt.Errorf("%w: cipher data too short", ErrDecryptFailed)
+		}
+		pad := int(r.held[len(r.held)-1])
+		if pad == 0 || pad > aes.BlockSize {
+			return fmt.Errorf("%w: invalid PKCS#7 padding", ErrDecryptFailed)
+		}
+		for _, v := range r.held[len(r.held)-pad:] {
+			if int(v) != pad {
+				return fmt.Errorf("%w: invalid PKCS#7 padding", ErrDecryptFailed)
+			}
+		}
+		r.out = append(r.out, r.held[:len(r.held)-pad]...)
+		r.held = nil
+		r.done = true
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,76 @@
+package southpark
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func encryptTestData(t *testing.T, plain, key, iv []byte) []byte {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pad := aes.BlockSize - len(plain)%aes.BlockSize
+	data := append(append([]byte{}, plain...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, data)
+	return data
+}
+
+func TestCBCDecryptReader(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	iv := []byte("fedcba9876543210")
+
+	for _, size := range []int{0, 1, 15, 16, 17, 100000} {
+		plain := make([]byte, size)
+		for i := range plain {
+			plain[i] = byte(i * 7)
+		}
+		enc := encryptTestData(t, plain, key, iv)
+
+		for name, src := range map[string]io.Reader{
+			"full":     bytes.NewReader(enc),
+			"one byte": iotest.OneByteReader(bytes.NewReader(enc)),
+			"half":     iotest.HalfReader(bytes.NewReader(enc)),
+		} {
+			r, err := newCBCDecryptReader(src, key, iv)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := io.ReadAll(r)
+			if err != nil {
+				t.Errorf("size %v, %v reader: %v", size, name, err)
+				continue
+			}
+			if !bytes.Equal(res, plain) {
+				t.Errorf("size %v, %v reader: decrypted data doesn't match", size, name)
+			}
+		}
+	}
+}
+
+func TestCBCDecryptReaderInvalid(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	iv := []byte("fedcba9876543210")
+
+	enc := encryptTestData(t, []byte("hello"), key, iv)
+
+	tests := map[string][]byte{
+		"empty":         {},
+		"partial block": enc[:10],
+		"bad padding":   append(enc[:len(enc):len(enc)], enc[:aes.BlockSize]...),
+	}
+
+	for name, data := range tests {
+		r, err := newCBCDecryptReader(bytes.NewReader(data), key, iv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadAll(r); err == nil {
+			t.Errorf("%v: expected error", name)
+		}
+	}
+}
@@ -3,9 +3,12 @@ package southpark
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path"
+	"strings"
 )
 
 type DownloaderStatus int
@@ -19,6 +22,24 @@ const (
 	DownloaderStatusPostprocessingSubtitles
 )
 
+type OutputFormat int
+
+const (
+	OutputFormatMP4 OutputFormat = iota
+	OutputFormatHLS              // Folder with decrypted segments and local playlists
+)
+
+func (f OutputFormat) String() string {
+	switch f {
+	case OutputFormatMP4:
+		return "MP4"
+	case OutputFormatHLS:
+		return "HLS Folder"
+	default:
+		return "Unknown"
+	}
+}
+
 type Downloader struct {
 	OnStatusChanged func(
 		status DownloaderStatus,
@@ -26,13 +47,16 @@ type Downloader struct {
 		// always related to current status
 		progress float64,
 	)
+	MP4Layout    MP4Layout
+	OutputFormat OutputFormat
 
 	selectFormat       func([]HLSFormat) (HLSFormat, error)
 	ctx                context.Context
 	tmpDirPath         string
-	outputVideoPath    string // Empty to download subs only
+	outputVideoPath    string // Empty to download subs only; a directory for OutputFormatHLS
 	outputSubtitlePath string // Empty to download video only
 	episode            Episode
+	masterURL          string // Used instead of episode if not empty
 }
 
 func NewDownloader(
@@ -54,12 +78,37 @@ func NewDownloader(
 	}
 }
 
+// Downloads from any HLS master playlist URL instead of an episode.
+// See GetHLSStream.
+func NewHLSDownloader(
+	ctx context.Context,
+	masterURL string,
+	tmpDirPath string,
+	outputVideoPath string, // Empty to download subs only
+	selectVideoFormat func([]HLSFormat) (HLSFormat, error),
+	outputSubtitlePath string, // Empty to download video only
+) *Downloader {
+	res := NewDownloader(ctx, Episode{}, tmpDirPath, outputVideoPath, selectVideoFormat, outputSubtitlePath)
+	res.masterURL = masterURL
+	return res
+}
+
 func (d *Downloader) Do() error {
 	d.OnStatusChanged(DownloaderStatusFetchingMetadata, -1)
 
-	stream, err := GetEpisodeStream(d.ctx, d.episode, d.selectFormat)
-	if err != nil {
-		return fmt.Errorf("GetEpisodeStream: %w", err)
+	var stream EpisodeStream
+	if d.masterURL != "" {
+		var err error
+		stream, err = GetHLSStream(d.ctx, d.masterURL, d.selectFormat)
+		if err != nil {
+			return fmt.Errorf("GetHLSStream: %w", err)
+		}
+	} else {
+		var err error
+		stream, err = GetEpisodeStream(d.ctx, d.episode, d.selectFormat)
+		if err != nil {
+			return fmt.Errorf("GetEpisodeStream: %w", err)
+		}
 	}
 
 	getSegFileName := func(n int) string {
@@ -98,26 +147,37 @@ func (d *Downloader) Do() error {
 		}
 
 		currentTotalSegment := startTotalSegment
+		writeSegFile := func(r io.Reader) error {
+			f, err := os.Create(getSegFileName(currentTotalSegment))
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(f, r); err != nil {
+				f.Close()
+				return err
+			}
+			return f.Close()
+		}
 		if err := DownloadEpisodeStream(d.ctx, stream, startTotalSegment,
 			func(segmentIdx int) {
 				currentTotalSegment = segmentIdx
 			},
-			func(frame []byte, relSegIdx int) error {
-				if err := os.WriteFile(getSegFileName(currentTotalSegment), frame, 0644); err != nil {
+			func(r io.Reader, relSegIdx int) error {
+				if err := writeSegFile(r); err != nil {
 					return err
 				}
 				d.OnStatusChanged(DownloaderStatusDownloadingVideo, float64(relSegIdx)/float64(len(stream.Video.Segments)))
 				return nil
 			},
-			func(frame []byte, relSegIdx int) error {
-				if err := os.WriteFile(getSegFileName(currentTotalSegment), frame, 0644); err != nil {
+			func(r io.Reader, relSegIdx int) error {
+				if err := writeSegFile(r); err != nil {
 					return err
 				}
 				d.OnStatusChanged(DownloaderStatusDownloadingAudio, float64(relSegIdx)/float64(len(stream.Audio.Segments)))
 				return nil
 			},
-			func(frame []byte, relSegIdx int) error {
-				if err := os.WriteFile(getSegFileName(currentTotalSegment), frame, 0644); err != nil {
+			func(r io.Reader, relSegIdx int) error {
+				if err := writeSegFile(r); err != nil {
 					return err
 				}
 				d.OnStatusChanged(DownloaderStatusDownloadingSubtitles, float64(relSegIdx)/float64(len(stream.Subs.Segments)))
@@ -126,52 +186,28 @@ func (d *Downloader) Do() error {
 			return fmt.Errorf("GetEpisodeAsTS: %w", err)
 		}
 
-		d.OnStatusChanged(DownloaderStatusPostprocessingVideo, 0)
-
-		outputFileMP4, err := os.Create(d.outputVideoPath)
-		if err != nil {
-			return fmt.Errorf("create output file: %w", err)
+		hlsPath := ""
+		if d.OutputFormat == OutputFormatHLS {
+			hlsPath = d.outputVideoPath
+		} else if c := stream.VideoFormat.AudioCodec(); c == CodecAC3 || c == CodecEAC3 {
+			hlsPath = HLSFallbackPath(d.outputVideoPath)
+		} else if err := d.muxMP4(stream, getSegFileName); errors.Is(err, ErrUnsupportedMP4) {
+			os.Remove(d.outputVideoPath)
+			hlsPath = HLSFallbackPath(d.outputVideoPath)
+		} else if err != nil {
+			return err
 		}
-		defer outputFileMP4.Close()
+		if hlsPath != "" {
+			d.OnStatusChanged(DownloaderStatusPostprocessingVideo, -1)
 
-		var exclRule excludeRule
-		for _, r := range excludeRules {
-			if d.episode.Language == r.Language && d.episode.SeasonNumber == r.Season && d.episode.EpisodeNumber == r.Episode {
-				exclRule = r
-				break
+			// The language of arbitrary HLS URLs is unknown
+			trackName := "Undetermined"
+			if d.masterURL == "" {
+				trackName = d.episode.Language.String()
 			}
-		}
-
-		var tsSegs []SegmentFile
-		for i, seg := range stream.Video.Segments {
-			skip := false
-			if exclRule.Season != 0 {
-				for _, si := range exclRule.SkipVideoSegments {
-					if si == i {
-						skip = true
-						break
-					}
-				}
+			if err := writeHLSArchive(stream, trackName, hlsPath, getSegFileName); err != nil {
+				return fmt.Errorf("write HLS archive: %w", err)
 			}
-			tsSegs = append(tsSegs, SegmentFile{
-				Filename: getSegFileName(i),
-				Duration: seg.Duration,
-				Skip:     skip,
-			})
-		}
-
-		var aacSegs []SegmentFile
-		for i, seg := range stream.Audio.Segments {
-			aacSegs = append(aacSegs, SegmentFile{
-				Filename: getSegFileName(len(stream.Video.Segments) + i),
-				Duration: seg.Duration,
-			})
-		}
-
-		if err := ConvertTSAndAACToMP4(tsSegs, aacSegs, outputFileMP4, func(progress float64) {
-			d.OnStatusChanged(DownloaderStatusPostprocessingVideo, progress)
-		}); err != nil {
-			return fmt.Errorf("convert MPEG-TS and AAC to MP4: %w", err)
 		}
 	}
 
@@ -209,3 +245,110 @@ func (d *Downloader) Do() error {
 
 	return nil
 }
+
+// Returns the directory an HLS folder is written to instead of the MP4
+// file at mp4Path if the streams can't be muxed into MP4, e.g. because
+// of AC-3 audio.
+func HLSFallbackPath(mp4Path string) string {
+	return strings.TrimSuffix(mp4Path, path.Ext(mp4Path))
+}
+
+func (d *Downloader) muxMP4(stream EpisodeStream, getSegFileName func(int) string) error {
+	d.OnStatusChanged(DownloaderStatusPostprocessingVideo, 0)
+
+	var exclRule excludeRule
+	for _, r := range excludeRules {
+		if d.episode.Language == r.Language && d.episode.SeasonNumber == r.Season && d.episode.EpisodeNumber == r.Episode {
+			exclRule = r
+			break
+		}
+	}
+
+	var tsSegs []SegmentFile
+	for i, seg := range stream.Video.Segments {
+		skip := false
+		if exclRule.Season != 0 {
+			for _, si := range exclRule.SkipVideoSegments {
+				if si == i {
+					skip = true
+					break
+				}
+			}
+		}
+		tsSegs = append(tsSegs, SegmentFile{
+			Filename: getSegFileName(i),
+			Duration: seg.Duration,
+			Skip:     skip,
+		})
+	}
+
+	var aacSegs []SegmentFile
+	for i, seg := range stream.Audio.Segments {
+		aacSegs = append(aacSegs, SegmentFile{
+			Filename: getSegFileName(len(stream.Video.Segments) + i),
+			Duration: seg.Duration,
+		})
+	}
+
+	// Fast start requires rewriting the whole file after muxing,
+	// so we mux into a temporary file first
+	muxedPath := d.outputVideoPath
+	if d.MP4Layout == MP4LayoutFastStart {
+		muxedPath = path.Join(d.tmpDirPath, "Muxed.mp4")
+	}
+
+	if err := func() error {
+		muxedFile, err := os.Create(muxedPath)
+		if err != nil {
+			return fmt.Errorf("create output file: %w", err)
+		}
+
+		if err := ConvertTSAndAACToMP4(d.ctx, tsSegs, aacSegs, muxedFile, d.MP4Layout, func(progress float64) {
+			d.OnStatusChanged(DownloaderStatusPostprocessingVideo, progress)
+		}); err != nil {
+			// A partial fragmented file is playable up to the last
+			// complete fragment, so it's kept. Other layouts are
+			// unplayable without moov. The segments are still in the
+			// temporary directory, so muxing can be redone either way.
+			muxedFile.Close()
+			if d.MP4Layout != MP4LayoutFragmented {
+				os.Remove(muxedPath)
+			}
+			return fmt.Errorf("convert MPEG-TS and AAC to MP4: %w", err)
+		}
+		if err := muxedFile.Close(); err != nil {
+			return fmt.Errorf("close output file: %w", err)
+		}
+		return nil
+	}(); err != nil {
+		return err
+	}
+
+	if d.MP4Layout == MP4LayoutFastStart {
+		d.OnStatusChanged(DownloaderStatusPostprocessingVideo, -1)
+
+		if err := func() error {
+			in, err := os.Open(muxedPath)
+			if err != nil {
+				return err
+			}
+			defer in.Close()
+
+			out, err := os.Create(d.outputVideoPath)
+			if err != nil {
+				return fmt.Errorf("create output file: %w", err)
+			}
+
+			if err := MoveMP4MoovToFront(in, out); err != nil {
+				out.Close()
+				os.Remove(d.outputVideoPath)
+				return err
+			}
+			return out.Close()
+		}(); err != nil {
+			return fmt.Errorf("move MP4 metadata to front: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,19 @@
+package southpark
+
+import (
+	"errors"
+
+	"github.com/xypwn/southpark-downloader-ui/pkg/httputils"
+)
+
+// Errors returned by this package can be matched against
+// these using errors.Is.
+var (
+	ErrGeoBlocked        = errors.New("not available from your location")
+	ErrEpisodeLocked     = errors.New("episode is locked or no longer available")
+	ErrRegionUnsupported = errors.New("website region is not supported")
+	ErrSiteLayoutChanged = errors.New("website layout has changed")
+	ErrRateLimited       = httputils.ErrTooManyRequests
+	ErrDecryptFailed     = errors.New("decryption failed")
+	ErrUnsupportedMP4    = errors.New("codec can't be written into MP4")
+)
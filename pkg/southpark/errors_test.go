@@ -0,0 +1,70 @@
+package southpark
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/xypwn/southpark-downloader-ui/pkg/httputils"
+)
+
+func TestErrorSentinels(t *testing.T) {
+	_, err := GetRegionInfoForHost("southpark.example.com", nil)
+	if !errors.Is(err, ErrRegionUnsupported) {
+		t.Errorf("expected ErrRegionUnsupported, got %v", err)
+	}
+
+	err = fmt.Errorf("get episodes: %w", ExtractionErrors{
+		{Strategy: "a", Err: errors.New("network down")},
+		{Strategy: "b", Err: selectorError("X > Y", errSelectorNotFound)},
+	})
+	if !errors.Is(err, ErrSiteLayoutChanged) {
+		t.Errorf("expected ErrSiteLayoutChanged, got %v", err)
+	}
+	if errors.Is(&ExtractionError{Strategy: "a", Err: errors.New("network down")}, ErrSiteLayoutChanged) {
+		t.Errorf("expected error without selector not to be ErrSiteLayoutChanged")
+	}
+
+	for diag, want := range map[string]error{"60101": ErrEpisodeLocked, "60103": ErrEpisodeLocked, "60502": ErrGeoBlocked, "60504": ErrGeoBlocked} {
+		err := fmt.Errorf("get master URL: %w", &VideoServiceError{Diagnostics: diag})
+		if !errors.Is(err, want) {
+			t.Errorf("%v: expected %v, got %v", diag, want, err)
+		}
+	}
+	if errors.Is(&VideoServiceError{Diagnostics: "1"}, ErrEpisodeLocked) {
+		t.Errorf("expected unknown diagnostics code not to match")
+	}
+
+	_, err = newCBCDecryptReader(bytes.NewReader(nil), make([]byte, 16), []byte("short"))
+	if !errors.Is(err, ErrDecryptFailed) {
+		t.Errorf("expected ErrDecryptFailed, got %v", err)
+	}
+	r, err := newCBCDecryptReader(bytes.NewReader([]byte("not a block")), make([]byte, 16), make([]byte, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(r); !errors.Is(err, ErrDecryptFailed) {
+		t.Errorf("expected ErrDecryptFailed, got %v", err)
+	}
+}
+
+func TestRateLimited(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	_, err := httputils.GetBodyWithContext(context.Background(), srv.URL)
+	if !errors.Is(err, ErrRateLimited) {
+		t.Errorf("expected ErrRateLimited, got %v", err)
+	}
+	var statusErr *httputils.StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("expected StatusError with code 429, got %v", err)
+	}
+}
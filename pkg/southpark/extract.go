@@ -0,0 +1,191 @@
+package southpark
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/xypwn/southpark-downloader-ui/pkg/httputils"
+)
+
+var errSelectorNotFound = errors.New("not found")
+
+// Returned when the website didn't contain what we were looking for,
+// which usually means its layout changed.
+type ExtractionError struct {
+	Strategy string // Name of the extraction strategy, e.g. "embedded-json/v1"
+	Selector string // The part of the website that was looked for
+	Err      error
+}
+
+func (e *ExtractionError) Error() string {
+	var b strings.Builder
+	if e.Strategy != "" {
+		b.WriteString(e.Strategy + ": ")
+	}
+	if e.Selector != "" {
+		b.WriteString("'" + e.Selector + "': ")
+	}
+	b.WriteString(e.Err.Error())
+	return b.String()
+}
+
+func (e *ExtractionError) Unwrap() error {
+	return e.Err
+}
+
+// Only errors about a specific selector indicate a layout change,
+// a strategy might also have failed because of e.g. a network error.
+func (e *ExtractionError) Is(target error) bool {
+	return target == ErrSiteLayoutChanged && e.Selector != ""
+}
+
+func selectorError(selector string, err error) *ExtractionError {
+	return &ExtractionError{Selector: selector, Err: err}
+}
+
+// Returned if every strategy failed.
+type ExtractionErrors []*ExtractionError
+
+func (e ExtractionErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, v := range e {
+		msgs[i] = v.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e ExtractionErrors) Unwrap() []error {
+	res := make([]error, len(e))
+	for i, v := range e {
+		res[i] = v
+	}
+	return res
+}
+
+type extractionStrategy[T any] struct {
+	Name    string
+	Extract func(ctx context.Context) (T, error)
+}
+
+// Whether err means the website doesn't look like a strategy expects,
+// in which case the next strategy is worth a try. Other errors, e.g.
+// network errors or rate limiting, would likely hit the next strategy
+// too, or make it return less than the failed one would have.
+func isLayoutError(err error) bool {
+	var statusErr *httputils.StatusError
+	return errors.Is(err, ErrSiteLayoutChanged) ||
+		errors.Is(err, errSelectorNotFound) ||
+		(errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound)
+}
+
+// Returns the result of the first strategy that succeeds. Only moves on
+// to the next strategy if the error is a layout error, otherwise the
+// error is returned right away.
+func extractWithFallbacks[T any](ctx context.Context, strategies ...extractionStrategy[T]) (T, error) {
+	var zero T
+	var errs ExtractionErrors
+	for _, s := range strategies {
+		res, err := s.Extract(ctx)
+		if err == nil {
+			return res, nil
+		}
+		if ctx.Err() != nil {
+			return zero, ctx.Err()
+		}
+		if !isLayoutError(err) {
+			return zero, &ExtractionError{Strategy: s.Name, Err: err}
+		}
+		errs = append(errs, &ExtractionError{Strategy: s.Name, Err: err})
+	}
+	return zero, errs
+}
+
+const websiteDataSelector = "window.__DATA__"
+
+var websiteDataV1Regexp = regexp.MustCompile("window.__DATA__\\s*=\\s*({.*});\n")
+
+// Each parser extracts the website data JSON from an HTML page.
+var websiteDataParsers = []struct {
+	Name  string
+	Parse func(body []byte) ([]byte, error)
+}{
+	{
+		// Object assigned on a single line, terminated by ";\n"
+		Name: "embedded-json/v1",
+		Parse: func(body []byte) ([]byte, error) {
+			match := websiteDataV1Regexp.FindSubmatch(body)
+			if match == nil || len(match) != 2 {
+				return nil, errSelectorNotFound
+			}
+			return match[1], nil
+		},
+	},
+	{
+		// Any formatting, the end of the object is found by the JSON decoder
+		Name: "embedded-json/v2",
+		Parse: func(body []byte) ([]byte, error) {
+			_, after, found := bytes.Cut(body, []byte(websiteDataSelector))
+			if !found {
+				return nil, errSelectorNotFound
+			}
+			start := bytes.IndexByte(after, '{')
+			if start == -1 || len(bytes.TrimSpace(bytes.TrimLeft(bytes.TrimSpace(after[:start]), "="))) != 0 {
+				return nil, errors.New("not followed by an object assignment")
+			}
+			var raw json.RawMessage
+			if err := json.NewDecoder(bytes.NewReader(after[start:])).Decode(&raw); err != nil {
+				return nil, err
+			}
+			return raw, nil
+		},
+	},
+}
+
+func getWebsiteDataFromBody(body []byte) (websiteData, error) {
+	var errs ExtractionErrors
+	for _, p := range websiteDataParsers {
+		dataJSON, err := p.Parse(body)
+		if err == nil {
+			var data websiteData
+			if err = json.Unmarshal(dataJSON, &data); err == nil {
+				return data, nil
+			}
+			err = fmt.Errorf("parse data JSON: %w", err)
+		}
+		errs = append(errs, &ExtractionError{Strategy: p.Name, Selector: websiteDataSelector, Err: err})
+	}
+	return websiteData{}, errs
+}
+
+func getWebsiteDataPropsFromBody(body []byte, containerType string, propsType string) (websiteDataProps, error) {
+	data, err := getWebsiteDataFromBody(body)
+	if err != nil {
+		return websiteDataProps{}, err
+	}
+	return data.getProps(containerType, propsType)
+}
+
+func (d websiteData) getProps(containerType string, propsType string) (websiteDataProps, error) {
+	for _, v := range d.Children {
+		if v.Type == "MainContainer" {
+			for _, v := range v.Children {
+				if v.Type == containerType &&
+					(v.Props.Type == propsType || propsType == "") {
+					return v.Props, nil
+				}
+			}
+		}
+	}
+
+	selector := "MainContainer > " + containerType
+	if propsType != "" {
+		selector += "[type=" + propsType + "]"
+	}
+	return websiteDataProps{}, selectorError(selector, errSelectorNotFound)
+}
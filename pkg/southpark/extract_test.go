@@ -0,0 +1,178 @@
+package southpark
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/xypwn/southpark-downloader-ui/pkg/httputils"
+)
+
+const testWebsiteDataJSON = `{"children":[{"type":"MainContainer","children":[` +
+	`{"type":"LineList","props":{"type":"video-guide",` +
+	`"filters":{"items":[{"label":"All","url":"/api/episodes/1"}],"selectedIndex":0},` +
+	`"items":[{"url":"/episodes/abc/south-park-a-season-1-ep-2","meta":{"subHeader":"Second","seasonMgid":"mgid:season1","itemMgid":"mgid:ep2"}},` +
+	`{"url":"/episodes/def/south-park-b-season-1-ep-1","meta":{"subHeader":"First","seasonMgid":"mgid:season1","itemMgid":"mgid:ep1"}}]}}` +
+	`]}]}`
+
+func TestGetWebsiteDataFromBody(t *testing.T) {
+	bodies := map[string]string{
+		"v1": "<script>window.__DATA__ = " + testWebsiteDataJSON + ";\n</script>",
+		"v2": "<script>\nwindow.__DATA__=\n" + testWebsiteDataJSON + "</script>",
+	}
+	for name, body := range bodies {
+		data, err := getWebsiteDataFromBody([]byte(body))
+		if err != nil {
+			t.Errorf("%v: %v", name, err)
+			continue
+		}
+		props, err := data.getProps("LineList", "video-guide")
+		if err != nil {
+			t.Errorf("%v: %v", name, err)
+			continue
+		}
+		if len(props.Items) != 2 {
+			t.Errorf("%v: expected 2 items, got %v", name, len(props.Items))
+		}
+	}
+}
+
+func TestGetWebsiteDataFromBodyErrors(t *testing.T) {
+	_, err := getWebsiteDataFromBody([]byte("<html></html>"))
+	var errs ExtractionErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected ExtractionErrors, got %v", err)
+	}
+	if len(errs) != len(websiteDataParsers) {
+		t.Errorf("expected one error per parser, got %v", len(errs))
+	}
+	for _, v := range errs {
+		if v.Selector != websiteDataSelector {
+			t.Errorf("expected selector %v, got %v", websiteDataSelector, v.Selector)
+		}
+	}
+
+	data, err := getWebsiteDataFromBody([]byte("window.__DATA__ = " + testWebsiteDataJSON + ";\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = data.getProps("SeasonSelector", "")
+	var extrErr *ExtractionError
+	if !errors.As(err, &extrErr) || extrErr.Selector != "MainContainer > SeasonSelector" {
+		t.Errorf("expected error naming selector, got %v", err)
+	}
+	_, err = data.getProps("LineList", "other")
+	if err == nil || !strings.Contains(err.Error(), "MainContainer > LineList[type=other]") {
+		t.Errorf("expected error naming selector, got %v", err)
+	}
+}
+
+func TestExtractWithFallbacks(t *testing.T) {
+	ctx := context.Background()
+	var called []string
+	strategy := func(name string, err error) extractionStrategy[string] {
+		return extractionStrategy[string]{
+			Name: name,
+			Extract: func(context.Context) (string, error) {
+				called = append(called, name)
+				return name, err
+			},
+		}
+	}
+
+	res, err := extractWithFallbacks(ctx,
+		strategy("a", selectorError("X > Y", errSelectorNotFound)),
+		strategy("b", nil),
+		strategy("c", nil),
+	)
+	if err != nil || res != "b" {
+		t.Errorf("expected 'b', got '%v' (%v)", res, err)
+	}
+	if strings.Join(called, ",") != "a,b" {
+		t.Errorf("unexpected strategies called: %v", called)
+	}
+
+	_, err = extractWithFallbacks(ctx,
+		strategy("a", selectorError("X > Y", errSelectorNotFound)),
+		strategy("b", selectorError("Z", errors.New("index out of bounds"))),
+	)
+	var errs ExtractionErrors
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Fatalf("expected 2 extraction errors, got %v", err)
+	}
+	if !errors.Is(err, errSelectorNotFound) {
+		t.Errorf("expected errors.Is to find errSelectorNotFound")
+	}
+	if msg := err.Error(); msg != "a: 'X > Y': not found; b: 'Z': index out of bounds" {
+		t.Errorf("unexpected error message: %v", msg)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	called = nil
+	_, err = extractWithFallbacks(cancelled, strategy("a", errors.New("fail")), strategy("b", nil))
+	if !errors.Is(err, context.Canceled) || len(called) != 1 {
+		t.Errorf("expected to stop after cancellation, got %v (called %v)", err, called)
+	}
+
+	called = nil
+	_, err = extractWithFallbacks(ctx,
+		strategy("a", &httputils.StatusError{StatusCode: http.StatusTooManyRequests, Status: "429 Too Many Requests"}),
+		strategy("b", nil),
+	)
+	if !errors.Is(err, ErrRateLimited) || len(called) != 1 {
+		t.Errorf("expected to stop after rate limit error, got %v (called %v)", err, called)
+	}
+	if errors.Is(err, ErrSiteLayoutChanged) {
+		t.Errorf("expected rate limit error not to be ErrSiteLayoutChanged")
+	}
+}
+
+func TestGetEpisodesFallback(t *testing.T) {
+	srv := newTestFileServer(map[string]string{
+		// The 'Show More' API is missing, so the embedded list must be used
+		"/seasons/south-park/x/season-1": "window.__DATA__ = " + testWebsiteDataJSON + ";\n",
+	})
+	defer srv.Close()
+
+	eps, seasonMGID, err := GetEpisodes(context.Background(), Season{
+		SeasonNumber: 1,
+		URL:          srv.URL + "/seasons/south-park/x/season-1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seasonMGID != "mgid:season1" {
+		t.Errorf("unexpected season MGID: %v", seasonMGID)
+	}
+	if len(eps) != 2 || eps[0].Title != "First" || eps[1].MGID != "mgid:ep2" {
+		t.Errorf("unexpected episodes: %+v", eps)
+	}
+	if eps[0].URL != srv.URL+"/episodes/def/south-park-b-season-1-ep-1" {
+		t.Errorf("unexpected episode URL: %v", eps[0].URL)
+	}
+}
+
+func TestGetEpisodesRateLimited(t *testing.T) {
+	// Falling back to the embedded list would silently drop the episodes
+	// behind 'Show More'
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/episodes/1" {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte("window.__DATA__ = " + testWebsiteDataJSON + ";\n"))
+	}))
+	defer srv.Close()
+
+	_, _, err := GetEpisodes(context.Background(), Season{
+		SeasonNumber: 1,
+		URL:          srv.URL + "/seasons/south-park/x/season-1",
+	})
+	if !errors.Is(err, ErrRateLimited) {
+		t.Errorf("expected ErrRateLimited, got %v", err)
+	}
+}
@@ -0,0 +1,211 @@
+package southpark
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+type MP4Layout int
+
+const (
+	MP4LayoutStandard   MP4Layout = iota // moov at the end of the file
+	MP4LayoutFastStart                   // moov at the front of the file
+	MP4LayoutFragmented                  // moov at the front, followed by moof/mdat pairs
+)
+
+func (l MP4Layout) String() string {
+	switch l {
+	case MP4LayoutStandard:
+		return "Standard"
+	case MP4LayoutFastStart:
+		return "Fast Start"
+	case MP4LayoutFragmented:
+		return "Fragmented"
+	default:
+		return "Unknown"
+	}
+}
+
+type mp4Box struct {
+	Type       string
+	Offset     int64 // Offset of the box header
+	HeaderSize int64
+	Size       int64 // Including header
+}
+
+func readMP4BoxHeader(r io.ReadSeeker, offset int64, end int64) (mp4Box, error) {
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return mp4Box{}, err
+	}
+	var hdr [16]byte
+	if _, err := io.ReadFull(r, hdr[:8]); err != nil {
+		return mp4Box{}, err
+	}
+	box := mp4Box{
+		Type:       string(hdr[4:8]),
+		Offset:     offset,
+		HeaderSize: 8,
+		Size:       int64(binary.BigEndian.Uint32(hdr[:4])),
+	}
+	switch box.Size {
+	case 0:
+		// Box extends to the end of the file
+		box.Size = end - offset
+	case 1:
+		if _, err := io.ReadFull(r, hdr[8:16]); err != nil {
+			return mp4Box{}, err
+		}
+		box.HeaderSize = 16
+		box.Size = int64(binary.BigEndian.Uint64(hdr[8:16]))
+	}
+	if box.Size < box.HeaderSize || offset+box.Size > end {
+		return mp4Box{}, fmt.Errorf("invalid size of MP4 box '%v' at offset %v", box.Type, offset)
+	}
+	return box, nil
+}
+
+// Adds shift to every chunk offset inside the given moov box data.
+func shiftMP4ChunkOffsets(data []byte, shift int64) error {
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return errors.New("truncated MP4 box header")
+		}
+		size := int64(binary.BigEndian.Uint32(data[:4]))
+		typ := string(data[4:8])
+		hdrSize := int64(8)
+		if size == 1 {
+			if len(data) < 16 {
+				return errors.New("truncated MP4 box header")
+			}
+			size = int64(binary.BigEndian.Uint64(data[8:16]))
+			hdrSize = 16
+		}
+		if size < hdrSize || size > int64(len(data)) {
+			return fmt.Errorf("invalid size of MP4 box '%v'", typ)
+		}
+		body := data[hdrSize:size]
+
+		switch typ {
+		case "moov", "trak", "mdia", "minf", "stbl":
+			if err := shiftMP4ChunkOffsets(body, shift); err != nil {
+				return err
+			}
+		case "stco", "co64":
+			entSize := 4
+			if typ == "co64" {
+				entSize = 8
+			}
+			if len(body) < 8 {
+				return fmt.Errorf("truncated '%v' box", typ)
+			}
+			n := int(binary.BigEndian.Uint32(body[4:8]))
+			ents := body[8:]
+			if len(ents) < n*entSize {
+				return fmt.Errorf("truncated '%v' box", typ)
+			}
+			for i := 0; i < n; i++ {
+				e := ents[i*entSize:]
+				if typ == "stco" {
+					v := int64(binary.BigEndian.Uint32(e)) + shift
+					if v > math.MaxUint32 {
+						return errors.New("chunk offset too large for 'stco' box")
+					}
+					binary.BigEndian.PutUint32(e, uint32(v))
+				} else {
+					binary.BigEndian.PutUint64(e, binary.BigEndian.Uint64(e)+uint64(shift))
+				}
+			}
+		}
+
+		data = data[size:]
+	}
+	return nil
+}
+
+// Rewrites a regular MP4 file so that the moov box comes before
+// the media data, allowing playback to start before the whole file
+// has been transferred.
+func MoveMP4MoovToFront(in io.ReadSeeker, out io.Writer) error {
+	end, err := in.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	var boxes []mp4Box
+	moovIdx := -1
+	firstMdatIdx := -1
+	for offset := int64(0); offset < end; {
+		box, err := readMP4BoxHeader(in, offset, end)
+		if err != nil {
+			return err
+		}
+		if box.Type == "moov" {
+			moovIdx = len(boxes)
+		} else if box.Type == "mdat" && firstMdatIdx == -1 {
+			firstMdatIdx = len(boxes)
+		}
+		boxes = append(boxes, box)
+		offset += box.Size
+	}
+	if moovIdx == -1 {
+		return errors.New("no 'moov' box found in MP4 file")
+	}
+
+	moovBox := boxes[moovIdx]
+	moov := make([]byte, moovBox.Size)
+	if _, err := in.Seek(moovBox.Offset, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(in, moov); err != nil {
+		return err
+	}
+
+	copyBox := func(box mp4Box) error {
+		if _, err := in.Seek(box.Offset, io.SeekStart); err != nil {
+			return err
+		}
+		_, err := io.CopyN(out, in, box.Size)
+		return err
+	}
+
+	if firstMdatIdx == -1 || moovIdx < firstMdatIdx {
+		// Already fast-start
+		for _, box := range boxes {
+			if err := copyBox(box); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, box := range boxes[moovIdx+1:] {
+		if box.Type == "mdat" {
+			return errors.New("unsupported MP4 layout: media data after 'moov' box")
+		}
+	}
+
+	// Everything before the first mdat (ftyp, free etc.) stays in
+	// front, so the media data only moves by the size of moov
+	if err := shiftMP4ChunkOffsets(moov, moovBox.Size); err != nil {
+		return fmt.Errorf("update chunk offsets: %w", err)
+	}
+
+	for i, box := range boxes {
+		if i == firstMdatIdx {
+			if _, err := out.Write(moov); err != nil {
+				return err
+			}
+		}
+		if i == moovIdx {
+			continue
+		}
+		if err := copyBox(box); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
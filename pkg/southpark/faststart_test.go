@@ -0,0 +1,86 @@
+package southpark
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func makeTestMP4Box(typ string, body ...[]byte) []byte {
+	var b []byte
+	for _, v := range body {
+		b = append(b, v...)
+	}
+	res := make([]byte, 8, 8+len(b))
+	binary.BigEndian.PutUint32(res, uint32(8+len(b)))
+	copy(res[4:], typ)
+	return append(res, b...)
+}
+
+func TestMoveMP4MoovToFront(t *testing.T) {
+	ftyp := makeTestMP4Box("ftyp", []byte("isom\x00\x00\x02\x00"))
+	mdat := makeTestMP4Box("mdat", []byte("SAMPLE1SAMPLE2"))
+	mdatOffset := uint32(len(ftyp))
+
+	stcoBody := make([]byte, 16)
+	binary.BigEndian.PutUint32(stcoBody[4:], 2)
+	binary.BigEndian.PutUint32(stcoBody[8:], mdatOffset+8)
+	binary.BigEndian.PutUint32(stcoBody[12:], mdatOffset+8+7)
+	moov := makeTestMP4Box("moov",
+		makeTestMP4Box("mvhd", make([]byte, 4)),
+		makeTestMP4Box("trak",
+			makeTestMP4Box("mdia",
+				makeTestMP4Box("minf",
+					makeTestMP4Box("stbl",
+						makeTestMP4Box("stco", stcoBody),
+					),
+				),
+			),
+		),
+	)
+
+	in := bytes.NewReader(append(append(append([]byte{}, ftyp...), mdat...), moov...))
+	var out bytes.Buffer
+	if err := MoveMP4MoovToFront(in, &out); err != nil {
+		t.Fatalf("MoveMP4MoovToFront: %v", err)
+	}
+
+	res := out.Bytes()
+	if len(res) != len(ftyp)+len(mdat)+len(moov) {
+		t.Fatalf("expected output length %v, got %v", len(ftyp)+len(mdat)+len(moov), len(res))
+	}
+	if !bytes.Equal(res[:len(ftyp)], ftyp) {
+		t.Errorf("expected ftyp to stay in front")
+	}
+	if typ := string(res[len(ftyp)+4 : len(ftyp)+8]); typ != "moov" {
+		t.Fatalf("expected moov after ftyp, got '%v'", typ)
+	}
+	newMdatOffset := len(ftyp) + len(moov)
+	if !bytes.Equal(res[newMdatOffset:], mdat) {
+		t.Errorf("expected mdat at the end")
+	}
+
+	// Chunk offsets must point to the same samples as before
+	stco := res[len(res)-len(mdat)-8:]
+	for i, want := range []string{"SAMPLE1", "SAMPLE2"} {
+		off := binary.BigEndian.Uint32(stco[i*4:])
+		if got := string(res[off : off+7]); got != want {
+			t.Errorf("chunk %v: expected '%v', got '%v'", i, want, got)
+		}
+	}
+}
+
+func TestMoveMP4MoovToFrontAlreadyFastStart(t *testing.T) {
+	data := append(
+		makeTestMP4Box("moov", makeTestMP4Box("mvhd", make([]byte, 4))),
+		makeTestMP4Box("mdat", []byte("DATA"))...,
+	)
+
+	var out bytes.Buffer
+	if err := MoveMP4MoovToFront(bytes.NewReader(data), &out); err != nil {
+		t.Fatalf("MoveMP4MoovToFront: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Errorf("expected file to be left unchanged")
+	}
+}
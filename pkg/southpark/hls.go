@@ -0,0 +1,149 @@
+package southpark
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"strings"
+)
+
+func writeHLSMediaPlaylist(filePath string, segments []HLSStreamSegment, segmentURI func(i int) string) error {
+	var targetDuration float64
+	for _, v := range segments {
+		targetDuration = math.Max(targetDuration, v.Duration)
+	}
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-VERSION:3\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%v\n", int(math.Ceil(targetDuration)))
+	b.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n")
+	b.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n")
+	for i, v := range segments {
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n", v.Duration)
+		b.WriteString(segmentURI(i) + "\n")
+	}
+	b.WriteString("#EXT-X-ENDLIST\n")
+
+	return os.WriteFile(filePath, []byte(b.String()), 0644)
+}
+
+// trackName is the name of the audio and subtitle tracks, e.g. "German".
+func writeHLSMasterPlaylist(filePath string, stream EpisodeStream, trackName string) error {
+	hasAudio := len(stream.Audio.Segments) > 0 // Audio may be muxed into the video
+	hasSubs := len(stream.Subs.Segments) > 0
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-VERSION:3\n")
+	if hasAudio {
+		fmt.Fprintf(&b, "#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"audio\",NAME=\"%v\",DEFAULT=YES,AUTOSELECT=YES,URI=\"audio.m3u8\"\n", trackName)
+	}
+	if hasSubs {
+		fmt.Fprintf(&b, "#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID=\"subs\",NAME=\"%v\",DEFAULT=NO,AUTOSELECT=YES,URI=\"subs.m3u8\"\n", trackName)
+	}
+
+	f := stream.VideoFormat
+	attrs := []string{fmt.Sprintf("BANDWIDTH=%v", f.Bandwidth)}
+	if f.AverageBandwidth != 0 {
+		attrs = append(attrs, fmt.Sprintf("AVERAGE-BANDWIDTH=%v", f.AverageBandwidth))
+	}
+	if f.Codecs != "" {
+		attrs = append(attrs, fmt.Sprintf("CODECS=\"%v\"", f.Codecs))
+	}
+	if f.Width != 0 && f.Height != 0 {
+		attrs = append(attrs, fmt.Sprintf("RESOLUTION=%vx%v", f.Width, f.Height))
+	}
+	if f.FrameRate != 0 {
+		attrs = append(attrs, fmt.Sprintf("FRAME-RATE=%.3f", f.FrameRate))
+	}
+	if hasAudio {
+		attrs = append(attrs, "AUDIO=\"audio\"")
+	}
+	if hasSubs {
+		attrs = append(attrs, "SUBTITLES=\"subs\"")
+	}
+	fmt.Fprintf(&b, "#EXT-X-STREAM-INF:%v\n", strings.Join(attrs, ","))
+	b.WriteString("video.m3u8\n")
+
+	return os.WriteFile(filePath, []byte(b.String()), 0644)
+}
+
+func copyFile(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Moves the downloaded (already decrypted) segments into outputDir and writes
+// local playlists, so the result can be played by opening master.m3u8.
+// Subtitle segments are copied instead of moved, since they're still needed
+// to create the standalone VTT file.
+func writeHLSArchive(stream EpisodeStream, trackName string, outputDir string, getSegFileName func(int) string) error {
+	type mediaType struct {
+		Name     string
+		Ext      string
+		Segments []HLSStreamSegment
+		Copy     bool
+	}
+	mediaTypes := []mediaType{
+		{"video", "ts", stream.Video.Segments, false},
+		{"audio", "aac", stream.Audio.Segments, false},
+		{"subs", "vtt", stream.Subs.Segments, true},
+	}
+
+	segOffset := 0
+	for _, mt := range mediaTypes {
+		if len(mt.Segments) == 0 {
+			continue
+		}
+
+		if err := os.MkdirAll(path.Join(outputDir, mt.Name), os.ModePerm); err != nil {
+			return fmt.Errorf("create %v directory: %w", mt.Name, err)
+		}
+
+		segmentURI := func(i int) string {
+			return fmt.Sprintf("%v/%04v.%v", mt.Name, i, mt.Ext)
+		}
+
+		for i := range mt.Segments {
+			src := getSegFileName(segOffset + i)
+			dst := path.Join(outputDir, segmentURI(i))
+			if mt.Copy {
+				if err := copyFile(dst, src); err != nil {
+					return fmt.Errorf("copy %v segment: %w", mt.Name, err)
+				}
+			} else {
+				if err := os.Rename(src, dst); err != nil {
+					return fmt.Errorf("move %v segment: %w", mt.Name, err)
+				}
+			}
+		}
+
+		if err := writeHLSMediaPlaylist(path.Join(outputDir, mt.Name+".m3u8"), mt.Segments, segmentURI); err != nil {
+			return fmt.Errorf("write %v playlist: %w", mt.Name, err)
+		}
+
+		segOffset += len(mt.Segments)
+	}
+
+	if err := writeHLSMasterPlaylist(path.Join(outputDir, "master.m3u8"), stream, trackName); err != nil {
+		return fmt.Errorf("write master playlist: %w", err)
+	}
+
+	return nil
+}
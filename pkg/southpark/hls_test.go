@@ -0,0 +1,168 @@
+package southpark
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestWriteHLSArchive(t *testing.T) {
+	segments := func(durations ...float64) []HLSStreamSegment {
+		res := make([]HLSStreamSegment, len(durations))
+		for i, v := range durations {
+			res[i] = HLSStreamSegment{Duration: v, URL: fmt.Sprintf("https://cdn.example/seg%v", i)}
+		}
+		return res
+	}
+	format := HLSFormat{
+		Bandwidth:        2000000,
+		AverageBandwidth: 1500000,
+		Codecs:           "avc1.64001f,mp4a.40.2",
+		Width:            1280,
+		Height:           720,
+		FrameRate:        25,
+	}
+
+	tests := []struct {
+		Name   string
+		Stream EpisodeStream
+		Files  map[string]string // Relative to the output directory
+		Kept   []string          // Segment files left in the temporary directory
+	}{
+		{
+			Name: "separate audio and subtitles",
+			Stream: EpisodeStream{
+				VideoFormat: format,
+				Video:       HLSStream{Segments: segments(6.006, 4.5)},
+				Audio:       HLSStream{Segments: segments(6, 4.5)},
+				Subs:        HLSStream{Segments: segments(10.5)},
+			},
+			Files: map[string]string{
+				"master.m3u8": "#EXTM3U\n" +
+					"#EXT-X-VERSION:3\n" +
+					"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"audio\",NAME=\"German\",DEFAULT=YES,AUTOSELECT=YES,URI=\"audio.m3u8\"\n" +
+					"#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID=\"subs\",NAME=\"German\",DEFAULT=NO,AUTOSELECT=YES,URI=\"subs.m3u8\"\n" +
+					"#EXT-X-STREAM-INF:BANDWIDTH=2000000,AVERAGE-BANDWIDTH=1500000,CODECS=\"avc1.64001f,mp4a.40.2\",RESOLUTION=1280x720,FRAME-RATE=25.000,AUDIO=\"audio\",SUBTITLES=\"subs\"\n" +
+					"video.m3u8\n",
+				"video.m3u8": "#EXTM3U\n" +
+					"#EXT-X-VERSION:3\n" +
+					"#EXT-X-TARGETDURATION:7\n" +
+					"#EXT-X-MEDIA-SEQUENCE:0\n" +
+					"#EXT-X-PLAYLIST-TYPE:VOD\n" +
+					"#EXTINF:6.006,\n" +
+					"video/0000.ts\n" +
+					"#EXTINF:4.500,\n" +
+					"video/0001.ts\n" +
+					"#EXT-X-ENDLIST\n",
+				"audio.m3u8": "#EXTM3U\n" +
+					"#EXT-X-VERSION:3\n" +
+					"#EXT-X-TARGETDURATION:6\n" +
+					"#EXT-X-MEDIA-SEQUENCE:0\n" +
+					"#EXT-X-PLAYLIST-TYPE:VOD\n" +
+					"#EXTINF:6.000,\n" +
+					"audio/0000.aac\n" +
+					"#EXTINF:4.500,\n" +
+					"audio/0001.aac\n" +
+					"#EXT-X-ENDLIST\n",
+				"subs.m3u8": "#EXTM3U\n" +
+					"#EXT-X-VERSION:3\n" +
+					"#EXT-X-TARGETDURATION:11\n" +
+					"#EXT-X-MEDIA-SEQUENCE:0\n" +
+					"#EXT-X-PLAYLIST-TYPE:VOD\n" +
+					"#EXTINF:10.500,\n" +
+					"subs/0000.vtt\n" +
+					"#EXT-X-ENDLIST\n",
+				"video/0000.ts":  "segment 0",
+				"video/0001.ts":  "segment 1",
+				"audio/0000.aac": "segment 2",
+				"audio/0001.aac": "segment 3",
+				"subs/0000.vtt":  "segment 4",
+			},
+			// Still needed for the VTT file
+			Kept: []string{"4.seg"},
+		},
+		{
+			Name: "audio muxed into video",
+			Stream: EpisodeStream{
+				VideoFormat: HLSFormat{Bandwidth: 800000},
+				Video:       HLSStream{Segments: segments(10)},
+			},
+			Files: map[string]string{
+				"master.m3u8": "#EXTM3U\n" +
+					"#EXT-X-VERSION:3\n" +
+					"#EXT-X-STREAM-INF:BANDWIDTH=800000\n" +
+					"video.m3u8\n",
+				"video.m3u8": "#EXTM3U\n" +
+					"#EXT-X-VERSION:3\n" +
+					"#EXT-X-TARGETDURATION:10\n" +
+					"#EXT-X-MEDIA-SEQUENCE:0\n" +
+					"#EXT-X-PLAYLIST-TYPE:VOD\n" +
+					"#EXTINF:10.000,\n" +
+					"video/0000.ts\n" +
+					"#EXT-X-ENDLIST\n",
+				"video/0000.ts": "segment 0",
+			},
+		},
+	}
+	for _, tt := range tests {
+		tmpDir := t.TempDir()
+		outputDir := path.Join(t.TempDir(), "S01E01")
+		getSegFileName := func(i int) string {
+			return path.Join(tmpDir, fmt.Sprintf("%v.seg", i))
+		}
+		nSegs := len(tt.Stream.Video.Segments) + len(tt.Stream.Audio.Segments) + len(tt.Stream.Subs.Segments)
+		for i := 0; i < nSegs; i++ {
+			if err := os.WriteFile(getSegFileName(i), []byte(fmt.Sprint("segment ", i)), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := writeHLSArchive(tt.Stream, "German", outputDir, getSegFileName); err != nil {
+			t.Errorf("%v: %v", tt.Name, err)
+			continue
+		}
+
+		// Only the expected files are present
+		var want, got []string
+		for k := range tt.Files {
+			want = append(want, k)
+		}
+		filepath.WalkDir(outputDir, func(p string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() {
+				rel, _ := filepath.Rel(outputDir, p)
+				got = append(got, filepath.ToSlash(rel))
+			}
+			return err
+		})
+		sort.Strings(want)
+		sort.Strings(got)
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%v: expected files %v, got %v", tt.Name, want, got)
+		}
+		for name, content := range tt.Files {
+			data, err := os.ReadFile(path.Join(outputDir, name))
+			if err != nil {
+				t.Errorf("%v: %v", tt.Name, err)
+			} else if string(data) != content {
+				t.Errorf("%v: %v: expected\n%v\ngot\n%v", tt.Name, name, content, string(data))
+			}
+		}
+
+		// Video and audio segments are moved, not copied
+		var kept []string
+		entries, err := os.ReadDir(tmpDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range entries {
+			kept = append(kept, v.Name())
+		}
+		if fmt.Sprint(kept) != fmt.Sprint(tt.Kept) {
+			t.Errorf("%v: expected %v to be left, got %v", tt.Name, tt.Kept, kept)
+		}
+	}
+}
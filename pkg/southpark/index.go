@@ -0,0 +1,216 @@
+package southpark
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Offline full-text index over episode metadata, e.g. from a cache.
+type SearchIndex struct {
+	entries []searchIndexEntry
+}
+
+type searchIndexEntry struct {
+	Episode     EpisodeMetadata
+	Title       []string
+	Description []string
+}
+
+func NewSearchIndex(episodes []EpisodeMetadata) *SearchIndex {
+	res := &SearchIndex{
+		entries: make([]searchIndexEntry, len(episodes)),
+	}
+	for i, v := range episodes {
+		res.entries[i] = searchIndexEntry{
+			Episode:     v,
+			Title:       searchTokens(v.Title),
+			Description: searchTokens(v.Description),
+		}
+	}
+	return res
+}
+
+var searchFoldReplacer = strings.NewReplacer(
+	"ä", "a", "á", "a", "à", "a", "â", "a", "ã", "a", "å", "a", "æ", "ae",
+	"ç", "c",
+	"é", "e", "è", "e", "ê", "e", "ë", "e",
+	"í", "i", "ì", "i", "î", "i", "ï", "i",
+	"ñ", "n",
+	"ö", "o", "ó", "o", "ò", "o", "ô", "o", "õ", "o", "ø", "o",
+	"ü", "u", "ú", "u", "ù", "u", "û", "u",
+	"ß", "ss",
+)
+
+// Lower case words with common diacritics removed.
+func searchTokens(s string) []string {
+	s = searchFoldReplacer.Replace(strings.ToLower(s))
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// Matches e.g. "S05E04", "s5e4", "5x04" and "S05".
+var searchEpisodeRegexp = regexp.MustCompile(`^(?i:s(\d+)(?:e(\d+))?|(\d+)x(\d+))$`)
+
+// Returns ok if query refers to a season or episode by number.
+// episode is 0 if only a season was given.
+func parseEpisodeQuery(query string) (season, episode int, ok bool) {
+	m := searchEpisodeRegexp.FindStringSubmatch(strings.TrimSpace(query))
+	if m == nil {
+		return 0, 0, false
+	}
+	if m[1] != "" {
+		season, _ = strconv.Atoi(m[1])
+		if m[2] != "" {
+			episode, _ = strconv.Atoi(m[2])
+		}
+	} else {
+		season, _ = strconv.Atoi(m[3])
+		episode, _ = strconv.Atoi(m[4])
+	}
+	return season, episode, true
+}
+
+// Scores how well a query word matches a word: exact matches score
+// highest, then prefixes, then substrings and typos. 0 means no match.
+func matchWord(query, word string) int {
+	switch {
+	case word == query:
+		return 4
+	case strings.HasPrefix(word, query):
+		return 3
+	case len(query) >= 3 && strings.Contains(word, query):
+		return 2
+	}
+	// Allow one typo in short and two in long words
+	maxDist := 0
+	if len(query) >= 4 {
+		maxDist = 1
+	}
+	if len(query) >= 8 {
+		maxDist = 2
+	}
+	if maxDist > 0 && editDistance(query, word, maxDist) <= maxDist {
+		return 1
+	}
+	return 0
+}
+
+func bestMatch(query string, words []string) int {
+	best := 0
+	for _, w := range words {
+		if s := matchWord(query, w); s > best {
+			best = s
+		}
+	}
+	return best
+}
+
+// Levenshtein distance, gives up early and returns max+1
+// if the distance is greater than max.
+func editDistance(a, b string, max int) int {
+	ar, br := []rune(a), []rune(b)
+	if d := len(ar) - len(br); d > max || -d > max {
+		return max + 1
+	}
+	prev := make([]int, len(br)+1)
+	curr := make([]int, len(br)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ar); i++ {
+		curr[0] = i
+		rowMin := curr[0]
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			curr[j] = prev[j-1] + cost
+			if v := prev[j] + 1; v < curr[j] {
+				curr[j] = v
+			}
+			if v := curr[j-1] + 1; v < curr[j] {
+				curr[j] = v
+			}
+			if curr[j] < rowMin {
+				rowMin = curr[j]
+			}
+		}
+		if rowMin > max {
+			return max + 1
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(br)]
+}
+
+// Returns the episodes matching query, best matches first. Every word of
+// the query has to match the title or description, title matches rank
+// higher. Queries like "S05E04" or "S05" look up episodes by number.
+// filter may be nil.
+func (idx *SearchIndex) Search(query string, filter func(EpisodeMetadata) bool) []EpisodeMetadata {
+	type result struct {
+		Episode EpisodeMetadata
+		Score   int
+	}
+	var results []result
+
+	if season, episode, ok := parseEpisodeQuery(query); ok {
+		for _, v := range idx.entries {
+			if v.Episode.SeasonNumber == season &&
+				(episode == 0 || v.Episode.EpisodeNumber == episode) &&
+				(filter == nil || filter(v.Episode)) {
+				results = append(results, result{Episode: v.Episode})
+			}
+		}
+	} else {
+		words := searchTokens(query)
+		if len(words) == 0 {
+			return nil
+		}
+		for _, v := range idx.entries {
+			if filter != nil && !filter(v.Episode) {
+				continue
+			}
+			score := 0
+			for _, w := range words {
+				s := 3 * bestMatch(w, v.Title)
+				if s == 0 {
+					s = bestMatch(w, v.Description)
+				}
+				if s == 0 {
+					score = 0
+					break
+				}
+				score += s
+			}
+			if score > 0 {
+				results = append(results, result{Episode: v.Episode, Score: score})
+			}
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.Episode.SeasonNumber != b.Episode.SeasonNumber {
+			return a.Episode.SeasonNumber < b.Episode.SeasonNumber
+		}
+		if a.Episode.EpisodeNumber != b.Episode.EpisodeNumber {
+			return a.Episode.EpisodeNumber < b.Episode.EpisodeNumber
+		}
+		return a.Episode.Language < b.Episode.Language
+	})
+
+	res := make([]EpisodeMetadata, len(results))
+	for i, v := range results {
+		res[i] = v.Episode
+	}
+	return res
+}
@@ -0,0 +1,78 @@
+package southpark
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSearchIndex(t *testing.T) {
+	ep := func(season, episode int, lang Language, title, desc string) EpisodeMetadata {
+		return EpisodeMetadata{
+			SeasonNumber:  season,
+			EpisodeNumber: episode,
+			Language:      lang,
+			Title:         title,
+			Description:   desc,
+		}
+	}
+	idx := NewSearchIndex([]EpisodeMetadata{
+		ep(1, 1, LanguageEnglish, "Cartman Gets an Anal Probe", "Cartman is abducted by aliens."),
+		ep(1, 1, LanguageGerman, "Cartman und die Analsonde", "Cartman wird von Außerirdischen entführt."),
+		ep(5, 4, LanguageEnglish, "Scott Tenorman Must Die", "Cartman gets revenge."),
+		ep(8, 10, LanguageEnglish, "Pre-School", "The boys face an old enemy."),
+		ep(15, 12, LanguageEnglish, "1%", "Cartman's stuffed animals go missing."),
+	})
+	english := func(e EpisodeMetadata) bool { return e.Language == LanguageEnglish }
+
+	tests := []struct {
+		Query  string
+		Filter func(EpisodeMetadata) bool
+		Want   string
+	}{
+		// Title matches rank above description matches
+		{"cartman", english, "[S1E1 S5E4 S15E12]"},
+		{"cartman", nil, "[S1E1 S1E1 S5E4 S15E12]"},
+		{"S05E04", nil, "[S5E4]"},
+		{"s5e4", nil, "[S5E4]"},
+		{"5x04", nil, "[S5E4]"},
+		{"S01", english, "[S1E1]"},
+		// Typo
+		{"tenorma", nil, "[S5E4]"},
+		{"tennorman", nil, "[S5E4]"},
+		{"cartman revenge", nil, "[S5E4]"},
+		{"pre school", nil, "[S8E10]"},
+		{"ausserirdischen", nil, "[S1E1]"},
+		{"außerirdischen", nil, "[S1E1]"},
+		{"xyz", nil, "[]"},
+		{"", nil, "[]"},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, v := range idx.Search(tt.Query, tt.Filter) {
+			got = append(got, fmt.Sprintf("S%vE%v", v.SeasonNumber, v.EpisodeNumber))
+		}
+		if s := fmt.Sprint(got); s != tt.Want {
+			t.Errorf("%q: expected %v, got %v", tt.Query, tt.Want, s)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		A, B string
+		Want int
+	}{
+		{"kitten", "sitting", 3},
+		{"cartman", "cartman", 0},
+		{"cartman", "catman", 1},
+		{"", "abc", 3},
+	}
+	for _, tt := range tests {
+		if got := editDistance(tt.A, tt.B, 10); got != tt.Want {
+			t.Errorf("%v, %v: expected %v, got %v", tt.A, tt.B, tt.Want, got)
+		}
+	}
+	if got := editDistance("kitten", "sitting", 1); got != 2 {
+		t.Errorf("expected early exit with 2, got %v", got)
+	}
+}
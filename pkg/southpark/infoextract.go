@@ -5,12 +5,11 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"net/http"
 	"net/url"
-	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 
 	"github.com/xypwn/southpark-downloader-ui/pkg/httputils"
 )
@@ -46,170 +45,24 @@ func getSeasonAndEpisodeNumberFromURL(episodeURL string) (int, int, error) {
 	return season, episode, nil
 }
 
-type Language int
-
-const (
-	LanguageEnglish Language = iota
-	LanguageGerman
-	LanguageSpanish
-	LanguageBrazilianPortuguese
-)
-
-func LanguageFromString(s string) (lang Language, ok bool) {
-	switch strings.ToUpper(s) {
-	case "EN", "ENGLISH":
-		return LanguageEnglish, true
-	case "DE", "GERMAN", "DEUTSCH":
-		return LanguageGerman, true
-	case "ES", "SPANISH", "ESPAÑOL":
-		return LanguageSpanish, true
-	case "PB", "BRAZILIAN PORTUGUESE", "PORTUGUÊS BRASILEIRO":
-		return LanguageSpanish, true
-	default:
-		return 0, false
-	}
-}
-
-func (l Language) String() string {
-	switch l {
-	case LanguageEnglish:
-		return "English"
-	case LanguageGerman:
-		return "German"
-	case LanguageSpanish:
-		return "Spanish"
-	case LanguageBrazilianPortuguese:
-		return "Brazilian Portuguese"
-	default:
-		panic("Language.String called on invalid language")
-	}
-}
-
-type Host int
-
-const (
-	HostSPDE     Host = iota // southpark.de
-	HostSPSCOM               // southparkstudios.com
-	HostSPSNU                // southparkstudios.nu
-	HostSPSDK                // southparkstudios.dk
-	HostSPCCCOM              // southpark.cc.com
-	HostSPNL                 // southpark.nl
-	HostSPLAT                // southpark.lat
-	HostSPSCOMBR             // southparkstudios.com.br
-)
-
-func HostFromString(hostStr string) (host Host, ok bool) {
-	switch strings.TrimPrefix(hostStr, "www.") {
-	case "southpark.de":
-		return HostSPDE, true
-	case "southparkstudios.com":
-		return HostSPSCOM, true
-	case "southparkstudios.nu":
-		return HostSPSNU, true
-	case "southparkstudios.dk":
-		return HostSPSDK, true
-	case "southpark.cc.com":
-		return HostSPCCCOM, true
-	case "southpark.nl":
-		return HostSPNL, true
-	case "southpark.lat":
-		return HostSPLAT, true
-	case "southparkstudios.com.br":
-		return HostSPSCOMBR, true
-	default:
-		return 0, false
-	}
-}
-
-func (h Host) String() string {
-	switch h {
-	case HostSPDE:
-		return "www.southpark.de"
-	case HostSPSCOM:
-		return "www.southparkstudios.com"
-	case HostSPSNU:
-		return "www.southparkstudios.nu"
-	case HostSPSDK:
-		return "www.southparkstudios.dk"
-	case HostSPCCCOM:
-		return "southpark.cc.com"
-	case HostSPNL:
-		return "www.southpark.nl"
-	case HostSPLAT:
-		return "www.southpark.lat"
-	case HostSPSCOMBR:
-		return "www.southparkstudios.com.br"
-	default:
-		panic("Host.String called on invalid host")
-	}
-}
-
-type RegionInfo struct {
-	Host               Host
-	AvailableLanguages []Language
-	RequiresExplicitEN bool
-}
-
-func GetRegionInfo(ctx context.Context) (RegionInfo, error) {
-	req, err := http.NewRequestWithContext(ctx, "GET", "https://southparkstudios.com", nil)
-	if err != nil {
-		return RegionInfo{}, fmt.Errorf("create southpark website request: %w", err)
-	}
-	var redirHost string
-	client := &http.Client{
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			redirHost = req.URL.Host
-			return nil
-		},
-	}
-	resp, err := client.Do(req)
-	if err != nil {
-		return RegionInfo{}, fmt.Errorf("get southpark website: %w", err)
-	}
-	resp.Body.Close()
-
-	if host, ok := HostFromString(redirHost); ok {
-		res := RegionInfo{
-			Host:               host,
-			AvailableLanguages: []Language{LanguageEnglish},
-		}
-		switch host {
-		case HostSPDE:
-			res.AvailableLanguages = append(res.AvailableLanguages, LanguageGerman)
-			res.RequiresExplicitEN = true
-		case HostSPLAT:
-			res.AvailableLanguages = append(res.AvailableLanguages, LanguageSpanish)
-			res.RequiresExplicitEN = true
-		case HostSPSCOMBR:
-			res.AvailableLanguages = append(res.AvailableLanguages, LanguageBrazilianPortuguese)
-			res.RequiresExplicitEN = true
-		}
-		return res, nil
-	} else {
-		return RegionInfo{}, fmt.Errorf("unsupported website region: %v", redirHost)
-	}
-}
-
-func (r RegionInfo) GetURLLanguage(spURL string) (Language, error) {
-	if len(r.AvailableLanguages) == 1 {
-		return r.AvailableLanguages[0], nil
-	} else if len(r.AvailableLanguages) == 2 &&
-		r.RequiresExplicitEN {
-		url, err := url.Parse(spURL)
-		if err != nil {
-			return 0, fmt.Errorf("parse URL: %w", err)
-		}
-		if strings.HasPrefix(url.Path, "/en/") {
-			return LanguageEnglish, nil
-		} else {
-			for _, v := range r.AvailableLanguages {
-				if v != LanguageEnglish {
-					return v, nil
-				}
-			}
-		}
-	}
-	panic("RegionInfo.GetURLLanguage called with invalid RegionInfo or invalid URL")
+type websiteDataItem struct {
+	Label        string `json:"label"`
+	URL          string `json:"url"`
+	SeasonNumber int    `json:"seasonNumber"`
+	Media        struct {
+		Image struct {
+			URL string `json:"url"`
+		} `json:"image"`
+		Duration    string `json:"duration"`
+		LockedLabel string `json:"lockedLabel"`
+	} `json:"media"`
+	Meta struct {
+		SubHeader   string `json:"subHeader"`
+		Description string `json:"description"`
+		ItemMGID    string `json:"itemMgid"`
+		SeriesMGID  string `json:"seriesMgid"`
+		SeasonMGID  string `json:"seasonMgid"`
+	} `json:"meta"`
 }
 
 type websiteDataProps struct {
@@ -221,27 +74,9 @@ type websiteDataProps struct {
 		} `json:"items"`
 		SelectedIndex int `json:"selectedIndex"`
 	} `json:"filters"`
-	IsEpisodes bool `json:"isEpisodes"`
-	Items      []struct {
-		Label        string `json:"label"`
-		URL          string `json:"url"`
-		SeasonNumber int    `json:"seasonNumber"`
-		Media        struct {
-			Image struct {
-				URL string `json:"url"`
-			} `json:"image"`
-			Duration    string `json:"duration"`
-			LockedLabel string `json:"lockedLabel"`
-		} `json:"media"`
-		Meta struct {
-			SubHeader   string `json:"subHeader"`
-			Description string `json:"description"`
-			ItemMGID    string `json:"itemMgid"`
-			SeriesMGID  string `json:"seriesMgid"`
-			SeasonMGID  string `json:"seasonMgid"`
-		} `json:"meta"`
-	} `json:"items"`
-	Media struct {
+	IsEpisodes bool              `json:"isEpisodes"`
+	Items      []websiteDataItem `json:"items"`
+	Media      struct {
 		Image struct {
 			URL string `json:"url"`
 		} `json:"image"`
@@ -290,22 +125,6 @@ func getWebsiteDataFromURL(ctx context.Context, url string) (websiteData, error)
 	return getWebsiteDataFromBody(body)
 }
 
-func getWebsiteDataFromBody(body []byte) (websiteData, error) {
-	re := regexp.MustCompile("window.__DATA__\\s*=\\s*({.*});\n")
-	match := re.FindSubmatch(body)
-	if match == nil || len(match) != 2 {
-		return websiteData{}, errors.New("unable to find JSON data in webpage")
-	}
-	dataJSON := match[1]
-
-	var data websiteData
-	err := json.Unmarshal(dataJSON, &data)
-	if err != nil {
-		return websiteData{}, fmt.Errorf("parse data JSON: %w", err)
-	}
-	return data, nil
-}
-
 func getWebsiteDataPropsFromURL(ctx context.Context, url string, containerType string, propsType string) (websiteDataProps, error) {
 	body, err := httputils.GetBodyWithContext(ctx, url)
 	if err != nil {
@@ -315,26 +134,6 @@ func getWebsiteDataPropsFromURL(ctx context.Context, url string, containerType s
 	return getWebsiteDataPropsFromBody(body, containerType, propsType)
 }
 
-func getWebsiteDataPropsFromBody(body []byte, containerType string, propsType string) (websiteDataProps, error) {
-	data, err := getWebsiteDataFromBody(body)
-	if err != nil {
-		return websiteDataProps{}, err
-	}
-
-	for _, v := range data.Children {
-		if v.Type == "MainContainer" {
-			for _, v := range v.Children {
-				if v.Type == containerType &&
-					(v.Props.Type == propsType || propsType == "") {
-					return v.Props, nil
-				}
-			}
-		}
-	}
-
-	return websiteDataProps{}, fmt.Errorf("unable to find container '%s' in webpage JSON", containerType)
-}
-
 type Season struct {
 	SeasonNumber int // From 1
 	Title        string
@@ -342,58 +141,81 @@ type Season struct {
 	Language     Language
 }
 
-func GetSeasons(ctx context.Context, regionInfo RegionInfo, language Language) (seasons []Season, seriesMGID string, err error) {
-	langAvailable := false
-	for _, v := range regionInfo.AvailableLanguages {
-		if v == language {
-			langAvailable = true
-			break
-		}
-	}
+// Pages that contain the season selector, tried in order. Using season
+// one first instead of /seasons/south-park, because in some regions (e.g.
+// sweden), the last season isn't available, meaning we can't get the series
+// MGID via that season, which messes everything up.
+var seasonPagePaths = []string{
+	"/seasons/south-park/yjy8n9/season-1",
+	"/seasons/south-park",
+}
 
-	if !langAvailable {
-		return nil, "", fmt.Errorf("language '%v' not available on '%v'",
-			language.String(),
-			regionInfo.Host)
+func GetSeasons(ctx context.Context, regionInfo RegionInfo, language Language) (seasons []Season, seriesMGID string, err error) {
+	langPath, err := regionInfo.PathPrefix(language)
+	if err != nil {
+		return nil, "", err
+	}
+
+	type result struct {
+		Seasons    []Season
+		SeriesMGID string
+	}
+	var strategies []extractionStrategy[result]
+	for _, p := range seasonPagePaths {
+		pageURL := fmt.Sprintf("https://%v%v%v", regionInfo.Host, langPath, p)
+		strategies = append(strategies, extractionStrategy[result]{
+			Name: "season page " + p,
+			Extract: func(ctx context.Context) (result, error) {
+				seasons, seriesMGID, err := getSeasonsFromPage(ctx, pageURL, language)
+				return result{seasons, seriesMGID}, err
+			},
+		})
 	}
 
-	langPath := ""
-	if language == LanguageEnglish && regionInfo.RequiresExplicitEN {
-		langPath = "/en"
+	res, err := extractWithFallbacks(ctx, strategies...)
+	if err != nil {
+		return nil, "", fmt.Errorf("get seasons: %w", err)
 	}
-	// Using season one instead of /seasons/south-park, because in some
-	// regions (e.g. sweden), the last season isn't available, meaning we
-	// can't get the series MGID via that season, which messes everything up
-	anySeasonURL := fmt.Sprintf("https://%v%v/seasons/south-park/yjy8n9/season-1", regionInfo.Host, langPath)
+	return res.Seasons, res.SeriesMGID, nil
+}
 
-	baseURL, err := getSPBaseURL(anySeasonURL)
+func getSeasonsFromPage(ctx context.Context, pageURL string, language Language) (seasons []Season, seriesMGID string, err error) {
+	baseURL, err := getSPBaseURL(pageURL)
 	if err != nil {
 		return nil, "", fmt.Errorf("get base URL: %w", err)
 	}
 
-	body, err := httputils.GetBodyWithContext(ctx, anySeasonURL)
+	body, err := httputils.GetBodyWithContext(ctx, pageURL)
 	if err != nil {
 		return nil, "", fmt.Errorf("get base data: %w", err)
 	}
 
+	data, err := getWebsiteDataFromBody(body)
+	if err != nil {
+		return nil, "", err
+	}
+
 	// Retrieve series MGID
 	{
-		props, err := getWebsiteDataPropsFromBody(body, "LineList", "video-guide")
+		props, err := data.getProps("LineList", "video-guide")
 		if err != nil {
-			return nil, "", fmt.Errorf("retrieve series MGID in website data JSON: %w", err)
+			return nil, "", err
 		}
 
-		if len(props.Items) == 0 {
-			return nil, "", fmt.Errorf("no series MGID found in website data JSON")
+		if len(props.Items) == 0 || props.Items[0].Meta.SeriesMGID == "" {
+			return nil, "", selectorError("LineList.items[0].meta.seriesMgid", errSelectorNotFound)
 		}
 
 		seriesMGID = props.Items[0].Meta.SeriesMGID
 	}
 
 	// Retrieve raw seasons data
-	props, err := getWebsiteDataPropsFromBody(body, "SeasonSelector", "")
+	props, err := data.getProps("SeasonSelector", "")
 	if err != nil {
-		return nil, "", fmt.Errorf("get 'SeasonSelector' in website data JSON: %w", err)
+		return nil, "", err
+	}
+	if len(props.Items) == 0 {
+		return nil, "", selectorError("SeasonSelector.items", errSelectorNotFound)
 	}
 
 	// Transform elements into our struct and return
@@ -405,7 +227,7 @@ func GetSeasons(ctx context.Context, regionInfo RegionInfo, language Language) (
 		} else {
 			// If v.URL is empty, that means
 			// we're at our initial URL
-			url = anySeasonURL
+			url = pageURL
 		}
 		res = append(res, Season{
 			SeasonNumber: v.SeasonNumber,
@@ -457,65 +279,98 @@ func GetEpisodes(ctx context.Context, season Season) (episodes []Episode, season
 		return nil, "", fmt.Errorf("get base URL: %w", err)
 	}
 
-	// Get the 'Show More' API call URL
-	var showMoreURL string
-	{
+	// Shared by both strategies, so the page is only downloaded once
+	var lineList *websiteDataProps
+	getLineList := func(ctx context.Context) (websiteDataProps, error) {
+		if lineList != nil {
+			return *lineList, nil
+		}
 		props, err := getWebsiteDataPropsFromURL(ctx, season.URL, "LineList", "video-guide")
 		if err != nil {
-			return nil, "", fmt.Errorf("retrieve 'show more' URL in website data JSON: %w", err)
+			return websiteDataProps{}, err
 		}
+		lineList = &props
+		return props, nil
+	}
+
+	items, err := extractWithFallbacks(ctx,
+		extractionStrategy[[]websiteDataItem]{
+			// The API the 'Show More' button calls, returns all episodes
+			Name: "show-more-api",
+			Extract: func(ctx context.Context) ([]websiteDataItem, error) {
+				props, err := getLineList(ctx)
+				if err != nil {
+					return nil, err
+				}
 
-		index := props.Filters.SelectedIndex
-		if index < 0 || index >= len(props.Filters.Items) {
-			return nil, "", errors.New("invalid JSON data: index out of bounds")
-		}
+				index := props.Filters.SelectedIndex
+				if index < 0 || index >= len(props.Filters.Items) {
+					return nil, selectorError("LineList.filters.items[selectedIndex]", errors.New("index out of bounds"))
+				}
+				showMoreURL := props.Filters.Items[index].URL
+				if showMoreURL == "" {
+					return nil, selectorError("LineList.filters.items[selectedIndex].url", errSelectorNotFound)
+				}
+
+				body, err := httputils.GetBodyWithContext(ctx, baseURL+showMoreURL)
+				if err != nil {
+					return nil, fmt.Errorf("get episodes: %w", err)
+				}
 
-		showMoreURL = props.Filters.Items[index].URL
+				var apiProps websiteDataProps
+				if err := json.Unmarshal(body, &apiProps); err != nil {
+					return nil, selectorError("items", fmt.Errorf("parse episodes from JSON: %w", err))
+				}
+				if len(apiProps.Items) == 0 {
+					return nil, selectorError("items", errSelectorNotFound)
+				}
+				return apiProps.Items, nil
+			},
+		},
+		extractionStrategy[[]websiteDataItem]{
+			// Only contains the episodes shown before clicking 'Show More'
+			Name: "embedded-line-list",
+			Extract: func(ctx context.Context) ([]websiteDataItem, error) {
+				props, err := getLineList(ctx)
+				if err != nil {
+					return nil, err
+				}
+				if len(props.Items) == 0 {
+					return nil, selectorError("LineList.items", errSelectorNotFound)
+				}
+				return props.Items, nil
+			},
+		},
+	)
+	if err != nil {
+		return nil, "", fmt.Errorf("get episodes: %w", err)
 	}
 
-	// Fetch all episodes using API call
-	var res []Episode
-	{
-		body, err := httputils.GetBodyWithContext(ctx, baseURL+showMoreURL)
-		if err != nil {
-			return nil, "", fmt.Errorf("get episodes: %w", err)
-		}
+	seasonMGID = items[0].Meta.SeasonMGID
 
-		var props websiteDataProps
-		err = json.Unmarshal(body, &props)
+	var res []Episode
+	for _, v := range items {
+		seasonNum, episodeNum, err := getSeasonAndEpisodeNumberFromURL(v.URL)
 		if err != nil {
-			return nil, "", fmt.Errorf("parse episodes from JSON: %w", err)
-		}
-
-		if len(props.Items) == 0 {
-			return nil, "", fmt.Errorf("no episodes found in season")
+			return nil, "", fmt.Errorf("extract season and episode number from URL: %w", err)
 		}
-
-		seasonMGID = props.Items[0].Meta.SeasonMGID
-
-		for _, v := range props.Items {
-			seasonNum, episodeNum, err := getSeasonAndEpisodeNumberFromURL(v.URL)
-			if err != nil {
-				return nil, "", fmt.Errorf("extract season and episode number from URL: %w", err)
-			}
-			if seasonNum != season.SeasonNumber {
-				return nil, "", fmt.Errorf("mismatch between season number in url (%v) and in season parameter (%v)", seasonNum, season.SeasonNumber)
-			}
-			res = append(res, Episode{
-				EpisodeMetadata: EpisodeMetadata{
-					SeasonNumber:  seasonNum,
-					EpisodeNumber: episodeNum,
-					Language:      season.Language,
-					Unavailable: v.Media.LockedLabel != "" ||
-						v.Media.Duration == "00:00",
-					RawThumbnailURL: v.Media.Image.URL,
-					Title:           v.Meta.SubHeader,
-					Description:     v.Meta.Description,
-					URL:             baseURL + v.URL,
-				},
-				MGID: v.Meta.ItemMGID,
-			})
+		if seasonNum != season.SeasonNumber {
+			return nil, "", fmt.Errorf("mismatch between season number in url (%v) and in season parameter (%v)", seasonNum, season.SeasonNumber)
 		}
+		res = append(res, Episode{
+			EpisodeMetadata: EpisodeMetadata{
+				SeasonNumber:  seasonNum,
+				EpisodeNumber: episodeNum,
+				Language:      season.Language,
+				Unavailable: v.Media.LockedLabel != "" ||
+					v.Media.Duration == "00:00",
+				RawThumbnailURL: v.Media.Image.URL,
+				Title:           v.Meta.SubHeader,
+				Description:     v.Meta.Description,
+				URL:             baseURL + v.URL,
+			},
+			MGID: v.Meta.ItemMGID,
+		})
 	}
 
 	// Sort episodes
@@ -580,6 +435,9 @@ type searchData struct {
 	} `json:"response"`
 }
 
+// Takes the host, replaced by tests to use plain HTTP.
+var searchAPIURL = "https://%v/api/search"
+
 func Search(
 	ctx context.Context,
 	regionInfo RegionInfo,
@@ -594,7 +452,7 @@ func Search(
 	}
 
 	apiURL := fmt.Sprintf(
-		"https://%v/api/search?q=%v&activeTab=Episode&showId=%v&pageNumber=%v&rowsPerPage=%v",
+		searchAPIURL+"?q=%v&activeTab=Episode&showId=%v&pageNumber=%v&rowsPerPage=%v",
 		regionInfo.Host,
 		url.QueryEscape(query),
 		showID,
@@ -643,3 +501,72 @@ func Search(
 	}
 	return res, nil
 }
+
+// Fetches search results page by page, skipping results
+// that were already returned. Next must not be called
+// concurrently, but Done may be called at any time.
+type SearchIterator struct {
+	// Only results for which Filter returns true are returned.
+	// May be nil.
+	Filter func(EpisodeMetadata) bool
+
+	regionInfo     RegionInfo
+	seriesMGID     string
+	query          string
+	resultsPerPage int
+
+	page    int
+	seen    map[string]struct{}
+	pending []EpisodeMetadata // Collected before an error occurred
+	done    atomic.Bool
+}
+
+func NewSearchIterator(regionInfo RegionInfo, seriesMGID string, query string, resultsPerPage int) *SearchIterator {
+	return &SearchIterator{
+		regionInfo:     regionInfo,
+		seriesMGID:     seriesMGID,
+		query:          query,
+		resultsPerPage: resultsPerPage,
+		seen:           make(map[string]struct{}),
+	}
+}
+
+// Returns the next batch of results. Fetches pages until at least one new
+// result passes the filter or there are no more pages. If an error occurs,
+// Next may be called again to retry the same page.
+func (it *SearchIterator) Next(ctx context.Context) ([]EpisodeMetadata, error) {
+	res := it.pending
+	it.pending = nil
+	for len(res) == 0 && !it.done.Load() {
+		results, err := Search(ctx, it.regionInfo, it.seriesMGID, it.query, it.page, it.resultsPerPage)
+		if err != nil {
+			it.pending = res
+			return nil, err
+		}
+		it.page++
+
+		anyNew := false
+		for _, v := range results {
+			key := fmt.Sprintf("%v:%v:%v", v.Language, v.SeasonNumber, v.EpisodeNumber)
+			if _, ok := it.seen[key]; ok {
+				continue
+			}
+			it.seen[key] = struct{}{}
+			anyNew = true
+			if it.Filter == nil || it.Filter(v) {
+				res = append(res, v)
+			}
+		}
+		// A page with only duplicates means the API is
+		// repeating itself, so stop instead of looping forever.
+		if len(results) < it.resultsPerPage || !anyNew {
+			it.done.Store(true)
+		}
+	}
+	return res, nil
+}
+
+// Reports whether all results have been returned.
+func (it *SearchIterator) Done() bool {
+	return it.done.Load()
+}
@@ -0,0 +1,80 @@
+package southpark
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+func TestSearchIterator(t *testing.T) {
+	// Pages 0 and 1 overlap by one episode, page 2 is the last one
+	pages := [][]string{
+		{"/episodes/a/south-park-a-season-1-ep-1", "/en/episodes/b/south-park-b-season-1-ep-2", "/episodes/c/south-park-c-season-1-ep-3"},
+		{"/episodes/c/south-park-c-season-1-ep-3", "/episodes/d/south-park-d-season-2-ep-1", "/en/episodes/e/south-park-e-season-2-ep-2"},
+		{"/en/episodes/f/south-park-f-season-3-ep-1"},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, _ := strconv.Atoi(r.URL.Query().Get("pageNumber"))
+		type item struct {
+			URL   string         `json:"url"`
+			Media map[string]any `json:"media"`
+		}
+		var items []item
+		if page < len(pages) {
+			for _, v := range pages[page] {
+				items = append(items, item{URL: v, Media: map[string]any{"duration": "22:00"}})
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"response": map[string]any{"items": items}})
+	}))
+	defer srv.Close()
+	oldSearchAPIURL := searchAPIURL
+	searchAPIURL = "http://%v/api/search"
+	t.Cleanup(func() { searchAPIURL = oldSearchAPIURL })
+
+	u, _ := url.Parse(srv.URL)
+	region := RegionInfo{
+		Host:      Host(u.Host),
+		Languages: []HostLanguage{{LanguageGerman, ""}, {LanguageEnglish, "/en"}},
+	}
+	it := NewSearchIterator(region, "mgid:arc:series:southpark.intl:x", "q", 3)
+	it.Filter = func(e EpisodeMetadata) bool {
+		return e.Language == LanguageGerman
+	}
+
+	// Done is polled from the UI while Next runs
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				it.Done()
+			}
+		}
+	}()
+
+	var got []string
+	for calls := 0; !it.Done(); calls++ {
+		if calls > len(pages) {
+			t.Fatal("iterator didn't finish")
+		}
+		res, err := it.Next(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range res {
+			got = append(got, fmt.Sprintf("S%vE%v", v.SeasonNumber, v.EpisodeNumber))
+		}
+	}
+	if fmt.Sprint(got) != "[S1E1 S1E3 S2E1]" {
+		t.Errorf("unexpected results: %v", got)
+	}
+}
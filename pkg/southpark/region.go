@@ -0,0 +1,335 @@
+package southpark
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xypwn/southpark-downloader-ui/pkg/httputils"
+)
+
+type Language int
+
+const (
+	LanguageEnglish Language = iota
+	LanguageGerman
+	LanguageSpanish
+	LanguageBrazilianPortuguese
+	LanguageDutch
+	LanguageSwedish
+	LanguageDanish
+	LanguageNorwegian
+	LanguageFinnish
+)
+
+var languageInfos = []struct {
+	Language Language
+	Code     string
+	Name     string
+	Aliases  []string // Including native name, all upper case
+}{
+	{LanguageEnglish, "EN", "English", nil},
+	{LanguageGerman, "DE", "German", []string{"DEUTSCH"}},
+	{LanguageSpanish, "ES", "Spanish", []string{"ESPAÑOL"}},
+	{LanguageBrazilianPortuguese, "PB", "Brazilian Portuguese", []string{"PT-BR", "PORTUGUÊS BRASILEIRO"}},
+	{LanguageDutch, "NL", "Dutch", []string{"NEDERLANDS"}},
+	{LanguageSwedish, "SV", "Swedish", []string{"SVENSKA"}},
+	{LanguageDanish, "DA", "Danish", []string{"DANSK"}},
+	{LanguageNorwegian, "NO", "Norwegian", []string{"NORSK"}},
+	{LanguageFinnish, "FI", "Finnish", []string{"SUOMI"}},
+}
+
+// Accepts language codes (e.g. "EN"), English and native names.
+func LanguageFromString(s string) (lang Language, ok bool) {
+	s = strings.ToUpper(s)
+	for _, v := range languageInfos {
+		if s == v.Code || s == strings.ToUpper(v.Name) {
+			return v.Language, true
+		}
+		for _, alias := range v.Aliases {
+			if s == alias {
+				return v.Language, true
+			}
+		}
+	}
+	return 0, false
+}
+
+func (l Language) String() string {
+	for _, v := range languageInfos {
+		if v.Language == l {
+			return v.Name
+		}
+	}
+	return fmt.Sprintf("Language(%d)", int(l))
+}
+
+// Returns the short language code, e.g. "EN".
+func (l Language) Code() string {
+	for _, v := range languageInfos {
+		if v.Language == l {
+			return v.Code
+		}
+	}
+	return fmt.Sprintf("Language(%d)", int(l))
+}
+
+// Host name of a South Park website, e.g. "www.southpark.de".
+type Host string
+
+const (
+	HostSPDE     Host = "www.southpark.de"
+	HostSPSCOM   Host = "www.southparkstudios.com"
+	HostSPSNU    Host = "www.southparkstudios.nu"
+	HostSPSDK    Host = "www.southparkstudios.dk"
+	HostSPCCCOM  Host = "southpark.cc.com"
+	HostSPNL     Host = "www.southpark.nl"
+	HostSPLAT    Host = "www.southpark.lat"
+	HostSPSCOMBR Host = "www.southparkstudios.com.br"
+)
+
+// Hosts in the order of their former numeric IDs
+var legacyHostIDs = []Host{
+	HostSPDE,
+	HostSPSCOM,
+	HostSPSNU,
+	HostSPSDK,
+	HostSPCCCOM,
+	HostSPNL,
+	HostSPLAT,
+	HostSPSCOMBR,
+}
+
+func hostsEqual(a, b string) bool {
+	return strings.EqualFold(strings.TrimPrefix(a, "www."), strings.TrimPrefix(b, "www."))
+}
+
+// Only returns ok for built-in hosts.
+func HostFromString(hostStr string) (host Host, ok bool) {
+	for _, v := range builtinHosts {
+		if hostsEqual(string(v.Host), hostStr) {
+			return v.Host, true
+		}
+	}
+	return "", false
+}
+
+func (h Host) String() string {
+	return string(h)
+}
+
+// Also accepts the numeric host IDs used by older versions,
+// so existing caches can still be read.
+func (h *Host) UnmarshalText(text []byte) error {
+	if i, err := strconv.Atoi(string(text)); err == nil {
+		if i < 0 || i >= len(legacyHostIDs) {
+			return fmt.Errorf("invalid host ID: %v", i)
+		}
+		*h = legacyHostIDs[i]
+		return nil
+	}
+	*h = Host(text)
+	return nil
+}
+
+func (h *Host) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		return h.UnmarshalText([]byte(s))
+	}
+	return h.UnmarshalText(data)
+}
+
+type HostLanguage struct {
+	Language Language
+	// Prepended to URL paths for this language, e.g. "/en".
+	// Empty for the host's default language.
+	PathPrefix string
+}
+
+// Describes which languages a host serves and under which URL paths.
+type HostDefinition struct {
+	Host      Host
+	Languages []HostLanguage
+}
+
+var builtinHosts = []HostDefinition{
+	{HostSPDE, []HostLanguage{{LanguageEnglish, "/en"}, {LanguageGerman, ""}}},
+	{HostSPSCOM, []HostLanguage{{LanguageEnglish, ""}}},
+	{HostSPSNU, []HostLanguage{{LanguageEnglish, ""}}},
+	{HostSPSDK, []HostLanguage{{LanguageEnglish, ""}}},
+	{HostSPCCCOM, []HostLanguage{{LanguageEnglish, ""}}},
+	{HostSPNL, []HostLanguage{{LanguageEnglish, ""}}},
+	{HostSPLAT, []HostLanguage{{LanguageEnglish, "/en"}, {LanguageSpanish, ""}}},
+	{HostSPSCOMBR, []HostLanguage{{LanguageEnglish, "/en"}, {LanguageBrazilianPortuguese, ""}}},
+}
+
+func BuiltinHostDefinitions() []HostDefinition {
+	res := make([]HostDefinition, len(builtinHosts))
+	copy(res, builtinHosts)
+	return res
+}
+
+// Checks that there is at least one language, that no language
+// appears twice and that path prefixes are unique and well-formed.
+func (d HostDefinition) Validate() error {
+	if d.Host == "" {
+		return fmt.Errorf("empty host")
+	}
+	if len(d.Languages) == 0 {
+		return fmt.Errorf("%v: no languages specified", d.Host)
+	}
+	langs := make(map[Language]struct{})
+	prefixes := make(map[string]struct{})
+	for _, v := range d.Languages {
+		if _, ok := langs[v.Language]; ok {
+			return fmt.Errorf("%v: language %v specified more than once", d.Host, v.Language)
+		}
+		langs[v.Language] = struct{}{}
+		if v.PathPrefix != "" && (!strings.HasPrefix(v.PathPrefix, "/") || strings.HasSuffix(v.PathPrefix, "/")) {
+			return fmt.Errorf("%v: path prefix '%v' of %v must start and must not end with '/'", d.Host, v.PathPrefix, v.Language)
+		}
+		if _, ok := prefixes[v.PathPrefix]; ok {
+			return fmt.Errorf("%v: path prefix '%v' used by more than one language", d.Host, v.PathPrefix)
+		}
+		prefixes[v.PathPrefix] = struct{}{}
+	}
+	return nil
+}
+
+func (d HostDefinition) RegionInfo() RegionInfo {
+	return RegionInfo{
+		Host:      d.Host,
+		Languages: append([]HostLanguage{}, d.Languages...),
+	}
+}
+
+type RegionInfo struct {
+	Host      Host
+	Languages []HostLanguage
+}
+
+func (r RegionInfo) AvailableLanguages() []Language {
+	res := make([]Language, len(r.Languages))
+	for i, v := range r.Languages {
+		res[i] = v.Language
+	}
+	return res
+}
+
+func (r RegionInfo) PathPrefix(language Language) (string, error) {
+	for _, v := range r.Languages {
+		if v.Language == language {
+			return v.PathPrefix, nil
+		}
+	}
+	return "", fmt.Errorf("%w: language '%v' not available on '%v'", ErrRegionUnsupported, language, r.Host)
+}
+
+// Determines the language of a URL on this host by its path prefix.
+func (r RegionInfo) GetURLLanguage(spURL string) (Language, error) {
+	u, err := url.Parse(spURL)
+	if err != nil {
+		return 0, fmt.Errorf("parse URL: %w", err)
+	}
+
+	// Longest matching prefix wins, the empty prefix matches everything
+	found := false
+	var res HostLanguage
+	for _, v := range r.Languages {
+		if v.PathPrefix != "" &&
+			u.Path != v.PathPrefix &&
+			!strings.HasPrefix(u.Path, v.PathPrefix+"/") {
+			continue
+		}
+		if !found || len(v.PathPrefix) > len(res.PathPrefix) {
+			res = v
+			found = true
+		}
+	}
+	if !found {
+		return 0, fmt.Errorf("unable to determine language of URL '%v' on '%v'", spURL, r.Host)
+	}
+	return res.Language, nil
+}
+
+// Looks up host in extraHosts first, then in the built-in hosts.
+func GetRegionInfoForHost(host string, extraHosts []HostDefinition) (RegionInfo, error) {
+	for _, defs := range [][]HostDefinition{extraHosts, builtinHosts} {
+		for _, v := range defs {
+			if hostsEqual(string(v.Host), host) {
+				if err := v.Validate(); err != nil {
+					return RegionInfo{}, fmt.Errorf("invalid host definition: %w", err)
+				}
+				return v.RegionInfo(), nil
+			}
+		}
+	}
+	return RegionInfo{}, fmt.Errorf("%w: %v", ErrRegionUnsupported, host)
+}
+
+// Returns the host southparkstudios.com redirects to from
+// the current IP address.
+func DetectHost(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://southparkstudios.com", nil)
+	if err != nil {
+		return "", fmt.Errorf("create southpark website request: %w", err)
+	}
+	var redirHost string
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			redirHost = req.URL.Host
+			return nil
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("get southpark website: %w", err)
+	}
+	resp.Body.Close()
+	return redirHost, nil
+}
+
+// Determines the region via DetectHost. extraHosts may be nil.
+func GetRegionInfo(ctx context.Context, extraHosts []HostDefinition) (RegionInfo, error) {
+	host, err := DetectHost(ctx)
+	if err != nil {
+		return RegionInfo{}, err
+	}
+	return GetRegionInfoForHost(host, extraHosts)
+}
+
+// Checks whether host serves its episode pages to the current IP
+// address. Returns the time it took to get a response.
+func ProbeHost(ctx context.Context, host Host) (time.Duration, error) {
+	start := time.Now()
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("https://%v/", host), nil)
+	if err != nil {
+		return 0, fmt.Errorf("create request: %w", err)
+	}
+	var redirHost string
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			redirHost = req.URL.Host
+			return nil
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("%v is unreachable: %w", host, err)
+	}
+	resp.Body.Close()
+	elapsed := time.Since(start)
+	if redirHost != "" && !hostsEqual(redirHost, string(host)) {
+		return elapsed, fmt.Errorf("%w: %v redirects to %v", ErrGeoBlocked, host, redirHost)
+	}
+	if err := httputils.CheckStatus(resp); err != nil {
+		return elapsed, err
+	}
+	return elapsed, nil
+}
@@ -0,0 +1,245 @@
+package southpark
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestLanguageFromString(t *testing.T) {
+	tests := map[string]Language{
+		"EN":                   LanguageEnglish,
+		"english":              LanguageEnglish,
+		"de":                   LanguageGerman,
+		"Deutsch":              LanguageGerman,
+		"ES":                   LanguageSpanish,
+		"español":              LanguageSpanish,
+		"PB":                   LanguageBrazilianPortuguese,
+		"pt-br":                LanguageBrazilianPortuguese,
+		"Brazilian Portuguese": LanguageBrazilianPortuguese,
+		"NL":                   LanguageDutch,
+		"svenska":              LanguageSwedish,
+		"DA":                   LanguageDanish,
+		"Norwegian":            LanguageNorwegian,
+		"FI":                   LanguageFinnish,
+	}
+	for s, want := range tests {
+		got, ok := LanguageFromString(s)
+		if !ok {
+			t.Errorf("%v: not recognized", s)
+		} else if got != want {
+			t.Errorf("%v: expected %v, got %v", s, want, got)
+		}
+	}
+
+	for _, s := range []string{"", "XX", "Klingon"} {
+		if _, ok := LanguageFromString(s); ok {
+			t.Errorf("%v: expected not to be recognized", s)
+		}
+	}
+}
+
+func TestLanguageString(t *testing.T) {
+	for _, v := range languageInfos {
+		if v.Language.String() != v.Name {
+			t.Errorf("expected %v, got %v", v.Name, v.Language.String())
+		}
+		if v.Language.Code() != v.Code {
+			t.Errorf("expected %v, got %v", v.Code, v.Language.Code())
+		}
+		// Round trip
+		if l, ok := LanguageFromString(v.Language.String()); !ok || l != v.Language {
+			t.Errorf("%v: name doesn't round trip", v.Name)
+		}
+		if l, ok := LanguageFromString(v.Language.Code()); !ok || l != v.Language {
+			t.Errorf("%v: code doesn't round trip", v.Name)
+		}
+	}
+
+	// Must not panic
+	if s := Language(1000).String(); s != "Language(1000)" {
+		t.Errorf("unexpected string for invalid language: %v", s)
+	}
+}
+
+func TestBuiltinHostDefinitions(t *testing.T) {
+	for _, v := range BuiltinHostDefinitions() {
+		if err := v.Validate(); err != nil {
+			t.Errorf("%v", err)
+		}
+		if h, ok := HostFromString(v.Host.String()); !ok || h != v.Host {
+			t.Errorf("%v: HostFromString doesn't round trip", v.Host)
+		}
+	}
+}
+
+func TestHostDefinitionValidate(t *testing.T) {
+	tests := map[string]HostDefinition{
+		"no host":          {Languages: []HostLanguage{{LanguageEnglish, ""}}},
+		"no languages":     {Host: "example.com"},
+		"duplicate lang":   {Host: "example.com", Languages: []HostLanguage{{LanguageEnglish, ""}, {LanguageEnglish, "/en"}}},
+		"duplicate prefix": {Host: "example.com", Languages: []HostLanguage{{LanguageEnglish, "/x"}, {LanguageGerman, "/x"}}},
+		"two defaults":     {Host: "example.com", Languages: []HostLanguage{{LanguageEnglish, ""}, {LanguageGerman, ""}}},
+		"no leading slash": {Host: "example.com", Languages: []HostLanguage{{LanguageEnglish, "en"}}},
+		"trailing slash":   {Host: "example.com", Languages: []HostLanguage{{LanguageEnglish, "/en/"}}},
+		"only a slash":     {Host: "example.com", Languages: []HostLanguage{{LanguageEnglish, "/"}}},
+		"empty languages":  {Host: "example.com", Languages: []HostLanguage{}},
+	}
+	for name, def := range tests {
+		if err := def.Validate(); err == nil {
+			t.Errorf("%v: expected error", name)
+		}
+	}
+}
+
+func TestRegionInfoGetURLLanguage(t *testing.T) {
+	nordic := RegionInfo{
+		Host: "www.southparkstudios.example",
+		Languages: []HostLanguage{
+			{LanguageSwedish, ""},
+			{LanguageEnglish, "/en"},
+			{LanguageDanish, "/da"},
+			{LanguageNorwegian, "/no"},
+			{LanguageFinnish, "/fi"},
+		},
+	}
+	de, err := GetRegionInfoForHost("southpark.de", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	com, err := GetRegionInfoForHost("southparkstudios.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefixOnly := RegionInfo{
+		Host: "example.com",
+		Languages: []HostLanguage{
+			{LanguageEnglish, "/en"},
+			{LanguageDutch, "/nl"},
+		},
+	}
+
+	tests := []struct {
+		Region RegionInfo
+		URL    string
+		Want   Language
+	}{
+		{de, "https://www.southpark.de/en/episodes/abc/south-park-1-s1-e1", LanguageEnglish},
+		{de, "https://www.southpark.de/folgen/abc/south-park-1-s1-e1", LanguageGerman},
+		{de, "https://www.southpark.de/entertainment", LanguageGerman}, // Not "/en"
+		{de, "https://www.southpark.de/en", LanguageEnglish},
+		{com, "https://www.southparkstudios.com/episodes/abc", LanguageEnglish},
+		{nordic, "/da/episodes/x", LanguageDanish},
+		{nordic, "/no/episodes/x", LanguageNorwegian},
+		{nordic, "/fi/episodes/x", LanguageFinnish},
+		{nordic, "/en/episodes/x", LanguageEnglish},
+		{nordic, "/episodes/x", LanguageSwedish},
+		{prefixOnly, "/nl/episodes/x", LanguageDutch},
+	}
+	for _, tt := range tests {
+		got, err := tt.Region.GetURLLanguage(tt.URL)
+		if err != nil {
+			t.Errorf("%v: %v", tt.URL, err)
+		} else if got != tt.Want {
+			t.Errorf("%v: expected %v, got %v", tt.URL, tt.Want, got)
+		}
+	}
+
+	// No default language and no matching prefix
+	if _, err := prefixOnly.GetURLLanguage("/de/episodes/x"); err == nil {
+		t.Errorf("expected error for URL without matching prefix")
+	}
+	if _, err := (RegionInfo{}).GetURLLanguage("/episodes/x"); err == nil {
+		t.Errorf("expected error for region without languages")
+	}
+}
+
+func TestRegionInfoPathPrefix(t *testing.T) {
+	r, err := GetRegionInfoForHost("www.southpark.lat", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, err := r.PathPrefix(LanguageEnglish); err != nil || p != "/en" {
+		t.Errorf("expected '/en', got '%v' (%v)", p, err)
+	}
+	if p, err := r.PathPrefix(LanguageSpanish); err != nil || p != "" {
+		t.Errorf("expected '', got '%v' (%v)", p, err)
+	}
+	if _, err := r.PathPrefix(LanguageGerman); err == nil {
+		t.Errorf("expected error for unavailable language")
+	}
+}
+
+func TestHostUnmarshalLegacy(t *testing.T) {
+	var v struct {
+		Host   Host
+		Series map[Host]int
+	}
+	if err := json.Unmarshal([]byte(`{"Host":6,"Series":{"0":1,"www.southpark.nl":2}}`), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Host != HostSPLAT {
+		t.Errorf("expected %v, got %v", HostSPLAT, v.Host)
+	}
+	if v.Series[HostSPDE] != 1 || v.Series[HostSPNL] != 2 {
+		t.Errorf("unexpected map: %v", v.Series)
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v2 struct {
+		Host   Host
+		Series map[Host]int
+	}
+	if err := json.Unmarshal(data, &v2); err != nil {
+		t.Fatal(err)
+	}
+	if v2.Host != v.Host || len(v2.Series) != len(v.Series) {
+		t.Errorf("round trip mismatch: %+v vs %+v", v2, v)
+	}
+
+	if err := json.Unmarshal([]byte(`{"Host":42}`), &v); err == nil {
+		t.Errorf("expected error for invalid host ID")
+	}
+}
+
+func TestGetRegionInfoForHost(t *testing.T) {
+	r, err := GetRegionInfoForHost("southpark.de", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Host != HostSPDE || len(r.Languages) != 2 {
+		t.Errorf("unexpected region info: %+v", r)
+	}
+
+	if _, err := GetRegionInfoForHost("southpark.example.com", nil); err == nil {
+		t.Errorf("expected error for unknown host")
+	}
+
+	extra := []HostDefinition{
+		{Host: "southpark.example.com", Languages: []HostLanguage{{LanguageSpanish, ""}}},
+		{Host: HostSPDE, Languages: []HostLanguage{{LanguageGerman, ""}}},
+		{Host: "broken.example.com"},
+	}
+	r, err = GetRegionInfoForHost("www.southpark.example.com", extra)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Host != "southpark.example.com" || len(r.Languages) != 1 || r.Languages[0].Language != LanguageSpanish {
+		t.Errorf("unexpected region info: %+v", r)
+	}
+
+	// Custom definitions take precedence
+	r, err = GetRegionInfoForHost("www.southpark.de", extra)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if langs := r.AvailableLanguages(); len(langs) != 1 || langs[0] != LanguageGerman {
+		t.Errorf("expected custom definition to override built-in one, got %+v", r)
+	}
+
+	if _, err := GetRegionInfoForHost("broken.example.com", extra); err == nil {
+		t.Errorf("expected error for invalid host definition")
+	}
+}
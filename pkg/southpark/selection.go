@@ -0,0 +1,408 @@
+package southpark
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Episode selection expression, e.g. "S01-S05", "S10E03",
+// "S20E01-E05,!S20E04", "latest" or "all:de".
+//
+// An expression is a list of terms separated by commas or spaces.
+// A term is one of:
+//
+//	S05            all episodes of a season
+//	5              same as S05
+//	S05E04         a single episode
+//	S01-S05        a range of seasons
+//	S20E01-E05     a range of episodes within a season
+//	S01E05-S02E03  a range of episodes across seasons
+//	latest         the newest available episode
+//	all            all episodes
+//
+// Any term can be followed by ":<language>", e.g. "S05:de".
+// Terms without a language select from the default language.
+// A term prefixed with "!" excludes episodes. Terms are applied from
+// left to right, so "S20,!S20E04" selects all of season 20 except
+// S20E04. Exclusions without a language apply to every language.
+// An expression consisting only of exclusions selects everything else.
+type Selection struct {
+	Terms []SelectionTerm
+}
+
+type SelectionTermKind int
+
+const (
+	SelectionTermRange SelectionTermKind = iota
+	SelectionTermLatest
+	SelectionTermAll
+)
+
+type SelectionTerm struct {
+	Kind        SelectionTermKind
+	Exclude     bool
+	Language    Language
+	HasLanguage bool
+	// Inclusive bounds for SelectionTermRange. An episode
+	// number of 0 stands for the whole season.
+	FromSeason, FromEpisode int
+	ToSeason, ToEpisode     int
+}
+
+type SelectionError struct {
+	Input string
+	Pos   int // Byte offset into Input
+	Msg   string
+}
+
+func (e *SelectionError) Error() string {
+	return fmt.Sprintf("invalid selection \"%v\" at position %v: %v", e.Input, e.Pos+1, e.Msg)
+}
+
+type selectionToken struct {
+	Pos  int
+	Text string // A word or one of ",!-:"
+}
+
+func tokenizeSelection(s string) ([]selectionToken, error) {
+	var res []selectionToken
+	for i := 0; i < len(s); {
+		r := rune(s[i])
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case strings.ContainsRune(",!-:", r):
+			res = append(res, selectionToken{i, s[i : i+1]})
+			i++
+		case 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9':
+			start := i
+			for i < len(s) && (('a' <= s[i] && s[i] <= 'z') ||
+				('A' <= s[i] && s[i] <= 'Z') ||
+				('0' <= s[i] && s[i] <= '9')) {
+				i++
+			}
+			res = append(res, selectionToken{start, s[start:i]})
+		default:
+			return nil, &SelectionError{s, i, fmt.Sprintf("unexpected character '%c'", r)}
+		}
+	}
+	return res, nil
+}
+
+var (
+	selectionBoundRegexp    = regexp.MustCompile(`^(?i:s(\d+)(?:e(\d+))?)$`)
+	selectionEndBoundRegexp = regexp.MustCompile(`^(?i:e(\d+))$`)
+)
+
+// Matches a season or an episode, a bare number is a season.
+func matchSelectionBound(word string) []string {
+	if word != "" && strings.Trim(word, "0123456789") == "" {
+		word = "S" + word
+	}
+	return selectionBoundRegexp.FindStringSubmatch(word)
+}
+
+// Season and episode numbers must fit into an episodeKey half.
+func parseSelectionNumber(s string) (int, bool) {
+	n, err := strconv.ParseInt(s, 10, 32)
+	return int(n), err == nil
+}
+
+func ParseSelection(s string) (Selection, error) {
+	toks, err := tokenizeSelection(s)
+	if err != nil {
+		return Selection{}, err
+	}
+	errAt := func(i int, msg string) error {
+		pos := len(s)
+		if i < len(toks) {
+			pos = toks[i].Pos
+		}
+		return &SelectionError{s, pos, msg}
+	}
+	peek := func(i int) string {
+		if i < len(toks) {
+			return toks[i].Text
+		}
+		return ""
+	}
+	// Parses the numbers of a bound matched at token i,
+	// an empty string is 0
+	parseNumbers := func(i int, strs ...string) ([]int, error) {
+		res := make([]int, len(strs))
+		for j, v := range strs {
+			if v == "" {
+				continue
+			}
+			n, ok := parseSelectionNumber(v)
+			if !ok {
+				return nil, errAt(i, "number too large")
+			}
+			res[j] = n
+		}
+		return res, nil
+	}
+
+	var res Selection
+	for i := 0; i < len(toks); {
+		if peek(i) == "," {
+			i++
+			continue
+		}
+
+		var term SelectionTerm
+		if peek(i) == "!" {
+			term.Exclude = true
+			i++
+		}
+
+		word := peek(i)
+		switch {
+		case strings.EqualFold(word, "all"):
+			term.Kind = SelectionTermAll
+			i++
+		case strings.EqualFold(word, "latest"):
+			term.Kind = SelectionTermLatest
+			i++
+		default:
+			m := matchSelectionBound(word)
+			if m == nil {
+				return Selection{}, errAt(i, "expected a season (e.g. S05), an episode (e.g. S05E04), \"latest\" or \"all\"")
+			}
+			term.Kind = SelectionTermRange
+			nums, err := parseNumbers(i, m[1], m[2])
+			if err != nil {
+				return Selection{}, err
+			}
+			term.FromSeason, term.FromEpisode = nums[0], nums[1]
+			term.ToSeason, term.ToEpisode = term.FromSeason, term.FromEpisode
+			i++
+
+			if peek(i) == "-" {
+				i++
+				word := peek(i)
+				if m := matchSelectionBound(word); m != nil {
+					nums, err := parseNumbers(i, m[1], m[2])
+					if err != nil {
+						return Selection{}, err
+					}
+					term.ToSeason, term.ToEpisode = nums[0], nums[1]
+				} else if m := selectionEndBoundRegexp.FindStringSubmatch(word); m != nil {
+					nums, err := parseNumbers(i, m[1])
+					if err != nil {
+						return Selection{}, err
+					}
+					term.ToEpisode = nums[0]
+					if term.FromEpisode == 0 {
+						term.FromEpisode = 1
+					}
+				} else {
+					return Selection{}, errAt(i, "expected the end of the range (e.g. S05 or E04)")
+				}
+				if term.fromKey() > term.toKey() {
+					return Selection{}, errAt(i, "range ends before it starts")
+				}
+				i++
+			}
+		}
+
+		if peek(i) == ":" {
+			i++
+			lang, ok := LanguageFromString(peek(i))
+			if !ok {
+				return Selection{}, errAt(i, "expected a language (e.g. EN or DE)")
+			}
+			term.Language = lang
+			term.HasLanguage = true
+			i++
+		}
+
+		if tok := peek(i); tok == "-" || tok == ":" {
+			return Selection{}, errAt(i, fmt.Sprintf("unexpected '%v'", tok))
+		}
+
+		res.Terms = append(res.Terms, term)
+	}
+	if len(res.Terms) == 0 {
+		return Selection{}, &SelectionError{s, 0, "empty selection"}
+	}
+	return res, nil
+}
+
+func episodeKey(season, episode int) int64 {
+	return int64(season)<<32 | int64(episode)
+}
+
+func (t SelectionTerm) fromKey() int64 {
+	return episodeKey(t.FromSeason, t.FromEpisode)
+}
+
+func (t SelectionTerm) toKey() int64 {
+	if t.ToEpisode == 0 {
+		return episodeKey(t.ToSeason, math.MaxInt32)
+	}
+	return episodeKey(t.ToSeason, t.ToEpisode)
+}
+
+func (t SelectionTerm) appliesTo(defaultLanguage, lang Language) bool {
+	if t.HasLanguage {
+		return t.Language == lang
+	}
+	return t.Exclude || lang == defaultLanguage
+}
+
+// Reports whether the term refers to exactly one episode.
+func (t SelectionTerm) single() bool {
+	return t.Kind == SelectionTermRange &&
+		t.FromEpisode != 0 && t.FromSeason == t.ToSeason && t.FromEpisode == t.ToEpisode
+}
+
+func (s Selection) onlyExclusions() bool {
+	for _, t := range s.Terms {
+		if !t.Exclude {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the languages the selection can select episodes from,
+// in order of appearance.
+func (s Selection) Languages(defaultLanguage Language) []Language {
+	var res []Language
+	add := func(lang Language) {
+		for _, v := range res {
+			if v == lang {
+				return
+			}
+		}
+		res = append(res, lang)
+	}
+	if s.onlyExclusions() {
+		add(defaultLanguage)
+	}
+	for _, t := range s.Terms {
+		if t.Exclude {
+			continue
+		}
+		if t.HasLanguage {
+			add(t.Language)
+		} else {
+			add(defaultLanguage)
+		}
+	}
+	return res
+}
+
+// Reports whether the selection can select any episode of the given
+// season, so callers only need to fetch the episodes of those seasons.
+// latestSeason is the number of the newest season in lang. If it has no
+// available episodes and SelectsLatest is true, callers need to fetch
+// earlier seasons until one has.
+func (s Selection) SelectsSeason(defaultLanguage, lang Language, season, latestSeason int) bool {
+	if s.onlyExclusions() {
+		return lang == defaultLanguage
+	}
+	for _, t := range s.Terms {
+		if t.Exclude || !t.appliesTo(defaultLanguage, lang) {
+			continue
+		}
+		switch t.Kind {
+		case SelectionTermAll:
+			return true
+		case SelectionTermLatest:
+			if season == latestSeason {
+				return true
+			}
+		case SelectionTermRange:
+			if t.FromSeason <= season && season <= t.ToSeason {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Reports whether the selection selects the newest available
+// episode of lang.
+func (s Selection) SelectsLatest(defaultLanguage, lang Language) bool {
+	for _, t := range s.Terms {
+		if t.Kind == SelectionTermLatest && !t.Exclude && t.appliesTo(defaultLanguage, lang) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the selected episodes out of episodes, sorted by language
+// (see Languages), season and episode. Unavailable episodes are only
+// selected by terms that refer to exactly that episode.
+func (s Selection) Select(defaultLanguage Language, episodes []Episode) []Episode {
+	latest := make(map[Language]int64)
+	for _, ep := range episodes {
+		k := episodeKey(ep.SeasonNumber, ep.EpisodeNumber)
+		if !ep.Unavailable && k > latest[ep.Language] {
+			latest[ep.Language] = k
+		}
+	}
+
+	matches := func(t SelectionTerm, ep Episode) bool {
+		if !t.appliesTo(defaultLanguage, ep.Language) {
+			return false
+		}
+		k := episodeKey(ep.SeasonNumber, ep.EpisodeNumber)
+		switch t.Kind {
+		case SelectionTermAll:
+			return t.Exclude || !ep.Unavailable
+		case SelectionTermLatest:
+			return k == latest[ep.Language]
+		case SelectionTermRange:
+			if k < t.fromKey() || k > t.toKey() {
+				return false
+			}
+			return t.Exclude || !ep.Unavailable || t.single()
+		}
+		return false
+	}
+
+	onlyExclusions := s.onlyExclusions()
+	var res []Episode
+	for _, ep := range episodes {
+		selected := onlyExclusions && ep.Language == defaultLanguage && !ep.Unavailable
+		for _, t := range s.Terms {
+			if matches(t, ep) {
+				selected = !t.Exclude
+			}
+		}
+		if selected {
+			res = append(res, ep)
+		}
+	}
+
+	langOrder := make(map[Language]int)
+	for i, v := range s.Languages(defaultLanguage) {
+		langOrder[v] = i
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		a, b := res[i], res[j]
+		if a.Language != b.Language {
+			return langOrder[a.Language] < langOrder[b.Language]
+		}
+		return episodeKey(a.SeasonNumber, a.EpisodeNumber) < episodeKey(b.SeasonNumber, b.EpisodeNumber)
+	})
+
+	// Remove duplicates
+	var deduped []Episode
+	for i, ep := range res {
+		if i > 0 && ep.Is(res[i-1].EpisodeMetadata) {
+			continue
+		}
+		deduped = append(deduped, ep)
+	}
+	return deduped
+}
@@ -0,0 +1,127 @@
+package southpark
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestSelection(t *testing.T) {
+	var eps []Episode
+	for _, lang := range []Language{LanguageEnglish, LanguageGerman} {
+		for season := 1; season <= 3; season++ {
+			for episode := 1; episode <= 3; episode++ {
+				eps = append(eps, Episode{EpisodeMetadata: EpisodeMetadata{
+					SeasonNumber:  season,
+					EpisodeNumber: episode,
+					Language:      lang,
+					// The newest German episode isn't out yet
+					Unavailable: lang == LanguageGerman && season == 3 && episode == 3,
+				}})
+			}
+		}
+	}
+
+	tests := []struct {
+		Expr string
+		Want string
+	}{
+		{"S02", "[S2E1EN S2E2EN S2E3EN]"},
+		{"2", "[S2E1EN S2E2EN S2E3EN]"},
+		{"1-2,!S01E02-S02E03", "[S1E1EN]"},
+		{"s2e3", "[S2E3EN]"},
+		{"S01-S02", "[S1E1EN S1E2EN S1E3EN S2E1EN S2E2EN S2E3EN]"},
+		{"S01E03-S02E01", "[S1E3EN S2E1EN]"},
+		{"S02E02-E03", "[S2E2EN S2E3EN]"},
+		{"S02-E02", "[S2E1EN S2E2EN]"},
+		{"S02E01-S02", "[S2E1EN S2E2EN S2E3EN]"},
+		{"S03E01-E03,!S03E02", "[S3E1EN S3E3EN]"},
+		{"S03 !S03E02 S03E02", "[S3E1EN S3E2EN S3E3EN]"},
+		{"!S01-S02", "[S3E1EN S3E2EN S3E3EN]"},
+		{"latest", "[S3E3EN]"},
+		{"latest:de", "[S3E2DE]"},
+		{"S03:de", "[S3E1DE S3E2DE]"},
+		// Explicitly selected unavailable episodes are kept
+		{"S03E03:de", "[S3E3DE]"},
+		{"all:de,!S01-S02", "[S3E1DE S3E2DE]"},
+		{"S01E01:de, S01E01, S01E01:en", "[S1E1DE S1E1EN]"},
+		{"S01E01 , , S01E02", "[S1E1EN S1E2EN]"},
+		{"S09", "[]"},
+	}
+	for _, tt := range tests {
+		sel, err := ParseSelection(tt.Expr)
+		if err != nil {
+			t.Errorf("%q: %v", tt.Expr, err)
+			continue
+		}
+		var got []string
+		for _, v := range sel.Select(LanguageEnglish, eps) {
+			got = append(got, fmt.Sprintf("S%vE%v%v", v.SeasonNumber, v.EpisodeNumber, v.Language.Code()))
+		}
+		if s := fmt.Sprint(got); s != tt.Want {
+			t.Errorf("%q: expected %v, got %v", tt.Expr, tt.Want, s)
+		}
+	}
+}
+
+func TestSelectionSeasons(t *testing.T) {
+	sel, err := ParseSelection("S02-S03, latest:de, !S05")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(sel.Languages(LanguageEnglish)); got != "[English German]" {
+		t.Errorf("expected languages [English German], got %v", got)
+	}
+	tests := []struct {
+		Lang   Language
+		Season int
+		Want   bool
+	}{
+		{LanguageEnglish, 1, false},
+		{LanguageEnglish, 2, true},
+		{LanguageEnglish, 3, true},
+		{LanguageEnglish, 5, false},
+		{LanguageGerman, 2, false},
+		{LanguageGerman, 5, true},
+	}
+	for _, tt := range tests {
+		if got := sel.SelectsSeason(LanguageEnglish, tt.Lang, tt.Season, 5); got != tt.Want {
+			t.Errorf("%v season %v: expected %v, got %v", tt.Lang, tt.Season, tt.Want, got)
+		}
+	}
+	if sel.SelectsLatest(LanguageEnglish, LanguageEnglish) || !sel.SelectsLatest(LanguageEnglish, LanguageGerman) {
+		t.Errorf("expected only German to select the latest episode")
+	}
+}
+
+func TestParseSelectionErrors(t *testing.T) {
+	tests := []struct {
+		Expr string
+		Pos  int
+	}{
+		{"", 0},
+		{" , ", 0},
+		{"S05E04-", 7},
+		{"S05-S04", 4},
+		{"S05E04-E02", 7},
+		{"S05:xx", 4},
+		{"S05 foo", 4},
+		{"S05;", 3},
+		{"latest-S05", 6},
+		{"S99999999999", 0},
+		{"99999999999", 0},
+		{"S01-S02E99999999999", 4},
+		{"S01E01-E99999999999", 7},
+	}
+	for _, tt := range tests {
+		_, err := ParseSelection(tt.Expr)
+		var selErr *SelectionError
+		if !errors.As(err, &selErr) {
+			t.Errorf("%q: expected SelectionError, got %v", tt.Expr, err)
+			continue
+		}
+		if selErr.Pos != tt.Pos {
+			t.Errorf("%q: expected error at %v, got %v (%v)", tt.Expr, tt.Pos, selErr.Pos, err)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package southpark
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var errSelfCheckSkipped = errors.New("skipped")
+
+type SelfCheckStep struct {
+	Name     string
+	Detail   string // Summary of the result if passed
+	Duration time.Duration
+	Err      error // nil if passed
+}
+
+func (s SelfCheckStep) Skipped() bool {
+	return errors.Is(s.Err, errSelfCheckSkipped)
+}
+
+func (s SelfCheckStep) Status() string {
+	switch {
+	case s.Err == nil:
+		return "PASS"
+	case s.Skipped():
+		return "SKIP"
+	default:
+		return "FAIL"
+	}
+}
+
+type SelfCheckReport struct {
+	Steps []SelfCheckStep
+}
+
+func (r SelfCheckReport) Passed() bool {
+	for _, v := range r.Steps {
+		if v.Err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (r SelfCheckReport) String() string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	for _, v := range r.Steps {
+		msg := v.Detail
+		if v.Err != nil {
+			msg = v.Err.Error()
+		}
+		dur := "-"
+		if !v.Skipped() {
+			dur = fmt.Sprintf("%vms", v.Duration.Milliseconds())
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", v.Status(), v.Name, dur, msg)
+	}
+	w.Flush()
+	return b.String()
+}
+
+// Runs steps, skipping those that need the result of a step that
+// didn't pass.
+type selfCheckRunner struct {
+	Report SelfCheckReport
+	OnStep func(SelfCheckStep) // May be nil
+}
+
+func (r *selfCheckRunner) step(name string, needs []string, fn func() (detail string, err error)) {
+	s := SelfCheckStep{Name: name}
+	for _, need := range needs {
+		for _, v := range r.Report.Steps {
+			if v.Name == need && v.Err != nil && s.Err == nil {
+				s.Err = fmt.Errorf("%w, needs '%v'", errSelfCheckSkipped, need)
+			}
+		}
+	}
+	if s.Err == nil {
+		start := time.Now()
+		s.Detail, s.Err = fn()
+		s.Duration = time.Since(start)
+	}
+	r.Report.Steps = append(r.Report.Steps, s)
+	if r.OnStep != nil {
+		r.OnStep(s)
+	}
+}
+
+// Runs the whole extraction chain once, so it's easy to see which part
+// broke if the website changed. Steps are skipped if a step whose
+// result they need failed. If host is empty, it is detected
+// automatically. onStep is called after each step and may be nil.
+func SelfCheck(ctx context.Context, host string, extraHosts []HostDefinition, onStep func(SelfCheckStep)) SelfCheckReport {
+	r := selfCheckRunner{OnStep: onStep}
+	step := r.step
+
+	var region RegionInfo
+	step("Region info", nil, func() (string, error) {
+		var err error
+		if host == "" {
+			region, err = GetRegionInfo(ctx, extraHosts)
+		} else {
+			region, err = GetRegionInfoForHost(host, extraHosts)
+		}
+		if err != nil {
+			return "", err
+		}
+		langs := make([]string, len(region.Languages))
+		for i, v := range region.Languages {
+			langs[i] = v.Language.String()
+		}
+		return fmt.Sprintf("%v (%v)", region.Host, strings.Join(langs, ", ")), nil
+	})
+
+	var seasons []Season
+	var seriesMGID string
+	step("Seasons", []string{"Region info"}, func() (string, error) {
+		var err error
+		seasons, seriesMGID, err = GetSeasons(ctx, region, region.Languages[0].Language)
+		if err != nil {
+			return "", err
+		}
+		if len(seasons) == 0 {
+			return "", errors.New("no seasons found")
+		}
+		return fmt.Sprintf("%v seasons, series MGID %v", len(seasons), seriesMGID), nil
+	})
+
+	var episode Episode
+	step("Episodes (first season)", []string{"Seasons"}, func() (string, error) {
+		eps, _, err := GetEpisodes(ctx, seasons[0])
+		if err != nil {
+			return "", err
+		}
+		for _, v := range eps {
+			if !v.Unavailable {
+				episode = v
+				break
+			}
+		}
+		if episode.URL == "" {
+			return "", errors.New("no available episodes found")
+		}
+		return fmt.Sprintf("%v episodes", len(eps)), nil
+	})
+
+	step("Episodes (last season)", []string{"Seasons"}, func() (string, error) {
+		eps, _, err := GetEpisodes(ctx, seasons[len(seasons)-1])
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%v episodes", len(eps)), nil
+	})
+
+	step("Search", []string{"Region info", "Seasons"}, func() (string, error) {
+		results, err := Search(ctx, region, seriesMGID, "cartman", 0, 5)
+		if err != nil {
+			return "", err
+		}
+		if len(results) == 0 {
+			return "", errors.New("no results for 'cartman'")
+		}
+		return fmt.Sprintf("%v results", len(results)), nil
+	})
+
+	var masterURL string
+	step("Media master URL", []string{"Episodes (first season)"}, func() (string, error) {
+		var err error
+		masterURL, err = getMediaMasterURL(ctx, episode)
+		if err != nil {
+			return "", err
+		}
+		u, err := url.Parse(masterURL)
+		if err != nil {
+			return "", fmt.Errorf("parse master URL: %w", err)
+		}
+		return fmt.Sprintf("S%vE%v from %v", episode.SeasonNumber, episode.EpisodeNumber, u.Host), nil
+	})
+
+	step("Master playlist", []string{"Media master URL"}, func() (string, error) {
+		master, err := parseMasterM3U8(ctx, masterURL)
+		if err != nil {
+			return "", err
+		}
+		if len(master.VideoFormats) == 0 {
+			return "", errors.New("no video formats found")
+		}
+		best := master.VideoFormats[0]
+		return fmt.Sprintf("%v formats, best %vx%v", len(master.VideoFormats), best.Width, best.Height), nil
+	})
+
+	return r.Report
+}
@@ -0,0 +1,68 @@
+package southpark
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSelfCheckReport(t *testing.T) {
+	r := SelfCheckReport{
+		Steps: []SelfCheckStep{
+			{Name: "Region info", Detail: "www.southpark.de (English, German)", Duration: 120 * time.Millisecond},
+			{Name: "Seasons", Duration: 1500 * time.Millisecond, Err: errors.New("'MainContainer > SeasonSelector': not found")},
+			{Name: "Search", Err: errSelfCheckSkipped},
+		},
+	}
+	if r.Passed() {
+		t.Errorf("expected report not to pass")
+	}
+
+	lines := strings.Split(strings.TrimSuffix(r.String(), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 lines, got %v", len(lines))
+	}
+	for i, want := range []string{
+		"PASS  Region info  120ms   www.southpark.de (English, German)",
+		"FAIL  Seasons      1500ms  'MainContainer > SeasonSelector': not found",
+		"SKIP  Search       -       " + errSelfCheckSkipped.Error(),
+	} {
+		if lines[i] != want {
+			t.Errorf("line %v: expected\n%q\ngot\n%q", i, want, lines[i])
+		}
+	}
+
+	r.Steps = r.Steps[:1]
+	if !r.Passed() {
+		t.Errorf("expected report to pass")
+	}
+}
+
+func TestSelfCheckSkipsDependents(t *testing.T) {
+	var r selfCheckRunner
+	var ran []string
+	step := func(name string, needs []string, err error) {
+		r.step(name, needs, func() (string, error) {
+			ran = append(ran, name)
+			return "", err
+		})
+	}
+	step("A", nil, nil)
+	step("B", []string{"A"}, errors.New("fail"))
+	step("C", []string{"A"}, nil)
+	step("D", []string{"B"}, nil)
+	step("E", []string{"D"}, nil)
+
+	if got := strings.Join(ran, ","); got != "A,B,C" {
+		t.Errorf("expected A, B and C to run, got %v", got)
+	}
+	for i, want := range []string{"PASS", "FAIL", "PASS", "SKIP", "SKIP"} {
+		if got := r.Report.Steps[i].Status(); got != want {
+			t.Errorf("step %v: expected %v, got %v", r.Report.Steps[i].Name, want, got)
+		}
+	}
+	if msg := r.Report.Steps[4].Err.Error(); msg != "skipped, needs 'D'" {
+		t.Errorf("unexpected skip message: %v", msg)
+	}
+}
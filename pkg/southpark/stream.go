@@ -2,8 +2,6 @@ package southpark
 
 import (
 	"context"
-	"crypto/aes"
-	"crypto/cipher"
 	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
@@ -11,6 +9,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"reflect"
 	"sort"
 	"strconv"
@@ -33,9 +32,24 @@ func (e *VideoServiceError) Error() string {
 	return fmt.Sprintf("%s (%s)", e.FriendlyMessage, e.Diagnostics)
 }
 
-var videoServiceErrorMessages = map[string]string{
-	"60101": "video is not found or no longer available due to date or rights restrictions",
-	"60504": "video is not available from your location",
+type videoServiceErrorInfo struct {
+	Message string
+	Err     error // Sentinel error matched by VideoServiceError.Is
+}
+
+// Indexed by diagnostics code
+var videoServiceErrorMessages = map[string]videoServiceErrorInfo{
+	"60101": {"video is not found or no longer available due to date or rights restrictions", ErrEpisodeLocked},
+	"60102": {"video is not available yet", ErrEpisodeLocked},
+	"60103": {"video has expired", ErrEpisodeLocked},
+	"60401": {"video requires signing in with a TV provider", ErrEpisodeLocked},
+	"60502": {"video is not available through a VPN or proxy", ErrGeoBlocked},
+	"60504": {"video is not available from your location", ErrGeoBlocked},
+}
+
+func (e *VideoServiceError) Is(target error) bool {
+	info, ok := videoServiceErrorMessages[e.Diagnostics]
+	return ok && target == info.Err
 }
 
 // Processes strings like METHOD=AES-128,URI="https://.../",IV=0xDEADBEEF
@@ -130,38 +144,28 @@ func getExtM3UInfo(data string, v any) error {
 	return nil
 }
 
-func downloadAndDecryptAES128Segment(ctx context.Context, url string, key []byte, segmentIdx int) ([]byte, error) {
-	data, err := httputils.GetBodyWithContext(ctx, url)
+// The returned reader decrypts the segment while it's being downloaded.
+// Must be closed by the caller.
+func getAES128SegmentReader(ctx context.Context, url string, key []byte, iv []byte) (io.ReadCloser, error) {
+	resp, err := httputils.GetWithContext(ctx, url)
 	if err != nil {
 		return nil, fmt.Errorf("get AES128 encrypted segment: %w", err)
 	}
-
-	if len(data) < 16 {
-		return nil, fmt.Errorf("cipher data too short")
-	}
-	var iv [16]byte
-	{
-		// https://github.com/FFmpeg/FFmpeg/blob/d7924a4f60f2088de1e6790345caba929eb97030/libavformat/hls.c#L882
-		startSeq := 1 // #EXT-X-MEDIA-SEQUENCE
-		binary.BigEndian.PutUint64(iv[8:], uint64(startSeq+segmentIdx))
+	if err := httputils.CheckStatus(resp); err != nil {
+		resp.Body.Close()
+		return nil, fmt.Errorf("get AES128 encrypted segment: %w", err)
 	}
 
-	block, err := aes.NewCipher(key)
+	r, err := newCBCDecryptReader(ioutils.NewCtxReader(ctx, resp.Body), key, iv)
 	if err != nil {
-		return nil, fmt.Errorf("aes.NewCipher: %w", err)
+		resp.Body.Close()
+		return nil, err
 	}
 
-	mode := cipher.NewCBCDecrypter(block, iv[:])
-
-	// Make sure encrypted data length is a multiple of AES block size
-	if len(data)%aes.BlockSize != 0 {
-		return nil, fmt.Errorf("encrypted data length (%v) is not a multiple of AES block size (%v)", len(data), aes.BlockSize)
-	}
-
-	// Decrypt data
-	mode.CryptBlocks(data, data)
-
-	return data, nil
+	return struct {
+		io.Reader
+		io.Closer
+	}{r, resp.Body}, nil
 }
 
 type videoServiceDoc struct {
@@ -182,25 +186,45 @@ type videoServiceDoc struct {
 	} `json:"error"`
 }
 
-func getMediaMasterURL(ctx context.Context, url string) (string, error) {
-	var videoServiceURL string
-	{
-		data, err := getWebsiteDataFromURL(ctx, url)
-		if err != nil {
-			return "", fmt.Errorf("get episode website data: %w", err)
-		}
-		for _, v := range data.Children {
-			if v.HandleTVEAuthRedirection != nil {
-				videoServiceURL = v.HandleTVEAuthRedirection.VideoDetail.VideoServiceURL
-				break
-			}
-		}
-		if cutURL, _, found := strings.Cut(videoServiceURL, "?"); found {
-			videoServiceURL = cutURL
-		} else {
-			return "", fmt.Errorf("video service URL does not contain a query: '%v'", videoServiceURL)
-		}
-		videoServiceURL += "?clientPlatform=desktop"
+func getMediaMasterURL(ctx context.Context, e Episode) (string, error) {
+	videoServiceURL, err := extractWithFallbacks(ctx,
+		extractionStrategy[string]{
+			Name: "embedded-json",
+			Extract: func(ctx context.Context) (string, error) {
+				data, err := getWebsiteDataFromURL(ctx, e.URL)
+				if err != nil {
+					return "", fmt.Errorf("get episode website data: %w", err)
+				}
+				var videoServiceURL string
+				for _, v := range data.Children {
+					if v.HandleTVEAuthRedirection != nil {
+						videoServiceURL = v.HandleTVEAuthRedirection.VideoDetail.VideoServiceURL
+						break
+					}
+				}
+				if videoServiceURL == "" {
+					return "", selectorError("handleTVEAuthRedirection.videoDetail.videoServiceUrl", errSelectorNotFound)
+				}
+				cutURL, _, found := strings.Cut(videoServiceURL, "?")
+				if !found {
+					return "", selectorError("handleTVEAuthRedirection.videoDetail.videoServiceUrl", fmt.Errorf("URL does not contain a query: '%v'", videoServiceURL))
+				}
+				return cutURL + "?clientPlatform=desktop", nil
+			},
+		},
+		extractionStrategy[string]{
+			// The video service URL only depends on the episode's MGID
+			Name: "mgid-feed",
+			Extract: func(ctx context.Context) (string, error) {
+				if e.MGID == "" {
+					return "", errors.New("episode has no MGID")
+				}
+				return fmt.Sprintf("https://topaz.viacomcbs.digital/topaz/api/%v/mica.json?clientPlatform=desktop", e.MGID), nil
+			},
+		},
+	)
+	if err != nil {
+		return "", fmt.Errorf("get video service URL: %w", err)
 	}
 
 	dataJSON, err := httputils.GetBodyWithContext(ctx, videoServiceURL)
@@ -215,8 +239,8 @@ func getMediaMasterURL(ctx context.Context, url string) (string, error) {
 	}
 	if data.Error.Diagnostics != "" {
 		err := &VideoServiceError{Diagnostics: data.Error.Diagnostics, ErrorMessage: data.Error.ErrorMessage}
-		if msg, ok := videoServiceErrorMessages[data.Error.Diagnostics]; ok {
-			err.FriendlyMessage = msg
+		if info, ok := videoServiceErrorMessages[data.Error.Diagnostics]; ok {
+			err.FriendlyMessage = info.Message
 		}
 		return "", err
 	}
@@ -224,14 +248,6 @@ func getMediaMasterURL(ctx context.Context, url string) (string, error) {
 	return data.Stitchedstream.Source, nil
 }
 
-func uriPrefixFromMasterURL(masterURL string) (string, error) {
-	if s, _, found := strings.Cut(masterURL, "/master.m3u8?"); found {
-		return s + "/", nil
-	} else {
-		return "", errors.New("invalid master URL: does not point to master.m3u8")
-	}
-}
-
 type HLSFormat struct {
 	AverageBandwidth uint
 	FrameRate        float32
@@ -242,6 +258,45 @@ type HLSFormat struct {
 	URI              string
 }
 
+// Returns CodecUnknown if the CODECS attribute doesn't name a video codec.
+func (f HLSFormat) VideoCodec() Codec {
+	for _, v := range ParseCodecs(f.Codecs) {
+		if v.IsVideo() {
+			return v
+		}
+	}
+	return CodecUnknown
+}
+
+// Returns CodecUnknown if the CODECS attribute doesn't name an audio codec.
+func (f HLSFormat) AudioCodec() Codec {
+	for _, v := range ParseCodecs(f.Codecs) {
+		if v.IsAudio() {
+			return v
+		}
+	}
+	return CodecUnknown
+}
+
+// Keeps the order of fmts. Formats without a CODECS attribute are assumed
+// to be H.264, which is what the service has always delivered.
+func FilterFormatsByVideoCodec(fmts []HLSFormat, codecs ...Codec) []HLSFormat {
+	var res []HLSFormat
+	for _, f := range fmts {
+		c := f.VideoCodec()
+		if c == CodecUnknown && f.Codecs == "" {
+			c = CodecH264
+		}
+		for _, v := range codecs {
+			if c == v {
+				res = append(res, f)
+				break
+			}
+		}
+	}
+	return res
+}
+
 type HLSMaster struct {
 	AudioURI     string
 	SubsURI      string
@@ -258,7 +313,9 @@ func parseMasterM3U8(ctx context.Context, url string) (HLSMaster, error) {
 
 	var res HLSMaster
 	var format HLSFormat
+	inStreamInf := false // Only URIs following #EXT-X-STREAM-INF are variants
 	for _, line := range lines {
+		line = strings.TrimSuffix(line, "\r")
 		if mediaInfoStr, found := cutPrefix(line, "#EXT-X-MEDIA:"); found {
 			var mediaInfo struct {
 				Type       string
@@ -298,7 +355,7 @@ func parseMasterM3U8(ctx context.Context, url string) (HLSMaster, error) {
 			format.AverageBandwidth = uint(streamInfo.AverageBandwidth)
 			format.FrameRate = streamInfo.FrameRate
 			format.Codecs = streamInfo.Codecs
-			{
+			if streamInfo.Resolution != "" {
 				sp := strings.SplitN(streamInfo.Resolution, "x", 2)
 				if len(sp) != 2 {
 					return HLSMaster{}, errors.New("invalid resolution format in EXT-X-STREAM-INF")
@@ -315,10 +372,12 @@ func parseMasterM3U8(ctx context.Context, url string) (HLSMaster, error) {
 				format.Height = uint(h)
 			}
 			format.Bandwidth = uint(streamInfo.Bandwidth)
-		} else if line != "" && !strings.HasPrefix(line, "#") {
+			inStreamInf = true
+		} else if inStreamInf && line != "" && !strings.HasPrefix(line, "#") {
 			format.URI = line
 			res.VideoFormats = append(res.VideoFormats, format)
 			format = HLSFormat{}
+			inStreamInf = false
 		}
 	}
 
@@ -333,6 +392,7 @@ func parseMasterM3U8(ctx context.Context, url string) (HLSMaster, error) {
 type HLSStreamKey struct {
 	Method string
 	Key    []byte
+	IV     []byte // nil if the IV is derived from the media sequence number
 }
 
 type HLSStreamSegment struct {
@@ -341,12 +401,29 @@ type HLSStreamSegment struct {
 }
 
 type HLSStream struct {
-	Key      *HLSStreamKey
-	Segments []HLSStreamSegment
+	Key           *HLSStreamKey // nil if unencrypted
+	MediaSequence int
+	Segments      []HLSStreamSegment
 }
 
-func getHLSStream(ctx context.Context, uriPrefix, hlsURI string) (HLSStream, error) {
-	body, err := httputils.GetBodyWithContext(ctx, uriPrefix+hlsURI)
+func resolveURL(base *url.URL, ref string) (string, error) {
+	refURL, err := url.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	return base.ResolveReference(refURL).String(), nil
+}
+
+// Relative URIs in the playlist are resolved against playlistURL.
+// defaultMediaSequence is used if the playlist has no
+// #EXT-X-MEDIA-SEQUENCE tag.
+func getHLSStream(ctx context.Context, playlistURL string, defaultMediaSequence int) (HLSStream, error) {
+	baseURL, err := url.Parse(playlistURL)
+	if err != nil {
+		return HLSStream{}, fmt.Errorf("parse playlist URL: %w", err)
+	}
+
+	body, err := httputils.GetBodyWithContext(ctx, playlistURL)
 	if err != nil {
 		return HLSStream{}, fmt.Errorf("get stream HLS playlist: %w", err)
 	}
@@ -355,32 +432,43 @@ func getHLSStream(ctx context.Context, uriPrefix, hlsURI string) (HLSStream, err
 	var keyInfo struct {
 		Method string
 		URI    string
+		IV     []byte
 	}
 
 	var duration float64 = 0
+	mediaSequence := defaultMediaSequence
 	var segments []HLSStreamSegment
 	var key []byte
 	for _, line := range lines {
+		line = strings.TrimSuffix(line, "\r")
 		if keyInfoStr, found := cutPrefix(line, "#EXT-X-KEY:"); found {
 			// Parse key info
+			keyInfo.IV = nil
 			err := getExtM3UInfo(keyInfoStr, &keyInfo)
 			if err != nil {
 				return HLSStream{}, fmt.Errorf("getExtM3UInfo: %w", err)
 			}
 
-			// Each URI has <uriPrefix>/<the same starting sequence>/<filename>.
-			// This starting sequence isn't included with the key, so we get it here.
-			keyPfx, _, found := strings.Cut(hlsURI, "/")
-			if !found {
-				return HLSStream{}, fmt.Errorf("malformed HLS URI: missing '/': '%v'", hlsURI)
+			if keyInfo.Method == "NONE" {
+				key = nil
+				continue
+			}
+
+			keyURL, err := resolveURL(baseURL, keyInfo.URI)
+			if err != nil {
+				return HLSStream{}, fmt.Errorf("parse key URI: %w", err)
 			}
-			keyPfx += "/"
 
 			// Download key
-			key, err = httputils.GetBodyWithContext(ctx, uriPrefix+keyPfx+keyInfo.URI)
+			key, err = httputils.GetBodyWithContext(ctx, keyURL)
 			if err != nil {
 				return HLSStream{}, fmt.Errorf("get decryption key: %w", err)
 			}
+		} else if seqStr, found := cutPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"); found {
+			mediaSequence, err = strconv.Atoi(strings.TrimSpace(seqStr))
+			if err != nil {
+				return HLSStream{}, fmt.Errorf("parse media sequence number: %w", err)
+			}
 		} else if infoStr, found := cutPrefix(line, "#EXTINF:"); found {
 			var err error
 			sp := strings.Split(infoStr, ",")
@@ -392,12 +480,16 @@ func getHLSStream(ctx context.Context, uriPrefix, hlsURI string) (HLSStream, err
 			if err != nil {
 				return HLSStream{}, fmt.Errorf("parse segment duration: %w", err)
 			}
-		} else if strings.HasPrefix(line, "https://") {
+		} else if line != "" && !strings.HasPrefix(line, "#") {
 			if duration == 0 {
 				return HLSStream{}, errors.New("no segment duration found")
 			}
+			segURL, err := resolveURL(baseURL, line)
+			if err != nil {
+				return HLSStream{}, fmt.Errorf("parse segment URI: %w", err)
+			}
 			segments = append(segments, HLSStreamSegment{
-				URL:      line,
+				URL:      segURL,
 				Duration: duration,
 			})
 			duration = 0
@@ -405,13 +497,15 @@ func getHLSStream(ctx context.Context, uriPrefix, hlsURI string) (HLSStream, err
 	}
 
 	res := HLSStream{
-		Segments: segments,
+		MediaSequence: mediaSequence,
+		Segments:      segments,
 	}
 
 	if key != nil {
 		res.Key = &HLSStreamKey{
 			Method: keyInfo.Method,
 			Key:    key,
+			IV:     keyInfo.IV,
 		}
 	}
 
@@ -419,59 +513,121 @@ func getHLSStream(ctx context.Context, uriPrefix, hlsURI string) (HLSStream, err
 }
 
 type EpisodeStream struct {
-	Video HLSStream
-	Audio HLSStream
-	Subs  HLSStream // subs are not available if len(Subs.Segments) == 0
+	VideoFormat HLSFormat
+	Video       HLSStream
+	Audio       HLSStream
+	Subs        HLSStream // subs are not available if len(Subs.Segments) == 0
 }
 
 func GetEpisodeStream(ctx context.Context, e Episode, selectFormat func([]HLSFormat) (HLSFormat, error)) (EpisodeStream, error) {
-	mediaMasterURL, err := getMediaMasterURL(ctx, e.URL)
+	mediaMasterURL, err := getMediaMasterURL(ctx, e)
 	if err != nil {
 		return EpisodeStream{}, fmt.Errorf("getMediaMasterURL: %w", err)
 	}
 
-	uriPrefix, err := uriPrefixFromMasterURL(mediaMasterURL)
+	res, err := getHLSStreams(ctx, mediaMasterURL, selectFormat, episodeMediaSequence)
 	if err != nil {
 		return EpisodeStream{}, err
 	}
 
-	hlsMaster, err := parseMasterM3U8(ctx, mediaMasterURL)
-	if err != nil {
-		return EpisodeStream{}, fmt.Errorf("parseMasterM3U8: %w", err)
+	if len(res.Audio.Segments) == 0 {
+		return EpisodeStream{}, fmt.Errorf("master M3U8 does not contain an audio track")
 	}
 
-	if len(hlsMaster.VideoFormats) == 0 || hlsMaster.AudioURI == "" {
-		return EpisodeStream{}, fmt.Errorf("master M3U8 does not contain a video or audio track")
+	return res, nil
+}
+
+func checkStreamEncryption(s HLSStream, name string) error {
+	if s.Key != nil && s.Key.Method != "AES-128" {
+		return fmt.Errorf("unable to decrypt %v with method '%v', only AES-128 decryption is supported", name, s.Key.Method)
 	}
+	return nil
+}
+
+// Gets the streams of any HLS master playlist. Streams may be unencrypted or
+// AES-128 encrypted. If there's no separate audio rendition, the audio is
+// expected to be muxed into the video stream and the returned Audio stream
+// has no segments. A media playlist URL is accepted as well, in which case
+// it is treated as the only video format.
+func GetHLSStream(ctx context.Context, masterURL string, selectFormat func([]HLSFormat) (HLSFormat, error)) (EpisodeStream, error) {
+	// The first segment's sequence number defaults to 0 per the HLS spec
+	return getHLSStreams(ctx, masterURL, selectFormat, 0)
+}
 
-	videoFormat, err := selectFormat(hlsMaster.VideoFormats)
+// The sequence number of the first segment of episode media playlists
+// without #EXT-X-MEDIA-SEQUENCE. Episodes have always been decrypted
+// with segments numbered from 1, unlike the HLS spec's 0.
+const episodeMediaSequence = 1
+
+// See GetHLSStream. defaultMediaSequence is used for media playlists
+// without #EXT-X-MEDIA-SEQUENCE.
+func getHLSStreams(ctx context.Context, masterURL string, selectFormat func([]HLSFormat) (HLSFormat, error), defaultMediaSequence int) (EpisodeStream, error) {
+	baseURL, err := url.Parse(masterURL)
 	if err != nil {
-		return EpisodeStream{}, fmt.Errorf("selectFormat: %w", err)
+		return EpisodeStream{}, fmt.Errorf("parse master URL: %w", err)
 	}
 
-	videoStream, err := getHLSStream(ctx, uriPrefix, videoFormat.URI)
+	hlsMaster, err := parseMasterM3U8(ctx, masterURL)
 	if err != nil {
-		return EpisodeStream{}, fmt.Errorf("get video HLS stream: %w", err)
+		return EpisodeStream{}, fmt.Errorf("parseMasterM3U8: %w", err)
 	}
-	if videoStream.Key == nil || videoStream.Key.Method != "AES-128" {
-		return EpisodeStream{}, fmt.Errorf("unable to decrypt video with method '%v', only AES-128 decryption is supported", videoStream.Key.Method)
+
+	var videoFormat HLSFormat
+	var videoURL string
+	if len(hlsMaster.VideoFormats) == 0 {
+		// Not a master playlist
+		videoURL = masterURL
+	} else {
+		videoFormat, err = selectFormat(hlsMaster.VideoFormats)
+		if err != nil {
+			return EpisodeStream{}, fmt.Errorf("selectFormat: %w", err)
+		}
+		videoURL, err = resolveURL(baseURL, videoFormat.URI)
+		if err != nil {
+			return EpisodeStream{}, fmt.Errorf("parse video URI: %w", err)
+		}
 	}
 
-	audioStream, err := getHLSStream(ctx, uriPrefix, hlsMaster.AudioURI)
+	videoStream, err := getHLSStream(ctx, videoURL, defaultMediaSequence)
 	if err != nil {
-		return EpisodeStream{}, fmt.Errorf("get audio HLS stream: %w", err)
+		return EpisodeStream{}, fmt.Errorf("get video HLS stream: %w", err)
 	}
-	if audioStream.Key == nil || audioStream.Key.Method != "AES-128" {
-		return EpisodeStream{}, fmt.Errorf("unable to decrypt audio with method '%v', only AES-128 decryption is supported", videoStream.Key.Method)
+	if len(videoStream.Segments) == 0 {
+		return EpisodeStream{}, fmt.Errorf("HLS playlist does not contain any video segments")
+	}
+	if err := checkStreamEncryption(videoStream, "video"); err != nil {
+		return EpisodeStream{}, err
 	}
 
 	res := EpisodeStream{
-		Video: videoStream,
-		Audio: audioStream,
+		VideoFormat: videoFormat,
+		Video:       videoStream,
+	}
+
+	if hlsMaster.AudioURI != "" {
+		audioURL, err := resolveURL(baseURL, hlsMaster.AudioURI)
+		if err != nil {
+			return EpisodeStream{}, fmt.Errorf("parse audio URI: %w", err)
+		}
+		audioStream, err := getHLSStream(ctx, audioURL, defaultMediaSequence)
+		if err != nil {
+			return EpisodeStream{}, fmt.Errorf("get audio HLS stream: %w", err)
+		}
+		if err := checkStreamEncryption(audioStream, "audio"); err != nil {
+			return EpisodeStream{}, err
+		}
+		if len(audioStream.Segments) != len(videoStream.Segments) {
+			return EpisodeStream{}, fmt.Errorf("number of audio segments (%v) and video segments (%v) doesn't match", len(audioStream.Segments), len(videoStream.Segments))
+		}
+		res.Audio = audioStream
 	}
 
 	if hlsMaster.SubsURI != "" {
-		subsStream, err := getHLSStream(ctx, uriPrefix, hlsMaster.SubsURI)
+		subsURL, err := resolveURL(baseURL, hlsMaster.SubsURI)
+		if err != nil {
+			return EpisodeStream{}, fmt.Errorf("parse subtitle URI: %w", err)
+		}
+		subsStream, err := getHLSStream(ctx, subsURL, defaultMediaSequence)
 		if err != nil {
 			return EpisodeStream{}, fmt.Errorf("get subtitle HLS stream: %w", err)
 		}
@@ -490,24 +646,52 @@ func DownloadEpisodeStream(
 	stream EpisodeStream,
 	startSegment int,
 	totalSegmentIdxCallback func(segmentIdx int),
-	videoCallback func(data []byte, videoSegmentIdx int) error,
-	audioCallback func(data []byte, audioSegmentIdx int) error,
-	subsCallback func(data []byte, subsSegmentIdx int) error,
+	videoCallback func(r io.Reader, videoSegmentIdx int) error,
+	audioCallback func(r io.Reader, audioSegmentIdx int) error,
+	subsCallback func(r io.Reader, subsSegmentIdx int) error,
 ) error {
 	segmentIndex := startSegment
 	segmentOffset := 0
 
 	totalSegmentIdxCallback(segmentIndex)
 
+	downloadSegment := func(hls HLSStream, relSegIdx int, callback func(io.Reader, int) error) error {
+		seg := hls.Segments[relSegIdx]
+		var r io.ReadCloser
+		if hls.Key == nil {
+			resp, err := httputils.GetWithContext(ctx, seg.URL)
+			if err != nil {
+				return err
+			}
+			if err := httputils.CheckStatus(resp); err != nil {
+				resp.Body.Close()
+				return err
+			}
+			r = struct {
+				io.Reader
+				io.Closer
+			}{ioutils.NewCtxReader(ctx, resp.Body), resp.Body}
+		} else {
+			iv := hls.Key.IV
+			if iv == nil {
+				// https://github.com/FFmpeg/FFmpeg/blob/d7924a4f60f2088de1e6790345caba929eb97030/libavformat/hls.c#L882
+				iv = make([]byte, 16)
+				binary.BigEndian.PutUint64(iv[8:], uint64(hls.MediaSequence+relSegIdx))
+			}
+			var err error
+			r, err = getAES128SegmentReader(ctx, seg.URL, hls.Key.Key, iv)
+			if err != nil {
+				return fmt.Errorf("getAES128SegmentReader: %w", err)
+			}
+		}
+		defer r.Close()
+		return callback(r, relSegIdx)
+	}
+
 	for segmentIndex-segmentOffset < len(stream.Video.Segments) {
 		relSegIdx := segmentIndex - segmentOffset
-		seg := stream.Video.Segments[relSegIdx]
-		data, err := downloadAndDecryptAES128Segment(ctx, seg.URL, stream.Video.Key.Key, relSegIdx)
-		if err != nil {
-			return fmt.Errorf("downloadAndDecryptAES128Segment (video): %w", err)
-		}
-		if err := videoCallback(data, relSegIdx); err != nil {
-			return fmt.Errorf("videoCallback: %w", err)
+		if err := downloadSegment(stream.Video, relSegIdx, videoCallback); err != nil {
+			return fmt.Errorf("download video segment: %w", err)
 		}
 		segmentIndex++
 		totalSegmentIdxCallback(segmentIndex)
@@ -516,13 +700,8 @@ func DownloadEpisodeStream(
 
 	for segmentIndex-segmentOffset < len(stream.Audio.Segments) {
 		relSegIdx := segmentIndex - segmentOffset
-		seg := stream.Audio.Segments[relSegIdx]
-		data, err := downloadAndDecryptAES128Segment(ctx, seg.URL, stream.Audio.Key.Key, relSegIdx)
-		if err != nil {
-			return fmt.Errorf("downloadAndDecryptAES128Segment (audio): %w", err)
-		}
-		if err := audioCallback(data, relSegIdx); err != nil {
-			return fmt.Errorf("audioCallback: %w", err)
+		if err := downloadSegment(stream.Audio, relSegIdx, audioCallback); err != nil {
+			return fmt.Errorf("download audio segment: %w", err)
 		}
 		segmentIndex++
 		totalSegmentIdxCallback(segmentIndex)
@@ -533,7 +712,6 @@ func DownloadEpisodeStream(
 		relSegIdx := segmentIndex - segmentOffset
 		seg := stream.Subs.Segments[relSegIdx]
 
-		var data []byte
 		if err := func() error {
 			resp, err := httputils.GetWithContext(ctx, seg.URL)
 			if err != nil {
@@ -544,24 +722,16 @@ func DownloadEpisodeStream(
 			if resp.StatusCode == http.StatusBadGateway {
 				// HACK: A 502 happens on S8E10 for a part of the subtitles.
 				// In that case, just write empty subs.
-			} else if resp.StatusCode != http.StatusOK {
-				return fmt.Errorf("HTTP error: %v", resp.Status)
-			} else {
-				var err error
-				data, err = io.ReadAll(ioutils.NewCtxReader(ctx, resp.Body))
-				if err != nil {
-					return fmt.Errorf("io.ReadAll: %w", err)
-				}
+				return subsCallback(strings.NewReader(""), relSegIdx)
+			} else if err := httputils.CheckStatus(resp); err != nil {
+				return err
 			}
 
-			return nil
+			return subsCallback(ioutils.NewCtxReader(ctx, resp.Body), relSegIdx)
 		}(); err != nil {
-			return fmt.Errorf("download subtitle segment: get '%v': %w", seg.URL, err)
+			return fmt.Errorf("download subtitle segment: %w", err)
 		}
 
-		if err := subsCallback(data, relSegIdx); err != nil {
-			return fmt.Errorf("subsCallback: %w", err)
-		}
 		segmentIndex++
 		totalSegmentIdxCallback(segmentIndex)
 	}
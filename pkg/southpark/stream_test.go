@@ -0,0 +1,206 @@
+package southpark
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestFileServer(files map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(data))
+	}))
+}
+
+func TestGetHLSStream(t *testing.T) {
+	srv := newTestFileServer(map[string]string{
+		"/master.m3u8": "#EXTM3U\r\n" +
+			"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"a\",NAME=\"en\",AUTOSELECT=YES,URI=\"audio/index.m3u8\"\r\n" +
+			"#EXT-X-STREAM-INF:BANDWIDTH=1000,CODECS=\"avc1.64001f,mp4a.40.2\",AUDIO=\"a\"\r\n" +
+			"low/index.m3u8\r\n" +
+			"#EXT-X-STREAM-INF:BANDWIDTH=5000,RESOLUTION=1920x1080,AUDIO=\"a\"\r\n" +
+			"/hls/high/index.m3u8\r\n",
+		"/hls/high/index.m3u8": "#EXTM3U\n" +
+			"#EXT-X-MEDIA-SEQUENCE:7\n" +
+			"#EXT-X-KEY:METHOD=AES-128,URI=\"../key.bin\",IV=0x000102030405060708090a0b0c0d0e0f\n" +
+			"#EXTINF:4.0,\n" +
+			"seg0.ts\n" +
+			"#EXTINF:2.5,\n" +
+			"https://cdn.example.com/seg1.ts\n" +
+			"#EXT-X-ENDLIST\n",
+		"/audio/index.m3u8": "#EXTM3U\n" +
+			"#EXTINF:4.0,\n" +
+			"a0.aac\n" +
+			"#EXTINF:2.5,\n" +
+			"a1.aac\n",
+		"/hls/key.bin": "0123456789abcdef",
+	})
+	defer srv.Close()
+
+	var gotFormats []HLSFormat
+	stream, err := GetHLSStream(context.Background(), srv.URL+"/master.m3u8", func(fmts []HLSFormat) (HLSFormat, error) {
+		gotFormats = fmts
+		return fmts[0], nil
+	})
+	if err != nil {
+		t.Fatalf("GetHLSStream: %v", err)
+	}
+
+	if len(gotFormats) != 2 || gotFormats[0].Height != 1080 || gotFormats[1].Height != 0 {
+		t.Errorf("unexpected formats: %+v", gotFormats)
+	}
+
+	v := stream.Video
+	if v.Key == nil || v.Key.Method != "AES-128" || string(v.Key.Key) != "0123456789abcdef" {
+		t.Fatalf("unexpected video key: %+v", v.Key)
+	}
+	if len(v.Key.IV) != 16 || v.Key.IV[15] != 0x0f {
+		t.Errorf("unexpected IV: %x", v.Key.IV)
+	}
+	if v.MediaSequence != 7 {
+		t.Errorf("expected media sequence 7, got %v", v.MediaSequence)
+	}
+	wantSegs := []HLSStreamSegment{
+		{Duration: 4.0, URL: srv.URL + "/hls/high/seg0.ts"},
+		{Duration: 2.5, URL: "https://cdn.example.com/seg1.ts"},
+	}
+	if len(v.Segments) != len(wantSegs) {
+		t.Fatalf("expected %v video segments, got %v", len(wantSegs), len(v.Segments))
+	}
+	for i, want := range wantSegs {
+		if v.Segments[i] != want {
+			t.Errorf("video segment %v: expected %+v, got %+v", i, want, v.Segments[i])
+		}
+	}
+
+	if stream.Audio.Key != nil {
+		t.Errorf("expected unencrypted audio")
+	}
+	if len(stream.Audio.Segments) != 2 || stream.Audio.Segments[1].URL != srv.URL+"/audio/a1.aac" {
+		t.Errorf("unexpected audio segments: %+v", stream.Audio.Segments)
+	}
+}
+
+func TestGetHLSStreamMediaPlaylist(t *testing.T) {
+	srv := newTestFileServer(map[string]string{
+		"/media.m3u8": "#EXTM3U\n" +
+			"#EXTINF:6,\n" +
+			"seg0.ts\n" +
+			"#EXTINF:6,\n" +
+			"seg1.ts\n",
+		"/seg0.ts": "SEGMENT0",
+	})
+	defer srv.Close()
+
+	stream, err := GetHLSStream(context.Background(), srv.URL+"/media.m3u8", func(fmts []HLSFormat) (HLSFormat, error) {
+		t.Errorf("selectFormat called for media playlist")
+		return HLSFormat{}, nil
+	})
+	if err != nil {
+		t.Fatalf("GetHLSStream: %v", err)
+	}
+	if len(stream.Video.Segments) != 2 || len(stream.Audio.Segments) != 0 {
+		t.Fatalf("unexpected stream: %+v", stream)
+	}
+
+	var got []byte
+	if err := DownloadEpisodeStream(context.Background(), stream, 0, func(int) {},
+		func(r io.Reader, idx int) error {
+			if idx == 0 {
+				var err error
+				got, err = io.ReadAll(r)
+				return err
+			}
+			_, err := io.Copy(io.Discard, r)
+			return err
+		}, nil, nil,
+	); err == nil {
+		t.Errorf("expected error for missing segment")
+	}
+	if !bytes.Equal(got, []byte("SEGMENT0")) {
+		t.Errorf("expected unencrypted segment data, got '%s'", got)
+	}
+}
+
+func TestGetHLSStreamUnsupportedEncryption(t *testing.T) {
+	srv := newTestFileServer(map[string]string{
+		"/media.m3u8": "#EXTM3U\n" +
+			"#EXT-X-KEY:METHOD=SAMPLE-AES,URI=\"key.bin\"\n" +
+			"#EXTINF:6,\n" +
+			"seg0.ts\n",
+		"/key.bin": "0123456789abcdef",
+	})
+	defer srv.Close()
+
+	if _, err := GetHLSStream(context.Background(), srv.URL+"/media.m3u8", nil); err == nil {
+		t.Errorf("expected error for SAMPLE-AES encryption")
+	}
+}
+
+func TestDownloadEpisodeStreamSequenceIV(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	// Without an IV attribute the IV is the sequence number
+	seqIV := func(n int) []byte {
+		iv := make([]byte, 16)
+		iv[15] = byte(n)
+		return iv
+	}
+	tests := []struct {
+		Name      string
+		GetStream func(ctx context.Context, url string) (EpisodeStream, error)
+		FirstSeq  int // Without EXT-X-MEDIA-SEQUENCE
+	}{
+		{"HLS URL", func(ctx context.Context, url string) (EpisodeStream, error) {
+			return GetHLSStream(ctx, url, nil)
+		}, 0},
+		{"episode", func(ctx context.Context, url string) (EpisodeStream, error) {
+			return getHLSStreams(ctx, url, nil, episodeMediaSequence)
+		}, 1},
+	}
+	for _, tt := range tests {
+		srv := newTestFileServer(map[string]string{
+			"/media.m3u8": "#EXTM3U\n" +
+				"#EXT-X-KEY:METHOD=AES-128,URI=\"key.bin\"\n" +
+				"#EXTINF:6,\n" +
+				"seg0.ts\n" +
+				"#EXTINF:6,\n" +
+				"seg1.ts\n",
+			"/key.bin": string(key),
+			"/seg0.ts": string(encryptTestData(t, []byte("SEGMENT0 SEGMENT0 SEGMENT0"), key, seqIV(tt.FirstSeq))),
+			"/seg1.ts": string(encryptTestData(t, []byte("SEGMENT1 SEGMENT1 SEGMENT1"), key, seqIV(tt.FirstSeq+1))),
+		})
+		defer srv.Close()
+
+		stream, err := tt.GetStream(context.Background(), srv.URL+"/media.m3u8")
+		if err != nil {
+			t.Fatalf("%v: get stream: %v", tt.Name, err)
+		}
+		if stream.Video.MediaSequence != tt.FirstSeq {
+			t.Errorf("%v: expected media sequence %v, got %v", tt.Name, tt.FirstSeq, stream.Video.MediaSequence)
+		}
+
+		var got []string
+		if err := DownloadEpisodeStream(context.Background(), stream, 0, func(int) {},
+			func(r io.Reader, idx int) error {
+				data, err := io.ReadAll(r)
+				got = append(got, string(data))
+				return err
+			}, nil, nil,
+		); err != nil {
+			t.Fatalf("%v: DownloadEpisodeStream: %v", tt.Name, err)
+		}
+		want := []string{"SEGMENT0 SEGMENT0 SEGMENT0", "SEGMENT1 SEGMENT1 SEGMENT1"}
+		if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", want) {
+			t.Errorf("%v: expected segments %q, got %q", tt.Name, want, got)
+		}
+	}
+}